@@ -0,0 +1,287 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// version is the version of the program, set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// command is a subcommand of the command line, such as translate or status.
+type command struct {
+	// name is the name of the command, the first argument of the command line.
+	name string
+	// usage describes the arguments of the command, e.g. "[flags] <job>".
+	usage string
+	// summary is the one-line help of the command.
+	summary string
+	// description is the help of the command, printed by its -h flag.
+	description string
+	// subcommands are the accepted first arguments of the command, before its flags, if it has subcommands.
+	subcommands []string
+	// setup defines the flags of the command on fs and returns the function running the command.
+	// The function takes the arguments left after the flags, preceded by the subcommand if the command has subcommands.
+	setup func(fs *flag.FlagSet) func(args []string) error
+}
+
+// commands returns the commands of the command line, in the order of the help.
+func commands() []*command {
+	return []*command{
+		{
+			name:        "translate",
+			usage:       "[flags]",
+			summary:     "Translate a document or a folder to one or several languages",
+			description: "Translate the document or the folder given with -in to the languages given with -to, writing the translations to -out.\nThe flags may be given without the translate command.",
+			setup:       setupTranslate,
+		},
+		{
+			name:        "status",
+			usage:       "[flags] <job>",
+			summary:     "Print the status of a translation job",
+			description: "Print the status of a translation job of the Translator service, and of its documents with -documents.\nThe job is given by its batch ID, or by its local ID in the job store.",
+			setup:       setupStatus,
+		},
+		{
+			name:        "cancel",
+			usage:       "[flags] <job>",
+			summary:     "Cancel a translation job",
+			description: "Cancel a translation job of the Translator service. The documents already being translated are still translated and charged.\nThe job is given by its batch ID, or by its local ID in the job store.",
+			setup:       setupCancel,
+		},
+		{
+			name:        "resume",
+			usage:       "[flags] <job>",
+			summary:     "Resume an interrupted translation job",
+			description: "Resume a translation job of the job store interrupted before it completed: poll it, download its translations and delete its temporary blobs.",
+			setup:       setupResume,
+		},
+		{
+			name:        "jobs",
+			usage:       "list [flags]",
+			summary:     "List the translation jobs",
+			description: "List the translation jobs recorded in the job store, or the jobs of the Translator resource with -remote.",
+			subcommands: []string{"list"},
+			setup:       setupJobs,
+		},
+		{
+			name:        "languages",
+			usage:       "[flags]",
+			summary:     "List the languages supported for translation",
+			description: "List the languages supported for translation by the Translator service.",
+			setup:       setupLanguages,
+		},
+		{
+			name:        "formats",
+			usage:       "[flags]",
+			summary:     "List the supported document or glossary formats",
+			description: "List the document formats supported by the Translator service, or its glossary formats with -glossary.",
+			setup:       setupFormats,
+		},
+		{
+			name:        "gc",
+			usage:       "[flags]",
+			summary:     "Delete the orphaned temporary blobs",
+			description: "Delete the temporary blobs left in the container by the translation jobs that did not complete.",
+			setup:       setupGC,
+		},
+		{
+			name:        "config",
+			usage:       "show|validate|schema [flags] [file]",
+			summary:     "Show or validate the configuration",
+			description: "show prints the effective configuration and the source of each value, the secrets redacted.\nvalidate checks a configuration file, given as argument or with -config, and reports each error with its line.\nschema prints the JSON Schema of the configuration files.",
+			subcommands: []string{"show", "validate", "schema"},
+			setup:       setupConfig,
+		},
+		{
+			name:        "serve",
+			usage:       "[flags]",
+			summary:     "Serve the translations as a REST web service",
+			description: "Serve the translations as a REST web service until SIGINT or SIGTERM.",
+			setup:       setupServe,
+		},
+		{
+			name:        "completion",
+			usage:       "bash|zsh|fish",
+			summary:     "Print the shell completion script",
+			description: "Print the completion script of a shell. Load it with:\n  bash: source <(translator completion bash)\n  zsh:  source <(translator completion zsh)\n  fish: translator completion fish | source",
+			subcommands: []string{"bash", "zsh", "fish"},
+			setup:       setupCompletion,
+		},
+		{
+			name:        "version",
+			usage:       "",
+			summary:     "Print the version",
+			description: "Print the version of the program, its revision and the version of Go it was built with.",
+			setup:       setupVersion,
+		},
+	}
+}
+
+// findCommand returns the command of a name, nil if there is none.
+func findCommand(name string) *command {
+	for _, c := range commands() {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// programName returns the name the program was run with.
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// runCommand runs the command of the command line.
+// It takes the arguments of the command line, without the program name.
+// The translation flags may be given without the translate command, as before the commands existed.
+// It returns an error if the command is unknown or fails.
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return fmt.Errorf("missing command")
+	}
+	name := args[0]
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		if len(args) > 1 {
+			if c := findCommand(args[1]); c != nil {
+				c.flagSet().Usage()
+				return nil
+			}
+			return fmt.Errorf("unknown command %q", args[1])
+		}
+		printUsage(os.Stdout)
+		return nil
+	case strings.HasPrefix(name, "-"):
+		return findCommand("translate").execute(args)
+	}
+	c := findCommand(name)
+	if c == nil {
+		printUsage(os.Stderr)
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return c.execute(args[1:])
+}
+
+// printUsage prints the commands and their summary.
+func printUsage(out io.Writer) {
+	fmt.Fprintf(out, "Usage: %s <command> [flags]\n\nCommands:\n", programName())
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.summary)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\nRun \"%s help <command>\" for the flags of a command.\n", programName())
+}
+
+// flagSet returns a flag set holding the flags of the command, whose usage prints its help.
+func (c *command) flagSet() *flag.FlagSet {
+	fs, _ := c.define()
+	return fs
+}
+
+// define creates the flag set of the command and defines its flags, it returns the flag set and the function running the command.
+func (c *command) define() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	run := c.setup(fs)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s %s\n\n%s\n", programName(), c.name, c.usage, c.description)
+		if hasFlags(fs) {
+			fmt.Fprintln(out, "\nFlags:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs, run
+}
+
+// execute parses the arguments of the command and runs it.
+// It returns an error if the subcommand is missing or unknown, or if the command fails.
+func (c *command) execute(args []string) error {
+	fs, run := c.define()
+	if len(c.subcommands) == 0 {
+		fs.Parse(args)
+		return run(fs.Args())
+	}
+	if len(args) == 0 || !slices.Contains(c.subcommands, args[0]) {
+		// Let -h print the help
+		fs.Parse(args)
+		fs.Usage()
+		if len(args) == 0 {
+			return fmt.Errorf("missing %s command, expected %s", c.name, strings.Join(c.subcommands, ", "))
+		}
+		return fmt.Errorf("unknown %s command %q, expected %s", c.name, args[0], strings.Join(c.subcommands, ", "))
+	}
+	fs.Parse(args[1:])
+	return run(append([]string{args[0]}, fs.Args()...))
+}
+
+// hasFlags reports whether a flag set has flags.
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+	fs.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
+
+// expectArgs returns an error if a command was not given the expected number of arguments.
+func expectArgs(fs *flag.FlagSet, args []string, count int, name string) error {
+	if len(args) == count {
+		return nil
+	}
+	fs.Usage()
+	if count == 0 {
+		return fmt.Errorf("%s expects no argument, got %s", fs.Name(), strings.Join(args, " "))
+	}
+	return fmt.Errorf("%s expects %s", fs.Name(), name)
+}
+
+// setupVersion defines the version command, printing the version, the revision of the build and the version of Go.
+func setupVersion(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 0, ""); err != nil {
+			return err
+		}
+		fmt.Println(versionString())
+		return nil
+	}
+}
+
+// versionString returns the version of the program, followed by its revision if the build recorded it,
+// the version of Go and the platform.
+func versionString() string {
+	s := programName() + " " + version
+	if info, ok := debug.ReadBuildInfo(); ok {
+		settings := map[string]string{}
+		for _, setting := range info.Settings {
+			settings[setting.Key] = setting.Value
+		}
+		if revision := settings["vcs.revision"]; revision != "" {
+			if len(revision) > 12 {
+				revision = revision[:12]
+			}
+			if settings["vcs.modified"] == "true" {
+				revision += "-dirty"
+			}
+			s += " (" + revision
+			if t := settings["vcs.time"]; t != "" {
+				s += " " + t
+			}
+			s += ")"
+		}
+	}
+	return fmt.Sprintf("%s %s %s/%s", s, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
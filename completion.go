@@ -0,0 +1,201 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// completionFlag is a flag of a command, as the completion scripts describe it.
+type completionFlag struct {
+	name        string
+	description string
+	// boolean flags take no value.
+	boolean bool
+}
+
+// completionCommand is a command, as the completion scripts describe it.
+type completionCommand struct {
+	name        string
+	summary     string
+	subcommands []string
+	flags       []completionFlag
+}
+
+// completionCommands returns the commands and their flags for the completion scripts,
+// followed by the help command, whose subcommands are the commands.
+func completionCommands() []completionCommand {
+	list := []completionCommand{}
+	names := []string{}
+	for _, c := range commands() {
+		flags := []completionFlag{}
+		c.flagSet().VisitAll(func(f *flag.Flag) {
+			boolean := false
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+				boolean = b.IsBoolFlag()
+			}
+			flags = append(flags, completionFlag{name: f.Name, description: strings.ReplaceAll(f.Usage, "\n", " "), boolean: boolean})
+		})
+		list = append(list, completionCommand{name: c.name, summary: c.summary, subcommands: c.subcommands, flags: flags})
+		names = append(names, c.name)
+	}
+	return append(list, completionCommand{name: "help", summary: "Print the help of a command", subcommands: names})
+}
+
+// setupCompletion defines the completion command, printing the completion script of bash, zsh or fish.
+func setupCompletion(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if err := expectArgs(fs, args[1:], 0, ""); err != nil {
+			return err
+		}
+		return writeCompletion(os.Stdout, args[0], programName())
+	}
+}
+
+// writeCompletion writes the completion script of a shell for the program name.
+// The scripts complete the commands, their subcommands and their flags, and the file names otherwise.
+// It returns an error if the shell is not bash, zsh or fish.
+func writeCompletion(w io.Writer, shell, name string) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, name)
+	case "zsh":
+		writeZshCompletion(w, name)
+	case "fish":
+		writeFishCompletion(w, name)
+	default:
+		return fmt.Errorf("unknown shell %q, expected bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+// nonIdentifier matches the characters of a program name that cannot be part of a shell function name.
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// shellQuote quotes a string for the shells between single quotes.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// flagNames returns the names of the flags of a command, prefixed with a dash.
+func (c completionCommand) flagNames() []string {
+	names := make([]string, len(c.flags))
+	for i, f := range c.flags {
+		names[i] = "-" + f.name
+	}
+	return names
+}
+
+// writeBashCompletion writes the bash completion script.
+// A first word starting with a dash is completed with the flags of the translate command, the default command.
+func writeBashCompletion(w io.Writer, name string) {
+	function := "_" + nonIdentifier.ReplaceAllString(name, "_")
+	list := completionCommands()
+	names := []string{}
+	for _, c := range list {
+		names = append(names, c.name)
+	}
+	fmt.Fprintf(w, "# bash completion for %s, load it with: source <(%s completion bash)\n", name, name)
+	fmt.Fprintf(w, "%s() {\n", function)
+	fmt.Fprintf(w, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\" command=\"${COMP_WORDS[1]}\" flags=\"\" subcommands=\"\"\n")
+	fmt.Fprintf(w, "    if [ \"$COMP_CWORD\" -eq 1 ] && [[ \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(names, " ")))
+	fmt.Fprintf(w, "        return\n    fi\n")
+	fmt.Fprintf(w, "    case \"$command\" in\n")
+	for _, c := range list {
+		pattern := c.name
+		if c.name == "translate" {
+			pattern = "translate|-*"
+		}
+		fmt.Fprintf(w, "        %s) flags=%s subcommands=%s ;;\n", pattern,
+			shellQuote(strings.Join(c.flagNames(), " ")), shellQuote(strings.Join(c.subcommands, " ")))
+	}
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "    if [ \"$COMP_CWORD\" -eq 2 ] && [ -n \"$subcommands\" ]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W \"$subcommands\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "    elif [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "    fi\n}\n")
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", function, name)
+}
+
+// writeZshCompletion writes the zsh completion script, which describes the commands and the flags.
+func writeZshCompletion(w io.Writer, name string) {
+	function := "_" + nonIdentifier.ReplaceAllString(name, "_")
+	list := completionCommands()
+	fmt.Fprintf(w, "#compdef %s\n# zsh completion for %s, load it with: source <(%s completion zsh)\n", name, name, name)
+	fmt.Fprintf(w, "%s() {\n", function)
+	fmt.Fprintf(w, "    local -a commands subcommands flags\n")
+	fmt.Fprintf(w, "    commands=(\n")
+	for _, c := range list {
+		fmt.Fprintf(w, "        %s\n", shellQuote(c.name+":"+c.summary))
+	}
+	fmt.Fprintf(w, "    )\n")
+	fmt.Fprintf(w, "    if (( CURRENT == 2 )) && [[ $PREFIX != -* ]]; then\n")
+	fmt.Fprintf(w, "        _describe -t commands 'command' commands\n")
+	fmt.Fprintf(w, "        return\n    fi\n")
+	fmt.Fprintf(w, "    case $words[2] in\n")
+	for _, c := range list {
+		pattern := c.name
+		if c.name == "translate" {
+			pattern = "translate|-*"
+		}
+		subcommands := make([]string, len(c.subcommands))
+		for i, subcommand := range c.subcommands {
+			subcommands[i] = shellQuote(subcommand)
+		}
+		flags := make([]string, len(c.flags))
+		for i, f := range c.flags {
+			flags[i] = shellQuote("-" + f.name + ":" + f.description)
+		}
+		fmt.Fprintf(w, "        %s)\n", pattern)
+		fmt.Fprintf(w, "            subcommands=(%s)\n", strings.Join(subcommands, " "))
+		fmt.Fprintf(w, "            flags=(\n")
+		for _, f := range flags {
+			fmt.Fprintf(w, "                %s\n", f)
+		}
+		fmt.Fprintf(w, "            )\n            ;;\n")
+	}
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "    if (( CURRENT == 3 )) && (( ${#subcommands} )); then\n")
+	fmt.Fprintf(w, "        _describe -t subcommands 'subcommand' subcommands\n")
+	fmt.Fprintf(w, "    elif [[ $PREFIX == -* ]]; then\n")
+	fmt.Fprintf(w, "        _describe -t flags 'flag' flags\n")
+	fmt.Fprintf(w, "    else\n        _files\n    fi\n}\n")
+	fmt.Fprintf(w, "if [ \"$funcstack[1]\" = %s ]; then\n    %s \"$@\"\nelse\n    compdef %s %s\nfi\n", shellQuote(function), function, function, name)
+}
+
+// writeFishCompletion writes the fish completion script, which describes the commands and the flags.
+// The flags of the translate command are also completed before any command, translate being the default command.
+func writeFishCompletion(w io.Writer, name string) {
+	list := completionCommands()
+	fmt.Fprintf(w, "# fish completion for %s, load it with: %s completion fish | source\n", name, name)
+	for _, c := range list {
+		fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand -a %s -d %s\n", name, c.name, shellQuote(c.summary))
+	}
+	for _, c := range list {
+		condition := "__fish_seen_subcommand_from " + c.name
+		if len(c.subcommands) > 0 {
+			fmt.Fprintf(w, "complete -c %s -f -n %s -a %s\n", name,
+				shellQuote(condition+"; and not __fish_seen_subcommand_from "+strings.Join(c.subcommands, " ")),
+				shellQuote(strings.Join(c.subcommands, " ")))
+		}
+		if c.name == "translate" {
+			condition = "__fish_use_subcommand; or " + condition
+		}
+		for _, f := range c.flags {
+			requires := " -r"
+			if f.boolean {
+				requires = ""
+			}
+			fmt.Fprintf(w, "complete -c %s -n %s -o %s%s -d %s\n", name, shellQuote(condition), f.name, requires, shellQuote(f.description))
+		}
+	}
+}
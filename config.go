@@ -0,0 +1,459 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"translator/internal/translator"
+)
+
+// setting is a configuration value, identified by its key in the configuration file,
+// which the environment and the command line may also set.
+type setting struct {
+	// key is the path of the value in the configuration file, e.g. sas.expiry.
+	key string
+	// kind is the type of the value in the configuration file, one of the kind constants.
+	kind string
+	// env is the environment variable setting the value, if any.
+	env string
+	// flag is the command-line flag setting the value, if any.
+	flag string
+	// secret values are redacted by config show.
+	secret bool
+	// values are the accepted values, any if empty.
+	values []string
+	// minimum and maximum bound the integers, the numbers and the durations, in seconds, if not nil.
+	minimum, maximum *float64
+	// description documents the value in the JSON Schema of the configuration file.
+	description string
+}
+
+// The kinds of the values of the configuration file.
+const (
+	kindString   = "string"
+	kindInteger  = "integer"
+	kindNumber   = "number"
+	kindBoolean  = "boolean"
+	kindDuration = "duration"
+)
+
+// bound returns a pointer to a bound of a setting.
+func bound(value float64) *float64 {
+	return &value
+}
+
+// categoriesKey is the key of the Custom Translator categories, whose values are keyed by language: categories.<language>.
+const categoriesKey = "categories"
+
+// settings are the configuration values of the command line, in the order config show prints them.
+var settings = []setting{
+	{key: "translatorEndpoint", kind: kindString, env: envTranslatorEndpoint, flag: "endpoint", description: "Azure Translator API endpoint"},
+	{key: "translatorKey", kind: kindString, env: envTranslatorKey, flag: "key", secret: true, description: "Azure Translator API key, or a file:, env: or cmd: secret reference"},
+	{key: "translatorRegion", kind: kindString, env: envTranslatorRegion, flag: "region", description: "Azure region of the Translator resource"},
+	{key: "blobAccountName", kind: kindString, env: envBlobAccount, flag: "blobAccount", description: "Azure Blob Storage account name"},
+	{key: "blobAccountKey", kind: kindString, env: envBlobAccountKey, flag: "blobAccountKey", secret: true, description: "Azure Blob Storage account key, or a file:, env: or cmd: secret reference"},
+	{key: "blobContainerName", kind: kindString, env: envBlobContainer, flag: "blobContainer", description: "Azure Blob Storage container name"},
+	{key: "blobServiceUrl", kind: kindString, env: envBlobServiceURL, flag: "blobServiceUrl", description: "Azure Blob Storage service URL, https://<blobAccountName>.blob.core.windows.net if empty"},
+	{key: "credential.type", kind: kindString, flag: "credential", description: "Microsoft Entra ID credential used instead of the keys",
+		values: []string{"", translator.CredentialKey, translator.CredentialClientSecret, translator.CredentialClientCertificate,
+			translator.CredentialWorkloadIdentity, translator.CredentialManagedIdentity, translator.CredentialAzureCLI}},
+	{key: "credential.tenantId", kind: kindString, env: envTenantID, flag: "tenantId", description: "Microsoft Entra ID tenant of the application"},
+	{key: "credential.clientId", kind: kindString, env: envClientID, flag: "clientId", description: "Client ID of the application or of the user-assigned managed identity"},
+	{key: "credential.clientSecret", kind: kindString, env: envClientSecret, secret: true, description: "Client secret of the application, or a file:, env: or cmd: secret reference"},
+	{key: "credential.certificatePath", kind: kindString, env: envClientCertificate, flag: "clientCertificate", description: "PEM or PKCS#12 file of the certificate of the application"},
+	{key: "credential.certificatePassword", kind: kindString, env: envCertificatePassword, secret: true, description: "Password of the certificate file, or a file:, env: or cmd: secret reference"},
+	{key: "credential.tokenFilePath", kind: kindString, description: "Service account token file of the workload identity"},
+	{key: "credential.authorityHost", kind: kindString, env: envAuthorityHost, description: "Microsoft Entra ID host of a sovereign cloud"},
+	{key: "credential.scope", kind: kindString, description: "Scope of the tokens of the Translator service, derived from the authority host if empty"},
+	{key: "timeout", kind: kindInteger, flag: "timeout", minimum: bound(0), description: "Timeout in seconds"},
+	{key: "verbose", kind: kindBoolean, flag: "v", description: "Enable verbose logging"},
+	{key: "syncThreshold", kind: kindInteger, flag: "syncThreshold", minimum: bound(0), description: "File size in bytes up to which documents are translated synchronously, 0 to disable"},
+	{key: "keepBlobs", kind: kindBoolean, flag: "keep-blobs", description: "Keep the temporary blobs of the jobs in Azure Blob Storage instead of deleting them"},
+	{key: "jobStore", kind: kindString, flag: "jobStore", description: "JSON file recording the translation jobs so that they can be resumed, empty to disable"},
+	{key: "transfer.blockSize", kind: kindInteger, flag: "blockSize", minimum: bound(0), maximum: bound(translator.MaxBlockSize), description: "Size in bytes of the blocks uploaded to and downloaded from Azure Blob Storage"},
+	{key: "transfer.parallelism", kind: kindInteger, flag: "parallelism", minimum: bound(0), maximum: bound(translator.MaxParallelism), description: "Number of blocks transferred in parallel"},
+	{key: "retry.maxAttempts", kind: kindInteger, minimum: bound(0), description: "Number of attempts of an operation including the first one, 1 disables the retries"},
+	{key: "retry.baseDelay", kind: kindDuration, minimum: bound(0), description: "Delay before the first retry"},
+	{key: "retry.maxDelay", kind: kindDuration, minimum: bound(0), description: "Maximum delay between two attempts"},
+	{key: "retry.jitter", kind: kindNumber, minimum: bound(0), maximum: bound(1), description: "Fraction of each delay, between 0 and 1, that is randomized, 0 to disable the jitter"},
+	{key: "sas.expiry", kind: kindDuration, flag: "sasExpiry", minimum: bound(0), description: "Lifetime of the SAS tokens given to the Translator service, the timeout plus 15m if zero"},
+	{key: "sas.ipRange", kind: kindString, flag: "sasIpRange", description: "IP address or range start-end allowed to use the SAS tokens"},
+	{key: categoriesKey, kind: kindString, flag: "category", description: "Custom Translator category ID per target language"},
+}
+
+// findSetting returns the setting of a key, the categories setting for the keys of the categories.
+func findSetting(key string) (int, *setting) {
+	if strings.HasPrefix(key, categoriesKey+".") {
+		key = categoriesKey
+	}
+	for i := range settings {
+		if settings[i].key == key {
+			return i, &settings[i]
+		}
+	}
+	return -1, nil
+}
+
+// cliConfig is the configuration of the command line: the translator configuration and the settings of the CLI itself.
+type cliConfig struct {
+	translator.TranslatorConfig
+	// JobStore is the JSON file recording the translation jobs, empty to disable.
+	JobStore string `json:"jobStore"`
+}
+
+// configValue is a configuration value in JSON and the source setting it.
+type configValue struct {
+	value  json.RawMessage
+	source string
+}
+
+// configLayer holds the values set by a source of configuration, by key.
+type configLayer map[string]configValue
+
+// layeredConfig is the configuration merged from its layers, by key.
+// Each value comes from the last layer setting it: defaults < configuration file < profile < environment < flags.
+type layeredConfig struct {
+	values configLayer
+}
+
+// mergeLayers merges configuration layers, the later ones overriding the earlier ones.
+func mergeLayers(layers ...configLayer) *layeredConfig {
+	merged := configLayer{}
+	for _, layer := range layers {
+		for key, value := range layer {
+			merged[key] = value
+		}
+	}
+	return &layeredConfig{values: merged}
+}
+
+// keys returns the keys of the configuration in the order of the settings, the categories sorted by language.
+func (c *layeredConfig) keys() []string {
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := findSetting(keys[i])
+		b, _ := findSetting(keys[j])
+		if a != b {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// decode decodes the merged configuration into v, as if it were a configuration file.
+func (c *layeredConfig) decode(v interface{}) error {
+	tree := map[string]interface{}{}
+	for key, value := range c.values {
+		node := tree
+		path := strings.Split(key, ".")
+		for _, name := range path[:len(path)-1] {
+			child, ok := node[name].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[name] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = value.value
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// flatten adds the leaves of a decoded JSON value to layer, keyed by their path, if they are settings.
+// It returns the keys that are not settings.
+func flatten(layer configLayer, prefix string, value interface{}, source string) []string {
+	if object, ok := value.(map[string]interface{}); ok {
+		unknown := []string{}
+		for name, child := range object {
+			key := name
+			if prefix != "" {
+				key = prefix + "." + name
+			}
+			unknown = append(unknown, flatten(layer, key, child, source)...)
+		}
+		return unknown
+	}
+	_, s := findSetting(prefix)
+	if s == nil || prefix == categoriesKey {
+		return []string{prefix}
+	}
+	// The languages of the categories are case-insensitive, they are keyed in lower case like those of the flag.
+	if language, ok := strings.CutPrefix(prefix, categoriesKey+"."); ok {
+		prefix = categoriesKey + "." + strings.ToLower(language)
+	}
+	// An integer may be written as an integral float, such as 1e3, which does not decode into an int.
+	if n, ok := numberValue(value); ok && s.kind == kindInteger && n == math.Trunc(n) {
+		value = int64(n)
+	}
+	data, _ := json.Marshal(value)
+	layer[prefix] = configValue{value: data, source: source}
+	return nil
+}
+
+// defaultsLayer returns the default values of the settings.
+func defaultsLayer() configLayer {
+	defaults := cliConfig{
+		TranslatorConfig: translator.TranslatorConfig{
+			Timeout:  30,
+			Retry:    translator.DefaultRetryPolicy,
+			Transfer: translator.TransferOptions{BlockSize: translator.DefaultBlockSize, Parallelism: translator.DefaultParallelism},
+		},
+		JobStore: defaultJobStorePath(),
+	}
+	var tree interface{}
+	data, _ := json.Marshal(defaults)
+	json.Unmarshal(data, &tree)
+	layer := configLayer{}
+	flatten(layer, "", tree, "default")
+	return layer
+}
+
+// fileLayers returns the values of a configuration file, and the values of one of its profiles if profile is not empty.
+// The format of the file is chosen by its extension, see parseConfigFile, and the file is checked strictly, see validate.
+// The profiles are the objects of the "profiles" object of the file, they override the values of the file.
+// It returns an error if the file cannot be decoded or is invalid, the profile does not exist, or the file references
+// secrets but is readable by its group or others.
+func fileLayers(path, profile string) (configLayer, configLayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := parseConfigFile(path, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding configuration file %s: %v", path, err)
+	}
+	if errs := doc.validate(); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		return nil, nil, fmt.Errorf("invalid configuration file %s: %s", path, strings.Join(messages, "; "))
+	}
+	tree := doc.tree
+
+	profiles, _ := tree[profilesKey].(map[string]interface{})
+	base := configLayer{}
+	for name, value := range tree {
+		if name != profilesKey && name != schemaKey {
+			flatten(base, name, value, "file "+path)
+		}
+	}
+	selected := configLayer{}
+	if profile != "" {
+		values, ok := profiles[profile].(map[string]interface{})
+		if !ok {
+			names := make([]string, 0, len(profiles))
+			for name := range profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, nil, fmt.Errorf("no profile %q in %s, the profiles are: %s", profile, path, strings.Join(names, ", "))
+		}
+		flatten(selected, "", values, fmt.Sprintf("profile %s of %s", profile, path))
+	}
+
+	// A readable file could let others learn where the secrets are and how to get them.
+	references := []string{}
+	for _, layer := range []configLayer{base, selected} {
+		for key, value := range layer {
+			var s string
+			if _, setting := findSetting(key); setting.secret && json.Unmarshal(value.value, &s) == nil && translator.IsSecretReference(s) {
+				references = append(references, key)
+			}
+		}
+	}
+	if len(references) > 0 {
+		if err := checkConfigPermissions(file); err != nil {
+			sort.Strings(references)
+			return nil, nil, fmt.Errorf("%v, refusing its secret references (%s)", err, strings.Join(references, ", "))
+		}
+	}
+	return base, selected, nil
+}
+
+// envLayer returns the values of the settings set by non-empty environment variables.
+func envLayer() configLayer {
+	layer := configLayer{}
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if value := os.Getenv(s.env); value != "" {
+			data, _ := json.Marshal(value)
+			layer[s.key] = configValue{value: data, source: "env " + s.env}
+		}
+	}
+	return layer
+}
+
+// flagLayer returns the values of the settings set by the flags given on the command line of fs.
+// The flags left to their default value do not override the other layers.
+// It returns an error if the categories are malformed.
+func flagLayer(fs *flag.FlagSet) (configLayer, error) {
+	flagKeys := map[string]string{}
+	for _, s := range settings {
+		if s.flag != "" {
+			flagKeys[s.flag] = s.key
+		}
+	}
+	layer := configLayer{}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		key, ok := flagKeys[f.Name]
+		if !ok {
+			return
+		}
+		source := "flag -" + f.Name
+		if key == categoriesKey {
+			categories, parseErr := parseCategories(f.Value.String())
+			if parseErr != nil {
+				err = parseErr
+			}
+			for language, category := range categories {
+				data, _ := json.Marshal(category)
+				layer[categoriesKey+"."+language] = configValue{value: data, source: source}
+			}
+			return
+		}
+		value := f.Value.(flag.Getter).Get()
+		if d, ok := value.(time.Duration); ok {
+			value = translator.Duration(d)
+		}
+		data, _ := json.Marshal(value)
+		layer[key] = configValue{value: data, source: source}
+	})
+	return layer, err
+}
+
+// load merges the configuration layers of the service flags: the defaults, the configuration file and its profile,
+// the environment and the flags given on the command line.
+func (f *serviceFlags) load() (*layeredConfig, error) {
+	layers := []configLayer{defaultsLayer()}
+	if *f.configFile != "" {
+		base, profile, err := fileLayers(*f.configFile, *f.profile)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, base, profile)
+	} else if *f.profile != "" {
+		return nil, fmt.Errorf("-profile requires a configuration file, set -config")
+	}
+	flags, err := flagLayer(f.fs)
+	if err != nil {
+		return nil, err
+	}
+	return mergeLayers(append(layers, envLayer(), flags)...), nil
+}
+
+// setupConfig defines the config command and its subcommands:
+// show prints the effective configuration, validate checks a configuration file and schema prints the JSON Schema of the configuration files.
+func setupConfig(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, allServiceFlags)
+	return func(args []string) error {
+		switch args[0] {
+		case "show":
+			if err := expectArgs(fs, args[1:], 0, ""); err != nil {
+				return err
+			}
+			layered, err := service.load()
+			if err != nil {
+				return err
+			}
+			printConfig(os.Stdout, layered)
+			return nil
+		case "validate":
+			path := *service.configFile
+			if len(args) > 1 {
+				if err := expectArgs(fs, args[1:], 1, "one configuration file"); err != nil {
+					return err
+				}
+				path = args[1]
+			}
+			if path == "" {
+				return fmt.Errorf("config validate requires a configuration file")
+			}
+			return validateConfigFile(os.Stdout, path)
+		}
+		if err := expectArgs(fs, args[1:], 0, ""); err != nil {
+			return err
+		}
+		return writeConfigSchema(os.Stdout)
+	}
+}
+
+// validateConfigFile checks a configuration file and prints each of its errors as path:line: message.
+// It returns an error if the file cannot be read or is invalid.
+func validateConfigFile(out io.Writer, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	errs := []*configError{}
+	doc, err := parseConfigFile(path, data)
+	var syntaxErr *configError
+	switch {
+	case errors.As(err, &syntaxErr):
+		errs = append(errs, syntaxErr)
+	case err != nil:
+		return err
+	default:
+		errs = doc.validate()
+	}
+	if len(errs) == 0 {
+		fmt.Fprintf(out, "%s is valid\n", path)
+		return nil
+	}
+	for _, err := range errs {
+		fmt.Fprintf(out, "%s:%d: %s\n", path, err.Line, err.Message)
+	}
+	return fmt.Errorf("configuration file %s has %d errors", path, len(errs))
+}
+
+// printConfig prints each value of the configuration with its source, the secrets redacted.
+// The secret references are printed, they do not reveal the secrets and are not resolved.
+func printConfig(out io.Writer, layered *layeredConfig) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, key := range layered.keys() {
+		value := layered.values[key]
+		text := string(value.value)
+		var s string
+		if json.Unmarshal(value.value, &s) == nil {
+			text = s
+			if _, setting := findSetting(key); setting.secret && s != "" && !translator.IsSecretReference(s) {
+				text = "REDACTED"
+			}
+		}
+		if bytes.Equal(value.value, []byte("null")) {
+			text = ""
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key, text, value.source)
+	}
+	w.Flush()
+}
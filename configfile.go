@@ -0,0 +1,479 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The keys of the configuration files that are not settings.
+const (
+	// profilesKey holds the named profiles of a configuration file, each overriding its settings.
+	profilesKey = "profiles"
+	// schemaKey references the JSON Schema of the configuration file, for the editors.
+	schemaKey = "$schema"
+)
+
+// configError is an error of a configuration file, at a line of the file if Line is not zero.
+type configError struct {
+	Line    int
+	Message string
+}
+
+func (e *configError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// configDocument is a decoded configuration file: its values, and the line of each key.
+type configDocument struct {
+	tree  map[string]interface{}
+	lines map[string]int
+}
+
+// line returns the line of a key, or of its closest parent whose line is known, 0 if none is.
+func (d *configDocument) line(key string) int {
+	for key != "" {
+		if line, ok := d.lines[key]; ok {
+			return line
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
+
+// joinKey returns the key of the child name of the object prefix.
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// parseConfigFile decodes a configuration file in the format of its extension: .json, .yaml, .yml or .toml.
+// It takes the following parameters:
+// - path: The path of the configuration file, only its extension is used.
+// - data: The content of the configuration file.
+// It returns a *configError locating the syntax error if the file cannot be decoded.
+func parseConfigFile(path string, data []byte) (*configDocument, error) {
+	doc := &configDocument{tree: map[string]interface{}{}, lines: map[string]int{}}
+	var err error
+	switch extension := strings.ToLower(filepath.Ext(path)); extension {
+	case ".json":
+		err = doc.parseJSON(data)
+	case ".yaml", ".yml":
+		err = doc.parseYAML(data)
+	case ".toml":
+		err = doc.parseTOML(data)
+	default:
+		return nil, fmt.Errorf("unknown configuration file format %q, expected .json, .yaml, .yml or .toml", extension)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// parseJSON decodes a JSON configuration file, recording the line of each key.
+func (d *configDocument) parseJSON(data []byte) error {
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+	// The syntax errors of the tokens are reported where the tokens start, check the whole syntax first
+	var syntaxErr *json.SyntaxError
+	if err := json.Unmarshal(data, new(interface{})); errors.As(err, &syntaxErr) {
+		return &configError{Line: lineAt(syntaxErr.Offset), Message: syntaxErr.Error()}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var parse func(prefix string) (interface{}, error)
+	parse = func(prefix string) (interface{}, error) {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token {
+		case json.Delim('{'):
+			object := map[string]interface{}{}
+			for decoder.More() {
+				token, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				name := token.(string)
+				key := joinKey(prefix, name)
+				line := lineAt(decoder.InputOffset())
+				if _, ok := object[name]; ok {
+					return nil, &configError{Line: line, Message: fmt.Sprintf("duplicate key %q", key)}
+				}
+				d.lines[key] = line
+				if object[name], err = parse(key); err != nil {
+					return nil, err
+				}
+			}
+			_, err := decoder.Token()
+			return object, err
+		case json.Delim('['):
+			array := []interface{}{}
+			for decoder.More() {
+				value, err := parse(prefix)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			}
+			_, err := decoder.Token()
+			return array, err
+		}
+		return token, nil
+	}
+
+	value, err := parse("")
+	if err == nil {
+		if _, err = decoder.Token(); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = &configError{Line: lineAt(decoder.InputOffset()), Message: "unexpected data after the top-level object"}
+		}
+	}
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return &configError{Line: lineAt(int64(len(data))), Message: "unexpected end of file"}
+	case err != nil:
+		return err
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return &configError{Line: 1, Message: "the configuration must be an object"}
+	}
+	d.tree = object
+	return nil
+}
+
+// yamlErrorLine matches the line of the YAML syntax errors.
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// parseYAML decodes a YAML configuration file, recording the line of each key.
+func (d *configDocument) parseYAML(data []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			return &configError{Line: line, Message: strings.TrimPrefix(err.Error(), match[0])}
+		}
+		return &configError{Message: err.Error()}
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+	var convert func(prefix string, node *yaml.Node) (interface{}, error)
+	convert = func(prefix string, node *yaml.Node) (interface{}, error) {
+		switch node.Kind {
+		case yaml.AliasNode:
+			return convert(prefix, node.Alias)
+		case yaml.MappingNode:
+			object := map[string]interface{}{}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				name := node.Content[i].Value
+				key := joinKey(prefix, name)
+				d.lines[key] = node.Content[i].Line
+				value, err := convert(key, node.Content[i+1])
+				if err != nil {
+					return nil, err
+				}
+				object[name] = value
+			}
+			return object, nil
+		case yaml.SequenceNode:
+			array := []interface{}{}
+			for _, child := range node.Content {
+				value, err := convert(prefix, child)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			}
+			return array, nil
+		}
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, &configError{Line: node.Line, Message: err.Error()}
+		}
+		return value, nil
+	}
+	value, err := convert("", root.Content[0])
+	if err != nil {
+		return err
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return &configError{Line: root.Content[0].Line, Message: "the configuration must be a mapping"}
+	}
+	d.tree = object
+	return nil
+}
+
+// tomlErrorPrefix matches the prefix of the TOML syntax errors, holding their line.
+var tomlErrorPrefix = regexp.MustCompile(`^toml: line \d+( \(last key "[^"]*"\))?: `)
+
+// parseTOML decodes a TOML configuration file, recording the line of each key and table.
+// The keys of the inline tables take the line of their table.
+func (d *configDocument) parseTOML(data []byte) error {
+	if _, err := toml.Decode(string(data), &d.tree); err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			return &configError{Line: parseErr.Position.Line, Message: tomlErrorPrefix.ReplaceAllString(parseErr.Error(), "")}
+		}
+		return &configError{Message: err.Error()}
+	}
+	tomlKey := func(s string) string {
+		parts := strings.Split(s, ".")
+		for i, part := range parts {
+			parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+		}
+		return strings.Join(parts, ".")
+	}
+	table := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		key := ""
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[["):
+			continue
+		case strings.HasPrefix(line, "["):
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+			table = tomlKey(line[1:end])
+			key = table
+		default:
+			name, _, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			key = joinKey(table, tomlKey(name))
+		}
+		// A line of a multi-line string may look like a key, keep the first line of each key
+		if _, ok := d.lines[key]; !ok {
+			d.lines[key] = i + 1
+		}
+	}
+	return nil
+}
+
+// isSection reports whether key is an object of the configuration file holding settings, such as sas.
+func isSection(key string) bool {
+	if key == categoriesKey {
+		return true
+	}
+	for _, s := range settings {
+		if strings.HasPrefix(s.key, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the configuration file strictly: every key must be a setting, the profiles key or the $schema key,
+// and every value must have the type of its setting, within its bounds. The profiles are checked like the file.
+// It returns the errors sorted by line, none if the file is valid.
+func (d *configDocument) validate() []*configError {
+	errs := []*configError{}
+	for name, value := range d.tree {
+		switch name {
+		case schemaKey:
+			if _, ok := value.(string); !ok {
+				errs = append(errs, d.errorf(name, "%s must be a string", name))
+			}
+		case profilesKey:
+			profiles, ok := value.(map[string]interface{})
+			if !ok {
+				errs = append(errs, d.errorf(name, "%s must be an object of profiles", name))
+				continue
+			}
+			for profile, values := range profiles {
+				path := joinKey(profilesKey, profile)
+				object, ok := values.(map[string]interface{})
+				if !ok {
+					errs = append(errs, d.errorf(path, "profile %s must be an object", profile))
+					continue
+				}
+				for name, value := range object {
+					errs = append(errs, d.validateValue(joinKey(path, name), name, value)...)
+				}
+			}
+		default:
+			errs = append(errs, d.validateValue(name, name, value)...)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Message < errs[j].Message
+	})
+	return errs
+}
+
+// validateValue checks a value of the configuration file against its setting.
+// It takes the following parameters:
+// - path: The key of the value in the file, including its profile.
+// - key: The key of the setting of the value.
+// - value: The decoded value.
+// It returns the errors of the value and of its children.
+func (d *configDocument) validateValue(path, key string, value interface{}) []*configError {
+	_, s := findSetting(key)
+	if object, ok := value.(map[string]interface{}); ok {
+		if !isSection(key) {
+			if s == nil {
+				return []*configError{d.errorf(path, "unknown key %q", path)}
+			}
+			return []*configError{d.errorf(path, "%s must be %s, not an object", path, kindName(s))}
+		}
+		errs := []*configError{}
+		for name, child := range object {
+			errs = append(errs, d.validateValue(joinKey(path, name), joinKey(key, name), child)...)
+		}
+		return errs
+	}
+	if isSection(key) {
+		return []*configError{d.errorf(path, "%s must be an object", path)}
+	}
+	if s == nil {
+		return []*configError{d.errorf(path, "unknown key %q", path)}
+	}
+	if !matchesKind(s, value) {
+		return []*configError{d.errorf(path, "%s must be %s", path, kindName(s))}
+	}
+	if message := checkBounds(s, value); message != "" {
+		return []*configError{d.errorf(path, "%s must be %s", path, message)}
+	}
+	return nil
+}
+
+// errorf returns an error at the line of key.
+func (d *configDocument) errorf(key, format string, args ...interface{}) *configError {
+	return &configError{Line: d.line(key), Message: fmt.Sprintf(format, args...)}
+}
+
+// kindName describes the values accepted by a setting, e.g. "an integer".
+func kindName(s *setting) string {
+	switch {
+	case len(s.values) > 0:
+		quoted := []string{}
+		for _, value := range s.values {
+			if value != "" {
+				quoted = append(quoted, strconv.Quote(value))
+			}
+		}
+		return "one of " + strings.Join(quoted, ", ")
+	case s.kind == kindInteger:
+		return "an integer"
+	case s.kind == kindDuration:
+		return `a duration such as "30s"`
+	}
+	return "a " + s.kind
+}
+
+// matchesKind reports whether a decoded value has the kind of its setting.
+// The numbers are json.Number in JSON, int in YAML and int64 in TOML.
+func matchesKind(s *setting, value interface{}) bool {
+	switch s.kind {
+	case kindString:
+		text, ok := value.(string)
+		if !ok || len(s.values) == 0 {
+			return ok
+		}
+		for _, accepted := range s.values {
+			if text == accepted {
+				return true
+			}
+		}
+		return false
+	case kindDuration:
+		text, ok := value.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.ParseDuration(text)
+		return err == nil
+	case kindBoolean:
+		_, ok := value.(bool)
+		return ok
+	case kindInteger, kindNumber:
+		number, ok := numberValue(value)
+		return ok && (s.kind == kindNumber || number == math.Trunc(number))
+	}
+	return false
+}
+
+// numberValue returns the value of a decoded number, it reports whether value is a number.
+// The numbers are json.Number in JSON, int or float64 in YAML and int64 or float64 in TOML.
+func numberValue(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case int:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case uint64:
+		return float64(number), true
+	case float64:
+		return number, true
+	case json.Number:
+		n, err := number.Float64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// checkBounds checks a decoded value of the kind of its setting against the bounds of the setting, in seconds for a duration.
+// It returns the range the value must be in, such as "between 0 and 1", or an empty string if the value is within the bounds.
+func checkBounds(s *setting, value interface{}) string {
+	var n float64
+	if s.kind == kindDuration {
+		d, _ := time.ParseDuration(value.(string))
+		n = d.Seconds()
+	} else if number, ok := numberValue(value); ok {
+		n = number
+	} else {
+		return ""
+	}
+	format := func(bound float64) string {
+		if s.kind == kindDuration {
+			return time.Duration(bound * float64(time.Second)).String()
+		}
+		return strconv.FormatFloat(bound, 'f', -1, 64)
+	}
+	switch {
+	case s.minimum != nil && s.maximum != nil && (n < *s.minimum || n > *s.maximum):
+		return fmt.Sprintf("between %s and %s", format(*s.minimum), format(*s.maximum))
+	case s.minimum != nil && n < *s.minimum:
+		return "at least " + format(*s.minimum)
+	case s.maximum != nil && n > *s.maximum:
+		return "at most " + format(*s.maximum)
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"translator/internal/translator"
+
+	"github.com/sirupsen/logrus"
+)
+
+// setupGC defines the gc command, deleting the orphaned temporary blobs of the translation jobs.
+// The command returns an error if the container cannot be listed or some blobs could not be deleted.
+func setupGC(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, storageFlags|jobStoreFlags)
+	olderThan := fs.Duration("olderThan", translator.DefaultGCMinAge, "Only delete the blobs last modified before this age (e.g. 12h)")
+	dryRun := fs.Bool("dryRun", false, "List the blobs that would be deleted without deleting them")
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 0, ""); err != nil {
+			return err
+		}
+		return collectGarbage(service, *olderThan, *dryRun)
+	}
+}
+
+// collectGarbage deletes the orphaned temporary blobs last modified before olderThan, or only lists them if dryRun is set.
+func collectGarbage(service *serviceFlags, olderThan time.Duration, dryRun bool) error {
+	log := logrus.New()
+	log.SetOutput(os.Stdout)
+
+	config, err := service.config(log)
+	if err != nil {
+		return err
+	}
+	missingArgs := []string{}
+	if config.BlobAccountName == "" {
+		missingArgs = append(missingArgs, "blobAccount")
+	}
+	if config.BlobAccountKey == "" && config.Credential.UsesKeys() {
+		missingArgs = append(missingArgs, "blobAccountKey")
+	}
+	if config.BlobContainerName == "" {
+		missingArgs = append(missingArgs, "blobContainer")
+	}
+	if len(missingArgs) > 0 {
+		return fmt.Errorf("missing required arguments: %s", strings.Join(missingArgs, ", "))
+	}
+
+	if redactor != nil {
+		log.AddHook(redactor)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := translator.CollectGarbage(ctx, config, translator.GCOptions{MinAge: olderThan, DryRun: dryRun})
+	if report != nil {
+		printGCReport(report, dryRun)
+	}
+	return err
+}
+
+// printGCReport prints the blobs deleted by a garbage collection as a table, followed by a summary.
+func printGCReport(report *translator.GCReport, dryRun bool) {
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	if len(report.Deleted) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "BLOB\tSIZE\tLAST MODIFIED")
+		for _, blob := range report.Deleted {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", blob.Name, formatBytes(blob.Size), blob.LastModified.Local().Format(time.DateTime))
+		}
+		w.Flush()
+	}
+	fmt.Printf("%s %d blobs (%s) of %d scanned, kept %d recent and %d of unfinished jobs, %d failed\n",
+		verb, len(report.Deleted), formatBytes(report.Bytes), report.Scanned, report.Recent, report.Active, len(report.Errors))
+}
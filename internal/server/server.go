@@ -0,0 +1,539 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+// Package server exposes the translator package as a REST web service,
+// so that clients without Azure credentials can translate documents through a shared, centrally configured instance.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"translator/internal/translator"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxUploadSize is the maximum size in bytes of an uploaded document when Options.MaxUploadSize is zero.
+	DefaultMaxUploadSize = 100 << 20
+	// DefaultRetention is the time a finished job and its documents are kept when Options.Retention is zero.
+	DefaultRetention = 24 * time.Hour
+	// DefaultMaxJobs is the maximum number of translations running at once when Options.MaxJobs is zero.
+	DefaultMaxJobs = 16
+)
+
+// Options configure a Server.
+type Options struct {
+	// WorkDir is the directory storing the uploaded and the translated documents, one subdirectory per job.
+	WorkDir string
+	// MaxUploadSize is the maximum size in bytes of an uploaded document, DefaultMaxUploadSize if zero.
+	MaxUploadSize int64
+	// Retention is the time a finished job and its documents are kept before they are deleted, DefaultRetention if zero.
+	Retention time.Duration
+	// MaxJobs is the maximum number of translations running at once, DefaultMaxJobs if zero.
+	// A submission is rejected with 429 Too Many Requests while this many translations are running.
+	MaxJobs int
+	// Tokens are the bearer tokens accepted by the server, each request must send one in its Authorization header if any is set.
+	// A job is only visible to the token that submitted it, and the jobs can only be listed when tokens are set.
+	Tokens []string
+}
+
+// Job is the state of a translation job of the server, as returned by the API.
+type Job struct {
+	ID                    string                   `json:"id"`
+	BatchID               string                   `json:"batchId,omitempty"`
+	Document              string                   `json:"document"`
+	SourceLanguage        string                   `json:"sourceLanguage,omitempty"`
+	TargetLanguages       []string                 `json:"targetLanguages"`
+	Status                translator.Status        `json:"status"`
+	Summary               translator.StatusSummary `json:"summary"`
+	CharacterCharged      int64                    `json:"characterCharged"`
+	Error                 *translator.ServiceError `json:"error,omitempty"`
+	CreatedDateTimeUtc    time.Time                `json:"createdDateTimeUtc"`
+	LastActionDateTimeUtc time.Time                `json:"lastActionDateTimeUtc"`
+	// ExpirationDateTimeUtc is the time the job and its documents will be deleted, once the job is finished.
+	ExpirationDateTimeUtc *time.Time `json:"expirationDateTimeUtc,omitempty"`
+	// Results maps each target language to the path downloading its translated document, once the job succeeded.
+	Results map[string]string `json:"results,omitempty"`
+}
+
+// job is a translation job run by the server.
+type job struct {
+	Job
+	dir string
+	// owner is the token that submitted the job, empty if the server has no tokens.
+	owner  string
+	cancel context.CancelFunc
+	done   chan struct{}
+	// expiry deletes the job once its retention is over.
+	expiry *time.Timer
+}
+
+// Server is the REST web service translating documents with a translator.Client.
+// A translation runs in the background once its document is uploaded, its job is polled with its ID.
+// A finished job and its documents are deleted after the retention of the options.
+type Server struct {
+	client  *translator.Client
+	options Options
+	log     *logrus.Logger
+	mu      sync.Mutex
+	jobs    map[string]*job
+	wg      sync.WaitGroup
+	// slots holds a value for each running translation, up to Options.MaxJobs.
+	slots chan struct{}
+}
+
+// New creates a Server translating with client.
+// The job directories left in the work directory by a previous server for longer than the retention are deleted.
+// It returns an error if the work directory cannot be created.
+func New(client *translator.Client, options Options) (*Server, error) {
+	if options.MaxUploadSize <= 0 {
+		options.MaxUploadSize = DefaultMaxUploadSize
+	}
+	if options.Retention <= 0 {
+		options.Retention = DefaultRetention
+	}
+	if options.MaxJobs <= 0 {
+		options.MaxJobs = DefaultMaxJobs
+	}
+	if err := os.MkdirAll(options.WorkDir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating work directory: %v", err)
+	}
+	s := &Server{client: client, options: options, log: client.Config().Logger, jobs: map[string]*job{}, slots: make(chan struct{}, options.MaxJobs)}
+	s.removeStaleDirs()
+	return s, nil
+}
+
+// removeStaleDirs deletes the job directories of the work directory last modified before the retention.
+// Only the directories named after a job ID are deleted, the other files of the work directory are kept.
+func (s *Server) removeStaleDirs() {
+	entries, err := os.ReadDir(s.options.WorkDir)
+	if err != nil {
+		s.log.Warnf("Cannot list the work directory: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if _, err := uuid.Parse(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < s.options.Retention {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.options.WorkDir, entry.Name())); err != nil {
+			s.log.Warnf("Cannot delete the documents of translation %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// Handler returns the HTTP handler of the web service.
+func (s *Server) Handler() http.Handler {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1").Produces(restful.MIME_JSON)
+
+	ws.Route(ws.POST("/translations").To(s.submit).
+		Doc("Upload a document in the multipart field document and start its translation").
+		Consumes("multipart/form-data").
+		Param(ws.QueryParameter("to", "Comma-separated list of target languages").Required(true)).
+		Param(ws.QueryParameter("from", "Source language, auto-detected if empty")).
+		Returns(http.StatusAccepted, "Translation started", Job{}))
+	ws.Route(ws.GET("/translations").To(s.list).
+		Doc("List the translation jobs of the token, the most recent first, only when the server has tokens").
+		Returns(http.StatusOK, "OK", []Job{}))
+	ws.Route(ws.GET("/translations/{id}").To(s.status).
+		Doc("Get the status of a translation job").
+		Param(ws.PathParameter("id", "Job ID")).
+		Returns(http.StatusOK, "OK", Job{}))
+	ws.Route(ws.GET("/translations/{id}/documents/{language}").To(s.download).
+		Doc("Download the translated document of a succeeded job").
+		Produces("application/octet-stream", restful.MIME_JSON).
+		Param(ws.PathParameter("id", "Job ID")).
+		Param(ws.PathParameter("language", "Target language")))
+	ws.Route(ws.DELETE("/translations/{id}").To(s.delete).
+		Doc("Cancel a running translation job, or delete the documents of a finished one").
+		Param(ws.PathParameter("id", "Job ID")).
+		Returns(http.StatusAccepted, "Cancellation requested", Job{}).
+		Returns(http.StatusNoContent, "Job deleted", nil))
+	ws.Route(ws.GET("/languages").To(s.languages).
+		Doc("List the languages supported for translation").
+		Returns(http.StatusOK, "OK", map[string]translator.Language{}))
+	ws.Route(ws.GET("/formats").To(s.formats).
+		Doc("List the supported document formats, or glossary formats with type=glossary").
+		Param(ws.QueryParameter("type", "document or glossary")).
+		Returns(http.StatusOK, "OK", []translator.FileFormat{}))
+
+	ws.Filter(s.authenticate)
+
+	container := restful.NewContainer()
+	container.Add(ws)
+	return container
+}
+
+// Shutdown cancels the running translations and waits for them to delete their temporary blobs.
+// The expiry of the finished jobs is stopped, their directories are deleted by the next server once their retention is over.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		j.cancel()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		if j.expiry != nil {
+			j.expiry.Stop()
+		}
+	}
+	s.mu.Unlock()
+}
+
+// remove deletes a finished job and its documents, it reports whether the job was still recorded.
+func (s *Server) remove(j *job) bool {
+	s.mu.Lock()
+	_, ok := s.jobs[j.ID]
+	delete(s.jobs, j.ID)
+	if j.expiry != nil {
+		j.expiry.Stop()
+	}
+	s.mu.Unlock()
+	if err := os.RemoveAll(j.dir); err != nil {
+		s.log.Warnf("Cannot delete the documents of translation %s: %v", j.ID, err)
+	}
+	return ok
+}
+
+// writeError writes an error response in the format of the Translator service.
+func writeError(resp *restful.Response, statusCode int, code, message string) {
+	resp.WriteHeaderAndEntity(statusCode, map[string]*translator.ServiceError{"error": {Code: code, Message: message}})
+}
+
+// tokenAttribute is the request attribute holding the token that authenticated the request.
+const tokenAttribute = "token"
+
+// authenticate rejects with 401 Unauthorized a request without a bearer token of the options, if the options have tokens.
+// The token of the request is stored in its tokenAttribute attribute.
+func (s *Server) authenticate(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if len(s.options.Tokens) == 0 {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	token, ok := strings.CutPrefix(req.HeaderParameter("Authorization"), "Bearer ")
+	if ok {
+		for _, valid := range s.options.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+				req.SetAttribute(tokenAttribute, valid)
+				chain.ProcessFilter(req, resp)
+				return
+			}
+		}
+	}
+	resp.AddHeader("WWW-Authenticate", "Bearer")
+	writeError(resp, http.StatusUnauthorized, "Unauthorized", "A valid bearer token is required")
+}
+
+// requestToken returns the token that authenticated a request, empty if the server has no tokens.
+func requestToken(req *restful.Request) string {
+	token, _ := req.Attribute(tokenAttribute).(string)
+	return token
+}
+
+// languagePattern matches the BCP-47 style language tags of the Translator service, such as fr or zh-Hans.
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// validLanguage reports whether a target language is a language tag, which can name the directory of its translated document.
+func validLanguage(language string) bool {
+	return languagePattern.MatchString(language)
+}
+
+// resultsDir is the subdirectory of a job directory holding the translated documents, one directory per language,
+// apart from the uploaded document.
+const resultsDir = "results"
+
+// submit saves the uploaded document and starts its translation.
+// It is rejected with 429 Too Many Requests while Options.MaxJobs translations are running.
+func (s *Server) submit(req *restful.Request, resp *restful.Response) {
+	targetLanguages := translator.ParseLanguages(req.QueryParameter("to"))
+	if len(targetLanguages) == 0 {
+		writeError(resp, http.StatusBadRequest, "InvalidRequest", "Missing target language, set the to query parameter")
+		return
+	}
+	for _, language := range targetLanguages {
+		if !validLanguage(language) {
+			writeError(resp, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("Invalid target language %q", language))
+			return
+		}
+	}
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		writeError(resp, http.StatusTooManyRequests, "TooManyRequests", fmt.Sprintf("%d translations are already running, retry later", s.options.MaxJobs))
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			<-s.slots
+		}
+	}()
+	req.Request.Body = http.MaxBytesReader(resp.ResponseWriter, req.Request.Body, s.options.MaxUploadSize)
+	document, header, err := req.Request.FormFile("document")
+	if err != nil {
+		writeError(resp, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("Missing document: %v", err))
+		return
+	}
+	defer document.Close()
+	name := filepath.Base(header.Filename)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		writeError(resp, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("Invalid document name %q", header.Filename))
+		return
+	}
+
+	now := time.Now().UTC()
+	j := &job{
+		Job: Job{
+			ID:                    uuid.NewString(),
+			Document:              name,
+			SourceLanguage:        req.QueryParameter("from"),
+			TargetLanguages:       targetLanguages,
+			Status:                translator.StatusNotStarted,
+			CreatedDateTimeUtc:    now,
+			LastActionDateTimeUtc: now,
+		},
+		owner: requestToken(req),
+		done:  make(chan struct{}),
+	}
+	j.dir = filepath.Join(s.options.WorkDir, j.ID)
+	source := filepath.Join(j.dir, "source", j.Document)
+	if err := saveFile(source, document); err != nil {
+		os.RemoveAll(j.dir)
+		writeError(resp, http.StatusInternalServerError, "InternalServerError", fmt.Sprintf("Cannot save the document: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	snapshot := j.Job
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	started = true
+	go s.translate(ctx, j, source)
+	s.log.Infof("Translation %s of %s to %s started", j.ID, j.Document, strings.Join(targetLanguages, ","))
+	resp.AddHeader("Location", "/api/v1/translations/"+j.ID)
+	resp.WriteHeaderAndEntity(http.StatusAccepted, snapshot)
+}
+
+// translate runs the translation of a job and records its outcome, then releases the slot of the job.
+func (s *Server) translate(ctx context.Context, j *job, source string) {
+	defer s.wg.Done()
+	defer func() { <-s.slots }()
+	defer close(j.done)
+	defer j.cancel()
+
+	client := s.client.WithProgress(translator.ProgressFunc(func(event translator.ProgressEvent) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch event.Kind {
+		case translator.ProgressSubmitted:
+			j.BatchID = event.JobID
+		case translator.ProgressStatus:
+			j.Status = event.Status
+			j.Summary = event.Summary
+		case translator.ProgressCharged:
+			j.CharacterCharged = event.Characters
+		default:
+			return
+		}
+		j.LastActionDateTimeUtc = time.Now().UTC()
+	}))
+	destination := filepath.Join(j.dir, resultsDir, "{lang}", "{name}{ext}")
+	err := client.Translate(ctx, source, destination, j.SourceLanguage, j.TargetLanguages)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.LastActionDateTimeUtc = time.Now().UTC()
+	expiration := j.LastActionDateTimeUtc.Add(s.options.Retention)
+	j.ExpirationDateTimeUtc = &expiration
+	j.expiry = time.AfterFunc(s.options.Retention, func() {
+		if s.remove(j) {
+			s.log.Infof("Translation %s expired", j.ID)
+		}
+	})
+	switch {
+	case err == nil:
+		j.Status = translator.StatusSucceeded
+		j.Results = map[string]string{}
+		for _, language := range j.TargetLanguages {
+			j.Results[language] = fmt.Sprintf("/api/v1/translations/%s/documents/%s", j.ID, language)
+		}
+		s.log.Infof("Translation %s succeeded", j.ID)
+	case errors.Is(err, context.Canceled):
+		j.Status = translator.StatusCancelled
+		s.log.Infof("Translation %s cancelled", j.ID)
+	default:
+		j.Status = translator.StatusFailed
+		j.Error = serviceError(err)
+		s.log.Warnf("Translation %s failed: %v", j.ID, err)
+	}
+}
+
+// serviceError returns the service error carried by a translation error, or an error wrapping its message.
+func serviceError(err error) *translator.ServiceError {
+	var submissionErr *translator.SubmissionError
+	var jobErr *translator.JobError
+	var documentErr *translator.DocumentError
+	switch {
+	case errors.As(err, &submissionErr) && submissionErr.Err != nil:
+		return submissionErr.Err
+	case errors.As(err, &jobErr) && jobErr.Err != nil:
+		return jobErr.Err
+	case errors.As(err, &documentErr) && documentErr.Err != nil:
+		return documentErr.Err
+	}
+	return &translator.ServiceError{Code: "InternalServerError", Message: err.Error()}
+}
+
+// lookup returns the job of the id path parameter, or writes a 404 response if it does not exist
+// or was submitted with another token.
+func (s *Server) lookup(req *restful.Request, resp *restful.Response) (*job, bool) {
+	s.mu.Lock()
+	j, ok := s.jobs[req.PathParameter("id")]
+	s.mu.Unlock()
+	ok = ok && j.owner == requestToken(req)
+	if !ok {
+		writeError(resp, http.StatusNotFound, "NotFound", "The requested job was not found")
+	}
+	return j, ok
+}
+
+// list writes the jobs submitted with the token of the request, the most recent first.
+// It is rejected with 403 Forbidden if the server has no tokens, since the jobs of every client would be listed.
+func (s *Server) list(req *restful.Request, resp *restful.Response) {
+	if len(s.options.Tokens) == 0 {
+		writeError(resp, http.StatusForbidden, "Forbidden", "The jobs can only be listed when the server has access tokens")
+		return
+	}
+	owner := requestToken(req)
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		if j.owner == owner {
+			jobs = append(jobs, j.Job)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].CreatedDateTimeUtc.After(jobs[k].CreatedDateTimeUtc) })
+	resp.WriteHeaderAndEntity(http.StatusOK, jobs)
+}
+
+// status writes the state of a job.
+func (s *Server) status(req *restful.Request, resp *restful.Response) {
+	j, ok := s.lookup(req, resp)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	snapshot := j.Job
+	s.mu.Unlock()
+	resp.WriteHeaderAndEntity(http.StatusOK, snapshot)
+}
+
+// download writes the translated document of a succeeded job.
+func (s *Server) download(req *restful.Request, resp *restful.Response) {
+	j, ok := s.lookup(req, resp)
+	if !ok {
+		return
+	}
+	language := req.PathParameter("language")
+	s.mu.Lock()
+	_, ready := j.Results[language]
+	s.mu.Unlock()
+	if !ready {
+		writeError(resp, http.StatusNotFound, "NotFound", fmt.Sprintf("No translated document in %q for this job", language))
+		return
+	}
+	resp.AddHeader("Content-Disposition", fmt.Sprintf("attachment; filename=%q", j.Document))
+	http.ServeFile(resp.ResponseWriter, req.Request, filepath.Join(j.dir, resultsDir, language, j.Document))
+}
+
+// delete cancels a running job, or deletes a finished job and its documents.
+func (s *Server) delete(req *restful.Request, resp *restful.Response) {
+	j, ok := s.lookup(req, resp)
+	if !ok {
+		return
+	}
+	select {
+	case <-j.done:
+		s.remove(j)
+		resp.WriteHeader(http.StatusNoContent)
+	default:
+		j.cancel()
+		s.mu.Lock()
+		j.Status = translator.StatusCancelling
+		snapshot := j.Job
+		s.mu.Unlock()
+		resp.WriteHeaderAndEntity(http.StatusAccepted, snapshot)
+	}
+}
+
+// languages writes the languages supported for translation.
+func (s *Server) languages(req *restful.Request, resp *restful.Response) {
+	languages, err := s.client.SupportedLanguages(req.Request.Context())
+	if err != nil {
+		writeError(resp, http.StatusBadGateway, "BadGateway", err.Error())
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, languages)
+}
+
+// formats writes the supported document or glossary formats.
+func (s *Server) formats(req *restful.Request, resp *restful.Response) {
+	var formats []translator.FileFormat
+	var err error
+	switch req.QueryParameter("type") {
+	case "", "document":
+		formats, err = s.client.SupportedFormats(req.Request.Context())
+	case "glossary":
+		formats, err = s.client.SupportedGlossaryFormats(req.Request.Context())
+	default:
+		writeError(resp, http.StatusBadRequest, "InvalidRequest", "The type must be document or glossary")
+		return
+	}
+	if err != nil {
+		writeError(resp, http.StatusBadGateway, "BadGateway", err.Error())
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, formats)
+}
+
+// saveFile writes the content read from r to a new file, creating its directory.
+func saveFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
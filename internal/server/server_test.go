@@ -0,0 +1,393 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the REST web service, backed by the fake Translator service of translatortest.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"translator/internal/translator"
+	"translator/internal/translator/translatortest"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestServer starts a web service translating with the fake Translator service, in a temporary work directory if options has none.
+func newTestServer(t *testing.T, fake *translatortest.Server, options Options) (*Server, *httptest.Server) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	config := fake.Config()
+	config.Logger = log
+	client, err := translator.NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if options.WorkDir == "" {
+		options.WorkDir = t.TempDir()
+	}
+	s, err := New(client, options)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	web := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		web.Close()
+		s.Shutdown()
+	})
+	return s, web
+}
+
+// upload posts a document to translate and returns the created job.
+func upload(t *testing.T, web *httptest.Server, query, name, content string) Job {
+	return uploadWithToken(t, web, "", query, name, content)
+}
+
+// uploadWithToken posts a document to translate with a bearer token, if not empty, and returns the created job.
+func uploadWithToken(t *testing.T, web *httptest.Server, token, query, name, content string) Job {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("document", name)
+	part.Write([]byte(content))
+	writer.Close()
+	req, _ := http.NewRequest(http.MethodPost, web.URL+"/api/v1/translations?"+query, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		data, _ := io.ReadAll(res.Body)
+		t.Fatalf("upload returned %s: %s", res.Status, data)
+	}
+	var job Job
+	if err := json.NewDecoder(res.Body).Decode(&job); err != nil {
+		t.Fatalf("cannot decode the job: %v", err)
+	}
+	return job
+}
+
+// getJSON gets a resource of the web service and decodes it into v, it returns the status code.
+func getJSON(t *testing.T, url string, v interface{}) int {
+	return getJSONWithToken(t, url, "", v)
+}
+
+// getJSONWithToken gets a resource of the web service with a bearer token, if not empty, and decodes it into v,
+// it returns the status code.
+func getJSONWithToken(t *testing.T, url, token string, v interface{}) int {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("cannot decode %s: %v", url, err)
+		}
+	}
+	return res.StatusCode
+}
+
+// waitForJob polls a job until it is finished.
+func waitForJob(t *testing.T, web *httptest.Server, id string) Job {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		var job Job
+		if code := getJSON(t, web.URL+"/api/v1/translations/"+id, &job); code != http.StatusOK {
+			t.Fatalf("status of job %s returned %d", id, code)
+		}
+		switch job.Status {
+		case translator.StatusSucceeded, translator.StatusFailed, translator.StatusCancelled:
+			return job
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+// TestTranslate is a test function that uploads a document, polls its job and downloads its translations.
+func TestTranslate(t *testing.T) {
+	fake := translatortest.NewServer()
+	defer fake.Close()
+	_, web := newTestServer(t, fake, Options{})
+
+	job := upload(t, web, "from=en&to=fr,de", "report.txt", "hello")
+	if job.ID == "" || job.Document != "report.txt" || len(job.TargetLanguages) != 2 {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+	job = waitForJob(t, web, job.ID)
+	if job.Status != translator.StatusSucceeded || job.BatchID == "" || len(job.Results) != 2 {
+		t.Fatalf("unexpected finished job: %+v", job)
+	}
+	for language, expected := range map[string]string{"fr": "[fr] hello", "de": "[de] hello"} {
+		res, err := http.Get(web.URL + job.Results[language])
+		if err != nil {
+			t.Fatalf("download failed: %v", err)
+		}
+		data, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || string(data) != expected {
+			t.Errorf("download of %s returned %s %q, expected %q", language, res.Status, data, expected)
+		}
+	}
+
+	if code := getJSON(t, web.URL+"/api/v1/translations", nil); code != http.StatusForbidden {
+		t.Errorf("list without tokens returned %d, expected %d", code, http.StatusForbidden)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, web.URL+"/api/v1/translations/"+job.ID, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete returned %v, %v", res, err)
+	}
+	if code := getJSON(t, web.URL+"/api/v1/translations/"+job.ID, nil); code != http.StatusNotFound {
+		t.Errorf("status of a deleted job returned %d", code)
+	}
+}
+
+// TestInvalidRequests is a test function that checks the errors returned for invalid requests.
+func TestInvalidRequests(t *testing.T) {
+	fake := translatortest.NewServer()
+	defer fake.Close()
+	_, web := newTestServer(t, fake, Options{})
+
+	res, err := http.Post(web.URL+"/api/v1/translations?to=fr", "multipart/form-data; boundary=x", bytes.NewReader(nil))
+	if err != nil || res.StatusCode != http.StatusBadRequest {
+		t.Errorf("upload without document returned %v, %v", res, err)
+	}
+	var body struct {
+		Error translator.ServiceError `json:"error"`
+	}
+	json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if body.Error.Code != "InvalidRequest" {
+		t.Errorf("unexpected error body: %+v", body)
+	}
+	if code := getJSON(t, web.URL+"/api/v1/translations/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("status of an unknown job returned %d", code)
+	}
+	if code := getJSON(t, web.URL+"/api/v1/formats?type=video", nil); code != http.StatusBadRequest {
+		t.Errorf("formats of an invalid type returned %d", code)
+	}
+	for _, name := range []string{".", ".."} {
+		var upload bytes.Buffer
+		writer := multipart.NewWriter(&upload)
+		part, _ := writer.CreateFormFile("document", name)
+		part.Write([]byte("hello"))
+		writer.Close()
+		res, err := http.Post(web.URL+"/api/v1/translations?to=fr", writer.FormDataContentType(), &upload)
+		if err != nil || res.StatusCode != http.StatusBadRequest {
+			t.Errorf("upload of the document %q returned %v, %v", name, res, err)
+			continue
+		}
+		res.Body.Close()
+	}
+	for _, language := range []string{"../fr", "fr/..", `..\fr`, "a/b", "..", ".", "fr_FR", "source-language-x"} {
+		res, err := http.Post(web.URL+"/api/v1/translations?to="+url.QueryEscape(language), "multipart/form-data; boundary=x", bytes.NewReader(nil))
+		if err != nil || res.StatusCode != http.StatusBadRequest {
+			t.Errorf("upload to the language %q returned %v, %v", language, res, err)
+			continue
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if !strings.Contains(body.Error.Message, "Invalid target language") {
+			t.Errorf("upload to the language %q returned %+v, expected an invalid language error", language, body)
+		}
+	}
+}
+
+// TestSourceLanguage is a test function that checks that a translation to the language source is rejected,
+// and that the translated documents are written apart from the uploaded document.
+func TestSourceLanguage(t *testing.T) {
+	fake := translatortest.NewServer()
+	defer fake.Close()
+	workDir := t.TempDir()
+	_, web := newTestServer(t, fake, Options{WorkDir: workDir})
+
+	res, err := http.Post(web.URL+"/api/v1/translations?to=source", "multipart/form-data; boundary=x", bytes.NewReader(nil))
+	if err != nil || res.StatusCode != http.StatusBadRequest {
+		t.Errorf("upload to the language source returned %v, %v", res, err)
+	} else {
+		res.Body.Close()
+	}
+
+	job := waitForJob(t, web, upload(t, web, "to=fr", "report.txt", "hello").ID)
+	if job.Status != translator.StatusSucceeded {
+		t.Fatalf("unexpected finished job: %+v", job)
+	}
+	for path, expected := range map[string]string{
+		filepath.Join(workDir, job.ID, "source", "report.txt"):        "hello",
+		filepath.Join(workDir, job.ID, "results", "fr", "report.txt"): "[fr] hello",
+	} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != expected {
+			t.Errorf("%s contains %q, %v, expected %q", path, data, err, expected)
+		}
+	}
+}
+
+// TestTokens is a test function that checks that the requests without a valid token are rejected
+// and that a job is only visible to the token that submitted it.
+func TestTokens(t *testing.T) {
+	fake := translatortest.NewServer()
+	defer fake.Close()
+	_, web := newTestServer(t, fake, Options{Tokens: []string{"team-a", "team-b"}})
+
+	if code := getJSON(t, web.URL+"/api/v1/languages", nil); code != http.StatusUnauthorized {
+		t.Errorf("request without a token returned %d, expected %d", code, http.StatusUnauthorized)
+	}
+	if code := getJSONWithToken(t, web.URL+"/api/v1/languages", "team-c", nil); code != http.StatusUnauthorized {
+		t.Errorf("request with an invalid token returned %d, expected %d", code, http.StatusUnauthorized)
+	}
+
+	job := uploadWithToken(t, web, "team-a", "to=fr", "report.txt", "hello")
+	var jobs []Job
+	if code := getJSONWithToken(t, web.URL+"/api/v1/translations", "team-a", &jobs); code != http.StatusOK || len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("list of the submitter returned %d %+v", code, jobs)
+	}
+	jobs = nil
+	if code := getJSONWithToken(t, web.URL+"/api/v1/translations", "team-b", &jobs); code != http.StatusOK || len(jobs) != 0 {
+		t.Errorf("list of another token returned %d %+v", code, jobs)
+	}
+	if code := getJSONWithToken(t, web.URL+"/api/v1/translations/"+job.ID, "team-b", nil); code != http.StatusNotFound {
+		t.Errorf("status of the job of another token returned %d, expected %d", code, http.StatusNotFound)
+	}
+	if code := getJSONWithToken(t, web.URL+"/api/v1/translations/"+job.ID+"/documents/fr", "team-b", nil); code != http.StatusNotFound {
+		t.Errorf("download of the job of another token returned %d, expected %d", code, http.StatusNotFound)
+	}
+	if code := getJSONWithToken(t, web.URL+"/api/v1/translations/"+job.ID, "team-a", nil); code != http.StatusOK {
+		t.Errorf("status of the job of its submitter returned %d", code)
+	}
+}
+
+// TestMaxJobs is a test function that checks that the submissions beyond the maximum number of running translations
+// are rejected with 429 Too Many Requests until a translation ends.
+func TestMaxJobs(t *testing.T) {
+	fake := translatortest.NewServer()
+	defer fake.Close()
+	fake.Latency = time.Hour
+	_, web := newTestServer(t, fake, Options{MaxJobs: 1})
+
+	job := upload(t, web, "to=fr", "report.txt", "hello")
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("document", "notes.txt")
+	part.Write([]byte("hi"))
+	writer.Close()
+	res, err := http.Post(web.URL+"/api/v1/translations?to=fr", writer.FormDataContentType(), &body)
+	if err != nil || res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("upload beyond the maximum number of jobs returned %v, %v", res, err)
+	}
+	res.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodDelete, web.URL+"/api/v1/translations/"+job.ID, nil)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusAccepted {
+		t.Fatalf("cancel returned %v, %v", res, err)
+	}
+	waitForJob(t, web, job.ID)
+	upload(t, web, "to=fr", "notes.txt", "hi")
+}
+
+// TestCancel is a test function that cancels a running job.
+func TestCancel(t *testing.T) {
+	fake := translatortest.NewServer()
+	defer fake.Close()
+	fake.Latency = time.Hour
+	_, web := newTestServer(t, fake, Options{})
+
+	job := upload(t, web, "to=fr", "report.txt", "hello")
+	for job.BatchID == "" {
+		time.Sleep(20 * time.Millisecond)
+		getJSON(t, web.URL+"/api/v1/translations/"+job.ID, &job)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, web.URL+"/api/v1/translations/"+job.ID, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != http.StatusAccepted {
+		t.Fatalf("cancel returned %v, %v", res, err)
+	}
+	res.Body.Close()
+	if job = waitForJob(t, web, job.ID); job.Status != translator.StatusCancelled {
+		t.Errorf("job ended with status %s, expected Cancelled", job.Status)
+	}
+	if status := fake.JobStatus(job.BatchID); status != translator.StatusCancelled {
+		t.Errorf("batch %s has status %s, expected Cancelled", job.BatchID, status)
+	}
+}
+
+// TestRetention is a test function that checks that the finished jobs and their documents, and the job directories
+// left by a previous server, are deleted after the retention.
+func TestRetention(t *testing.T) {
+	fake := translatortest.NewServer()
+	defer fake.Close()
+	workDir := t.TempDir()
+	stale := filepath.Join(workDir, uuid.NewString())
+	other := filepath.Join(workDir, "notes")
+	for _, dir := range []string{stale, other} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-time.Hour)
+		os.Chtimes(dir, old, old)
+	}
+	_, web := newTestServer(t, fake, Options{WorkDir: workDir, Retention: 500 * time.Millisecond})
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("the stale job directory was not deleted: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("a directory that is not a job directory was deleted: %v", err)
+	}
+
+	job := waitForJob(t, web, upload(t, web, "to=fr", "report.txt", "hello").ID)
+	if job.Status != translator.StatusSucceeded || job.ExpirationDateTimeUtc == nil || !job.ExpirationDateTimeUtc.After(job.LastActionDateTimeUtc) {
+		t.Fatalf("unexpected finished job: %+v", job)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for getJSON(t, web.URL+"/api/v1/translations/"+job.ID, nil) != http.StatusNotFound {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s was not deleted after its retention", job.ID)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, job.ID)); !os.IsNotExist(err) {
+		t.Errorf("the documents of job %s were not deleted: %v", job.ID, err)
+	}
+}
+
+// TestLanguagesAndFormats is a test function that lists the supported languages and formats.
+func TestLanguagesAndFormats(t *testing.T) {
+	fake := translatortest.NewServer()
+	defer fake.Close()
+	_, web := newTestServer(t, fake, Options{})
+
+	var languages map[string]translator.Language
+	if code := getJSON(t, web.URL+"/api/v1/languages", &languages); code != http.StatusOK || languages["fr"].Name == "" {
+		t.Errorf("languages returned %d %+v", code, languages)
+	}
+	var formats []translator.FileFormat
+	if code := getJSON(t, web.URL+"/api/v1/formats", &formats); code != http.StatusOK || len(formats) == 0 {
+		t.Errorf("formats returned %d %+v", code, formats)
+	}
+}
@@ -0,0 +1,590 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// AzureBlobStorage is the Storage backed by an Azure Blob Storage container.
+// Documents are streamed in blocks, so that the memory used does not depend on their size,
+// and every block is checked against its MD5 hash by the service on upload and by the storage on download.
+type AzureBlobStorage struct {
+	// Transfer sets the block size and the parallelism of the uploads and downloads.
+	Transfer TransferOptions
+	// IPRange restricts the signed URLs to an IP address or a range "start-end", see SASOptions.
+	IPRange string
+
+	// sharedKey signs the SAS with the account key, they are user delegation SAS if it is nil.
+	sharedKey *azblob.SharedKeyCredential
+	// token authenticates the requests if the storage uses a Microsoft Entra ID credential.
+	token         *blobToken
+	accountName   string
+	containerName string
+	serviceURL    azblob.ServiceURL
+	containerURL  azblob.ContainerURL
+	url           url.URL
+
+	// delegation is the cached user delegation credential and delegationExpiry the expiry of its key.
+	mu               sync.Mutex
+	delegation       azblob.StorageAccountCredential
+	delegationExpiry time.Time
+}
+
+// maxDelegationKeyLifetime is the longest validity of a user delegation key accepted by Azure Blob Storage.
+const maxDelegationKeyLifetime = 7 * 24 * time.Hour
+
+// DefaultBlobServiceURL returns the blob service URL of a storage account in the Azure public cloud.
+func DefaultBlobServiceURL(accountName string) string {
+	return fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
+}
+
+// NewAzureBlobStorage creates a Storage for an Azure Blob Storage container.
+// It takes the following parameters:
+// - serviceURL: The blob service URL, e.g. https://account.blob.core.chinacloudapi.cn for Azure China,
+// a private endpoint hostname, or http://127.0.0.1:10000/devstoreaccount1 for Azurite. DefaultBlobServiceURL is used if empty.
+// - accountName: The storage account name.
+// - accountKey: The storage account key.
+// - containerName: The container name.
+// It returns an error if the account key or the service URL is invalid.
+func NewAzureBlobStorage(serviceURL, accountName, accountKey, containerName string) (*AzureBlobStorage, error) {
+	// Create a default request pipeline using your storage account name and account key.
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := newAzureBlobStorage(serviceURL, accountName, containerName, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	storage.sharedKey = credential
+	return storage, nil
+}
+
+// NewAzureBlobStorageWithCredential creates a Storage for an Azure Blob Storage container
+// authenticated with a Microsoft Entra ID credential instead of the account key.
+// Its signed URLs are user delegation SAS, the identity needs the Storage Blob Data Contributor role
+// on the container, which includes the permission to get a user delegation key, and on the storage account
+// to create the containers of the folder translations, see ContainerStorage.
+// It takes the following parameters:
+// - serviceURL: The blob service URL, DefaultBlobServiceURL is used if empty. It must use https.
+// - accountName: The storage account name.
+// - containerName: The container name.
+// - credential: The credential whose tokens authenticate the requests.
+// No token is requested before the first operation, which gets it with its own context.
+// It returns an error if the service URL is invalid.
+func NewAzureBlobStorageWithCredential(serviceURL, accountName, containerName string, credential azcore.TokenCredential) (*AzureBlobStorage, error) {
+	token := newBlobToken(credential)
+	storage, err := newAzureBlobStorage(serviceURL, accountName, containerName, token.token, nil)
+	if err != nil {
+		return nil, err
+	}
+	storage.token = token
+	return storage, nil
+}
+
+// authorize refreshes the token authenticating the requests of an operation, if the storage uses a Microsoft Entra ID credential.
+func (s *AzureBlobStorage) authorize(ctx context.Context) error {
+	if s.token == nil {
+		return nil
+	}
+	return s.token.refresh(ctx)
+}
+
+// newAzureBlobStorage creates a Storage for an Azure Blob Storage container whose requests are authenticated by credential.
+// The requests are sent by client, or by the default client of azblob if nil.
+func newAzureBlobStorage(serviceURL, accountName, containerName string, credential azblob.Credential, client *http.Client) (*AzureBlobStorage, error) {
+	if serviceURL == "" {
+		serviceURL = DefaultBlobServiceURL(accountName)
+	}
+	// The operations are retried by the retry policy of the translator configuration, not by the pipeline.
+	options := azblob.PipelineOptions{Retry: azblob.RetryOptions{MaxTries: 1}}
+	if client != nil {
+		options.HTTPSender = httpSender(client)
+	}
+	p := azblob.NewPipeline(credential, options)
+
+	// Create a URL to the blob storage container.
+	URL, err := url.Parse(fmt.Sprintf("%s/%s", strings.TrimSuffix(serviceURL, "/"), containerName))
+	if err != nil {
+		return nil, fmt.Errorf("invalid blob service URL %q: %v", serviceURL, err)
+	}
+	if URL.Scheme != "https" && URL.Scheme != "http" {
+		return nil, fmt.Errorf("invalid blob service URL %q: the scheme must be https or http", serviceURL)
+	}
+	service := *URL
+	service.Path = strings.TrimSuffix(strings.TrimSuffix(service.Path, containerName), "/")
+
+	return &AzureBlobStorage{
+		accountName:   accountName,
+		containerName: containerName,
+		serviceURL:    azblob.NewServiceURL(service, p),
+		containerURL:  azblob.NewContainerURL(*URL, p),
+		url:           *URL,
+	}, nil
+}
+
+// httpSender returns the pipeline factory sending the requests of a pipeline with client.
+func httpSender(client *http.Client) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			response, err := client.Do(request.WithContext(ctx))
+			return pipeline.NewHTTPResponse(response), err
+		}
+	})
+}
+
+// maxRangeMD5Size is the largest range of a blob whose MD5 hash Azure Blob Storage returns with its content.
+const maxRangeMD5Size = 4 << 20
+
+// transferGroup runs the block transfers of an operation in parallel, up to a limit,
+// and cancels the running transfers when one of them fails.
+type transferGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	slots  chan struct{}
+	mu     sync.Mutex
+	err    error
+}
+
+// newTransferGroup creates a transferGroup running up to parallelism transfers at a time.
+func newTransferGroup(ctx context.Context, parallelism int) *transferGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	return &transferGroup{ctx: ctx, cancel: cancel, slots: make(chan struct{}, parallelism)}
+}
+
+// run waits for a free slot and runs transfer in a goroutine with the context of the group.
+// It returns false, without running transfer, if a transfer failed or the context is done.
+func (g *transferGroup) run(transfer func(ctx context.Context) error) bool {
+	select {
+	case g.slots <- struct{}{}:
+	case <-g.ctx.Done():
+		return false
+	}
+	if g.ctx.Err() != nil {
+		<-g.slots
+		return false
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() { <-g.slots }()
+		if err := transfer(g.ctx); err != nil {
+			g.mu.Lock()
+			if g.err == nil {
+				g.err = err
+				g.cancel()
+			}
+			g.mu.Unlock()
+		}
+	}()
+	return true
+}
+
+// wait waits for the transfers and returns the first error, or the error of the context if it is done.
+func (g *transferGroup) wait() error {
+	g.wg.Wait()
+	defer g.cancel()
+	if g.err != nil {
+		return g.err
+	}
+	return g.ctx.Err()
+}
+
+// Put uploads the content read from r to the blob name.
+// The content is streamed in blocks of Transfer.BlockSize bytes, Transfer.Parallelism blocks at a time.
+// Each block is sent with its MD5 hash, which the service checks before storing it, and the MD5 hash
+// of the whole content is stored as the Content-MD5 property of the blob when the blocks are committed.
+func (s *AzureBlobStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+	transfer, err := s.Transfer.validate()
+	if err != nil {
+		return err
+	}
+	blobURL := s.containerURL.NewBlockBlobURL(name)
+	hash := md5.New()
+	blockIDs := []string{}
+	// The buffers of the staged blocks are reused, at most Parallelism+1 are allocated.
+	buffers := make(chan []byte, transfer.Parallelism+1)
+	group := newTransferGroup(ctx, transfer.Parallelism)
+	for {
+		var buffer []byte
+		select {
+		case buffer = <-buffers:
+		default:
+			buffer = make([]byte, transfer.BlockSize)
+		}
+		n, readErr := io.ReadFull(r, buffer)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			group.cancel()
+			group.wait()
+			return readErr
+		}
+		if n == 0 {
+			break
+		}
+		block := buffer[:n]
+		hash.Write(block)
+		blockMD5 := md5.Sum(block)
+		// The block IDs of a blob must all have the same length.
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(blockIDs))))
+		blockIDs = append(blockIDs, blockID)
+		started := group.run(func(ctx context.Context) error {
+			defer func() { buffers <- buffer }()
+			_, err := blobURL.StageBlock(ctx, blockID, bytes.NewReader(block), azblob.LeaseAccessConditions{}, blockMD5[:], azblob.ClientProvidedKeyOptions{})
+			return err
+		})
+		if !started || readErr != nil {
+			break
+		}
+	}
+	if err := group.wait(); err != nil {
+		return err
+	}
+	_, err = blobURL.CommitBlockList(ctx, blockIDs, azblob.BlobHTTPHeaders{ContentMD5: hash.Sum(nil)}, azblob.Metadata{},
+		azblob.BlobAccessConditions{}, azblob.DefaultAccessTier, nil, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
+	return err
+}
+
+// getRange downloads count bytes of the blob name from offset to w, and checks them against the MD5 hash the service
+// computes for the range. Unlike the Content-MD5 property, which the Translator service does not set on the blobs
+// it writes, this hash is available for every blob.
+// It returns an error if the service returns no hash, or if the content does not match it.
+func (s *AzureBlobStorage) getRange(ctx context.Context, name string, offset, count int64, w io.Writer) error {
+	blobURL := s.containerURL.NewBlobURL(name)
+	res, err := blobURL.Download(ctx, offset, count, azblob.BlobAccessConditions{}, true, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return err
+	}
+	body := res.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), body); err != nil {
+		return err
+	}
+	return checkContentMD5(fmt.Sprintf("%s (bytes %d-%d)", name, offset, offset+count-1), res.ContentMD5(), hash)
+}
+
+// rangeSize returns the size of the ranges downloaded with their MD5 hash, at most maxRangeMD5Size.
+func rangeSize(transfer TransferOptions) int64 {
+	return min(transfer.BlockSize, maxRangeMD5Size)
+}
+
+// Get downloads the blob name to w, in ranges of Transfer.BlockSize bytes and at most 4 MiB,
+// each checked against its MD5 hash, see getRange.
+func (s *AzureBlobStorage) Get(ctx context.Context, name string, w io.Writer) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+	transfer, err := s.Transfer.validate()
+	if err != nil {
+		return err
+	}
+	properties, err := s.containerURL.NewBlobURL(name).GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return err
+	}
+	size := properties.ContentLength()
+	for offset := int64(0); offset < size; offset += rangeSize(transfer) {
+		if err := s.getRange(ctx, name, offset, min(rangeSize(transfer), size-offset), w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFile downloads the blob name to file with parallel ranged requests
+// of Transfer.BlockSize bytes and at most 4 MiB, Transfer.Parallelism requests at a time.
+// Each range is checked against its MD5 hash, see getRange.
+// progress is called with the number of bytes downloaded so far and the size of the blob.
+func (s *AzureBlobStorage) GetFile(ctx context.Context, name string, file *os.File, progress func(bytes, total int64)) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+	transfer, err := s.Transfer.validate()
+	if err != nil {
+		return err
+	}
+	properties, err := s.containerURL.NewBlobURL(name).GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return err
+	}
+	size := properties.ContentLength()
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	var downloaded int64
+	group := newTransferGroup(ctx, transfer.Parallelism)
+	for offset := int64(0); offset < size; offset += rangeSize(transfer) {
+		offset, count := offset, min(rangeSize(transfer), size-offset)
+		started := group.run(func(ctx context.Context) error {
+			if err := s.getRange(ctx, name, offset, count, io.NewOffsetWriter(file, offset)); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			downloaded += count
+			progress(downloaded, size)
+			return nil
+		})
+		if !started {
+			break
+		}
+	}
+	return group.wait()
+}
+
+// checkContentMD5 compares the MD5 hash of the downloaded content of a blob with the expected one.
+// It returns an error if they differ, or if expected is empty since the content cannot be checked.
+func checkContentMD5(name string, expected []byte, hash hash.Hash) error {
+	if len(expected) == 0 {
+		return fmt.Errorf("blob %s was downloaded without its MD5 hash, its content cannot be checked", name)
+	}
+	if actual := hash.Sum(nil); !bytes.Equal(actual, expected) {
+		return fmt.Errorf("content of blob %s is corrupted: MD5 %s, expected %s", name,
+			base64.StdEncoding.EncodeToString(actual), base64.StdEncoding.EncodeToString(expected))
+	}
+	return nil
+}
+
+// Delete deletes the blob name and its snapshots.
+func (s *AzureBlobStorage) Delete(ctx context.Context, name string) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+	blobURL := s.containerURL.NewBlockBlobURL(name)
+	_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return err
+}
+
+// List lists the blobs whose name starts with prefix, segment by segment.
+func (s *AzureBlobStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		segment, err := s.containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range segment.Segment.BlobItems {
+			names = append(names, blob.Name)
+		}
+		marker = segment.NextMarker
+	}
+	return names, nil
+}
+
+// ListInfo returns the blobs whose names start with prefix, with their size and modification time.
+func (s *AzureBlobStorage) ListInfo(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	blobs := []BlobInfo{}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		segment, err := s.containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range segment.Segment.BlobItems {
+			info := BlobInfo{Name: blob.Name, LastModified: blob.Properties.LastModified}
+			if blob.Properties.ContentLength != nil {
+				info.Size = *blob.Properties.ContentLength
+			}
+			blobs = append(blobs, info)
+		}
+		marker = segment.NextMarker
+	}
+	return blobs, nil
+}
+
+// Container returns the Storage of the container name of the same account, with the same credential and settings.
+// The container is not created, see CreateContainer.
+func (s *AzureBlobStorage) Container(name string) (Storage, error) {
+	u := s.url
+	u.Path = fmt.Sprintf("%s/%s", strings.TrimSuffix(strings.TrimSuffix(u.Path, s.containerName), "/"), name)
+	return &AzureBlobStorage{
+		Transfer:      s.Transfer,
+		IPRange:       s.IPRange,
+		sharedKey:     s.sharedKey,
+		token:         s.token,
+		accountName:   s.accountName,
+		containerName: name,
+		serviceURL:    s.serviceURL,
+		containerURL:  s.serviceURL.NewContainerURL(name),
+		url:           u,
+	}, nil
+}
+
+// hasServiceCode reports whether err is a storage error with the given service code.
+func hasServiceCode(err error, code azblob.ServiceCodeType) bool {
+	var storageErr azblob.StorageError
+	return errors.As(err, &storageErr) && storageErr.ServiceCode() == code
+}
+
+// forbidden wraps err with ErrForbidden if it is a storage error with the status 403 Forbidden.
+func forbidden(err error) error {
+	var storageErr azblob.StorageError
+	if errors.As(err, &storageErr) && storageErr.Response() != nil && storageErr.Response().StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: %v", ErrForbidden, err)
+	}
+	return err
+}
+
+// CreateContainer creates the private container name, it succeeds if the container already exists.
+func (s *AzureBlobStorage) CreateContainer(ctx context.Context, name string) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+	_, err := s.serviceURL.NewContainerURL(name).Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
+	if hasServiceCode(err, azblob.ServiceCodeContainerAlreadyExists) {
+		return nil
+	}
+	return forbidden(err)
+}
+
+// DeleteContainer deletes the container name and its blobs, it succeeds if the container does not exist.
+func (s *AzureBlobStorage) DeleteContainer(ctx context.Context, name string) error {
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+	_, err := s.serviceURL.NewContainerURL(name).Delete(ctx, azblob.ContainerAccessConditions{})
+	if hasServiceCode(err, azblob.ServiceCodeContainerNotFound) {
+		return nil
+	}
+	return forbidden(err)
+}
+
+// ListContainers lists the containers whose name starts with prefix, segment by segment.
+func (s *AzureBlobStorage) ListContainers(ctx context.Context, prefix string) ([]ContainerInfo, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	containers := []ContainerInfo{}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		segment, err := s.serviceURL.ListContainersSegment(ctx, marker, azblob.ListContainersSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, forbidden(err)
+		}
+		for _, container := range segment.ContainerItems {
+			containers = append(containers, ContainerInfo{Name: container.Name, LastModified: container.Properties.LastModified})
+		}
+		marker = segment.NextMarker
+	}
+	return containers, nil
+}
+
+// SignedURL returns the URL of the blob name with a Shared Access Signature (SAS) query.
+// A blob SAS is issued for a blob, and a container SAS for a folder or the container,
+// since Azure Blob Storage has no SAS scoped to a virtual folder.
+func (s *AzureBlobStorage) SignedURL(ctx context.Context, name string, permissions Permissions, expiry time.Time) (string, error) {
+	values := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPS, // Users MUST use HTTPS (not HTTP)
+		ExpiryTime:    expiry.UTC(),
+		ContainerName: s.containerName,
+	}
+	if s.url.Scheme == "http" {
+		// Emulators such as Azurite are served over plain HTTP.
+		values.Protocol = azblob.SASProtocolHTTPSandHTTP
+	}
+	if s.IPRange != "" {
+		start, end, err := parseIPRange(s.IPRange)
+		if err != nil {
+			return "", err
+		}
+		values.IPRange = azblob.IPRange{Start: start, End: end}
+	}
+	if name == "" || strings.HasSuffix(name, "/") {
+		// To produce a container SAS (as opposed to a blob SAS), assign to Permissions using
+		// ContainerSASPermissions and make sure the BlobName field is "" (the default).
+		values.Permissions = azblob.ContainerSASPermissions{Read: permissions.Read, Write: permissions.Write, Delete: permissions.Delete, List: permissions.List}.String()
+	} else {
+		values.BlobName = name
+		values.Permissions = azblob.BlobSASPermissions{Read: permissions.Read, Add: permissions.Write, Create: permissions.Write, Write: permissions.Write, Delete: permissions.Delete, List: permissions.List}.String()
+	}
+	credential, err := s.sasCredential(ctx, expiry)
+	if err != nil {
+		return "", err
+	}
+	sasQueryParams, err := values.NewSASQueryParameters(credential)
+	if err != nil {
+		return "", err
+	}
+
+	u := s.url
+	if name != "" {
+		u.Path = fmt.Sprintf("%s/%s", u.Path, strings.TrimSuffix(name, "/"))
+	}
+	u.RawQuery = sasQueryParams.Encode()
+	return u.String(), nil
+}
+
+// sasCredential returns the credential signing the SAS expiring at expiry: the account key if the storage has one,
+// otherwise a user delegation key, requested from the service and reused while it outlives the SAS.
+func (s *AzureBlobStorage) sasCredential(ctx context.Context, expiry time.Time) (azblob.StorageAccountCredential, error) {
+	if s.sharedKey != nil {
+		return s.sharedKey, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.delegation != nil && !s.delegationExpiry.Before(expiry) {
+		return s.delegation, nil
+	}
+
+	// The key is valid from a few minutes ago to tolerate the clock skew,
+	// and for an hour more than the SAS so that the next SAS can reuse it.
+	now := time.Now()
+	keyExpiry := expiry.Add(time.Hour)
+	if latest := now.Add(maxDelegationKeyLifetime); keyExpiry.After(latest) {
+		keyExpiry = latest
+	}
+	if keyExpiry.Before(expiry) {
+		return nil, fmt.Errorf("a user delegation SAS cannot expire after %v", maxDelegationKeyLifetime)
+	}
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	credential, err := s.serviceURL.GetUserDelegationCredential(ctx, azblob.NewKeyInfo(now.Add(-5*time.Minute), keyExpiry), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting a user delegation key: %v", err)
+	}
+	s.delegation = delegationCredential{UserDelegationCredential: credential, accountName: s.accountName}
+	s.delegationExpiry = keyExpiry
+	return s.delegation, nil
+}
+
+// delegationCredential is a user delegation credential signing for the configured account name.
+// azblob takes the account name from the first label of the host, which is wrong for emulators and private endpoints.
+type delegationCredential struct {
+	azblob.UserDelegationCredential
+	accountName string
+}
+
+// AccountName returns the storage account name.
+func (c delegationCredential) AccountName() string {
+	return c.accountName
+}
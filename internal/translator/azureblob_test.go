@@ -0,0 +1,416 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the Azure Blob Storage backend that do not need an Azure account.
+*/
+
+package translator
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// azuriteAccountKey is the well-known account key of the Azurite emulator.
+const azuriteAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+// TestAzureBlobStorageSignedURL is a test function that tests the signed URLs of the Azure public cloud and of Azurite.
+func TestAzureBlobStorageSignedURL(t *testing.T) {
+	tests := []struct {
+		serviceURL string
+		prefix     string
+		protocol   string
+	}{
+		{"", "https://devstoreaccount1.blob.core.windows.net/translator/doc%20a.docx?", "spr=https&"},
+		{"https://devstoreaccount1.blob.core.chinacloudapi.cn/", "https://devstoreaccount1.blob.core.chinacloudapi.cn/translator/doc%20a.docx?", "spr=https&"},
+		{"http://127.0.0.1:10000/devstoreaccount1", "http://127.0.0.1:10000/devstoreaccount1/translator/doc%20a.docx?", "spr=https%2Chttp&"},
+	}
+
+	for _, test := range tests {
+		storage, err := NewAzureBlobStorage(test.serviceURL, "devstoreaccount1", azuriteAccountKey, "translator")
+		if err != nil {
+			t.Fatalf("NewAzureBlobStorage(%q) failed: %v", test.serviceURL, err)
+		}
+		signedURL, err := storage.SignedURL(context.Background(), "doc a.docx", Permissions{Read: true}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("SignedURL failed: %v", err)
+		}
+		if !strings.HasPrefix(signedURL, test.prefix) || !strings.Contains(signedURL, test.protocol) {
+			t.Errorf("SignedURL returned %q, expected prefix %q and %q", signedURL, test.prefix, test.protocol)
+		}
+
+		// The signed URLs of a job container are scoped to the job container.
+		container, _ := storage.Container("translator-job-1")
+		signedURL, err = container.SignedURL(context.Background(), "job-translated-fr/", Permissions{Write: true, List: true}, time.Now().Add(time.Hour))
+		expected := strings.Replace(test.prefix, "/translator/doc%20a.docx?", "/translator-job-1/job-translated-fr?", 1)
+		if err != nil || !strings.HasPrefix(signedURL, expected) || !strings.Contains(signedURL, "sr=c&") {
+			t.Errorf("SignedURL of the job container returned %q (%v), expected prefix %q and a container SAS", signedURL, err, expected)
+		}
+	}
+
+	if _, err := NewAzureBlobStorage("ftp://example.com", "devstoreaccount1", azuriteAccountKey, "translator"); err == nil {
+		t.Error("NewAzureBlobStorage accepted an ftp service URL")
+	}
+}
+
+// TestSASPermissions is a test function that checks the permissions, the scope, the IP range and the lifetime of the signed URLs given to the service.
+func TestSASPermissions(t *testing.T) {
+	config := TranslatorConfig{
+		BlobAccountName:   "devstoreaccount1",
+		BlobAccountKey:    azuriteAccountKey,
+		BlobContainerName: "translator",
+		Timeout:           60,
+		SAS:               SASOptions{IPRange: "203.0.113.0-203.0.113.255"},
+	}
+	tests := []struct {
+		blob        string
+		permissions Permissions
+		sp          string
+		sr          string
+	}{
+		{"job-doc.docx", sourcePermissions, "r", "b"},
+		{"job-translated-fr-doc.docx", targetPermissions, "acw", "b"},
+		{"", sourceFolderPermissions, "rl", "c"},
+		{"job-translated-fr/", targetFolderPermissions, "wl", "c"},
+	}
+	for _, test := range tests {
+		before := time.Now()
+		signedURL, err := getBlobURLWithSASToken(context.Background(), config, test.blob, test.permissions)
+		if err != nil {
+			t.Fatalf("getBlobURLWithSASToken(%q) failed: %v", test.blob, err)
+		}
+		u, err := url.Parse(signedURL)
+		if err != nil {
+			t.Fatalf("invalid signed URL %q: %v", signedURL, err)
+		}
+		query := u.Query()
+		if query.Get("sp") != test.sp || query.Get("sr") != test.sr || query.Get("sip") != config.SAS.IPRange {
+			t.Errorf("signed URL of %q has sp=%s sr=%s sip=%s, expected sp=%s sr=%s sip=%s", test.blob,
+				query.Get("sp"), query.Get("sr"), query.Get("sip"), test.sp, test.sr, config.SAS.IPRange)
+		}
+		expiry, err := time.Parse(time.RFC3339, query.Get("se"))
+		expected := before.Add(time.Minute + DefaultSASExpiryMargin)
+		if err != nil || expiry.Before(expected.Add(-time.Second)) || expiry.After(expected.Add(5*time.Second)) {
+			t.Errorf("signed URL of %q expires at %v, expected about %v", test.blob, expiry, expected)
+		}
+	}
+
+	config.SAS.Expiry = Duration(time.Hour)
+	signedURL, err := getBlobURLWithSASToken(context.Background(), config, "job-doc.docx", sourcePermissions)
+	if err != nil {
+		t.Fatalf("getBlobURLWithSASToken failed: %v", err)
+	}
+	u, _ := url.Parse(signedURL)
+	if expiry, err := time.Parse(time.RFC3339, u.Query().Get("se")); err != nil || time.Until(expiry) < 59*time.Minute || time.Until(expiry) > time.Hour {
+		t.Errorf("signed URL expires at %v, expected in an hour", expiry)
+	}
+
+	config.SAS.IPRange = "203.0.113.0-nowhere"
+	if _, err := getBlobURLWithSASToken(context.Background(), config, "job-doc.docx", sourcePermissions); err == nil {
+		t.Error("getBlobURLWithSASToken accepted an invalid IP range")
+	}
+}
+
+// blockBlobServer is a minimal Blob service storing block blobs in memory.
+// It serves the requests of the block uploads, checking their MD5 hash, of the blob properties
+// and of the ranged downloads, returning the MD5 hash of the ranges.
+// With a token set, it only accepts the requests carrying this Bearer token and issues user delegation keys.
+type blockBlobServer struct {
+	mu       sync.Mutex
+	staged   map[string][]byte
+	blobs    map[string][]byte
+	md5s     map[string]string
+	maxRange int
+	token    string
+	// corrupt changes the first byte of the uploaded blocks and of the downloaded content after computing their MD5 hash.
+	corrupt bool
+	// noRangeMD5 omits the MD5 hash of the downloaded ranges.
+	noRangeMD5 bool
+	// delegationKeys are the expiry times of the user delegation keys issued.
+	delegationKeys []string
+}
+
+// blockBlobDelegationKey is the value of the user delegation keys issued by blockBlobServer.
+var blockBlobDelegationKey = base64.StdEncoding.EncodeToString([]byte("user-delegation-key"))
+
+func (b *blockBlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	name := r.URL.Path
+	query := r.URL.Query()
+	w.Header().Set("ETag", `"0x1"`)
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	if b.token != "" && r.Header.Get("Authorization") != "Bearer "+b.token {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && query.Get("comp") == "userdelegationkey" && b.token != "":
+		var info azblob.KeyInfo
+		xml.NewDecoder(r.Body).Decode(&info)
+		b.delegationKeys = append(b.delegationKeys, info.Expiry)
+		start, _ := time.Parse(azblob.SASTimeFormat, info.Start)
+		expiry, _ := time.Parse(azblob.SASTimeFormat, info.Expiry)
+		key, _ := xml.Marshal(azblob.UserDelegationKey{SignedOid: "oid", SignedTid: "tid", SignedStart: start, SignedExpiry: expiry,
+			SignedService: "b", SignedVersion: azblob.SASVersion, Value: blockBlobDelegationKey})
+		w.Write(key)
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		block, _ := io.ReadAll(r.Body)
+		sum := md5.Sum(block)
+		if b.corrupt && len(block) > 0 {
+			block[0] ^= 0xff
+			sum = md5.Sum(block)
+		}
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.Header().Set("x-ms-error-code", "Md5Mismatch")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b.staged[name+"/"+query.Get("blockid")] = block
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		xml.NewDecoder(r.Body).Decode(&list)
+		var content []byte
+		for _, id := range list.Latest {
+			content = append(content, b.staged[name+"/"+id]...)
+		}
+		b.blobs[name] = content
+		b.md5s[name] = r.Header.Get("x-ms-blob-content-md5")
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "properties":
+		b.md5s[name] = r.Header.Get("x-ms-blob-content-md5")
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		content, ok := b.blobs[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end); err == nil {
+			if end-start+1 > b.maxRange {
+				b.maxRange = end - start + 1
+			}
+			content = content[start:min(end+1, len(content))]
+			if r.Header.Get("x-ms-range-get-content-md5") == "true" && !b.noRangeMD5 {
+				sum := md5.Sum(content)
+				w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+len(content)-1, len(b.blobs[name])))
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-MD5", b.md5s[name])
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.WriteHeader(http.StatusOK)
+		}
+		if r.Method == http.MethodGet {
+			if b.corrupt && len(content) > 0 {
+				content = append([]byte{content[0] ^ 0xff}, content[1:]...)
+			}
+			w.Write(content)
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// TestAzureBlobStorageTransfer is a test function that streams a document in blocks, downloads it with
+// parallel ranged requests and checks the MD5 hash of the blocks and of the ranges.
+func TestAzureBlobStorageTransfer(t *testing.T) {
+	blobServer := &blockBlobServer{staged: map[string][]byte{}, blobs: map[string][]byte{}, md5s: map[string]string{}}
+	server := httptest.NewServer(blobServer)
+	defer server.Close()
+	storage, err := NewAzureBlobStorage(server.URL+"/devstoreaccount1", "devstoreaccount1", azuriteAccountKey, "translator")
+	if err != nil {
+		t.Fatalf("NewAzureBlobStorage failed: %v", err)
+	}
+	storage.Transfer = TransferOptions{BlockSize: 1 << 20, Parallelism: 2}
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 5<<16+1)
+	if err := storage.Put(ctx, "deck.pptx", bytes.NewReader(content)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	sum := md5.Sum(content)
+	if stored := blobServer.md5s["/devstoreaccount1/translator/deck.pptx"]; stored != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Errorf("Put stored the Content-MD5 %q", stored)
+	}
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "deck.pptx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var downloaded, total int64
+	progress := func(bytes, size int64) {
+		downloaded, total = bytes, size
+	}
+	if err := storage.GetFile(ctx, "deck.pptx", file, progress); err != nil {
+		t.Fatalf("GetFile failed: %v", err)
+	}
+	if downloaded != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("GetFile reported %d of %d bytes downloaded, expected %d", downloaded, total, len(content))
+	}
+	if downloaded, _ := os.ReadFile(file.Name()); !bytes.Equal(downloaded, content) {
+		t.Errorf("GetFile downloaded %d bytes, expected the %d bytes uploaded", len(downloaded), len(content))
+	}
+	if blobServer.maxRange != 1<<20 {
+		t.Errorf("GetFile downloaded ranges of up to %d bytes, expected 1 MiB blocks", blobServer.maxRange)
+	}
+
+	var buffer bytes.Buffer
+	if err := storage.Get(ctx, "deck.pptx", &buffer); err != nil || !bytes.Equal(buffer.Bytes(), content) {
+		t.Errorf("Get returned %d bytes, %v", buffer.Len(), err)
+	}
+
+	// A content corrupted in transit is reported by both downloads.
+	blobServer.corrupt = true
+	if err := storage.GetFile(ctx, "deck.pptx", file, progress); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("GetFile returned %v, expected a corruption error", err)
+	}
+	if err := storage.Get(ctx, "deck.pptx", io.Discard); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("Get returned %v, expected a corruption error", err)
+	}
+	blobServer.corrupt = false
+
+	// A content without its MD5 hash cannot be checked.
+	blobServer.noRangeMD5 = true
+	if err := storage.GetFile(ctx, "deck.pptx", file, progress); err == nil || !strings.Contains(err.Error(), "without its MD5 hash") {
+		t.Errorf("GetFile returned %v, expected a missing hash error", err)
+	}
+	if err := storage.Get(ctx, "deck.pptx", io.Discard); err == nil || !strings.Contains(err.Error(), "without its MD5 hash") {
+		t.Errorf("Get returned %v, expected a missing hash error", err)
+	}
+	blobServer.noRangeMD5 = false
+
+	// Ranges are limited to the 4 MiB the service computes the MD5 hash of.
+	blobServer.maxRange = 0
+	storage.Transfer = TransferOptions{BlockSize: 8 << 20, Parallelism: 2}
+	if err := storage.Get(ctx, "deck.pptx", io.Discard); err != nil || blobServer.maxRange != maxRangeMD5Size {
+		t.Errorf("Get returned %v with ranges of up to %d bytes, expected 4 MiB ranges", err, blobServer.maxRange)
+	}
+
+	// A block corrupted in transit is refused by the service.
+	blobServer.corrupt = true
+	if err := storage.Put(ctx, "corrupted.pptx", bytes.NewReader(content)); err == nil || !strings.Contains(err.Error(), "Md5Mismatch") {
+		t.Errorf("Put returned %v, expected an MD5 mismatch", err)
+	}
+	if _, ok := blobServer.blobs["/devstoreaccount1/translator/corrupted.pptx"]; ok {
+		t.Error("Put committed a blob with a corrupted block")
+	}
+	blobServer.corrupt = false
+
+	storage.Transfer = TransferOptions{Parallelism: MaxParallelism + 1}
+	if err := storage.Put(ctx, "deck.pptx", bytes.NewReader(content)); err == nil || !strings.Contains(err.Error(), "parallelism") {
+		t.Errorf("Put returned %v, expected an invalid parallelism error", err)
+	}
+}
+
+// TestAzureBlobStorageUserDelegation is a test function that authenticates the blob requests with a token
+// and signs the URLs with user delegation keys, reused while they outlive the signatures.
+func TestAzureBlobStorageUserDelegation(t *testing.T) {
+	blobServer := &blockBlobServer{staged: map[string][]byte{}, blobs: map[string][]byte{}, md5s: map[string]string{}, token: "storage-token"}
+	server := httptest.NewTLSServer(blobServer)
+	defer server.Close()
+	credential := staticCredential{}
+	token := newBlobToken(credential)
+	storage, err := newAzureBlobStorage(server.URL+"/devstoreaccount1", "devstoreaccount1", "translator", token.token, server.Client())
+	if err != nil {
+		t.Fatalf("newAzureBlobStorage failed: %v", err)
+	}
+	storage.token = token
+	// No token is requested before the first operation, which fails without one.
+	ctx := context.Background()
+	if err := storage.Put(ctx, "doc.docx", strings.NewReader("document")); err == nil {
+		t.Error("Put succeeded without a token")
+	}
+	// The token is obtained from the credential before each operation.
+	credential[storageScope] = "storage-token"
+	if err := storage.Put(ctx, "doc.docx", strings.NewReader("document")); err != nil {
+		t.Fatalf("Put with a token failed: %v", err)
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	signedURL, err := storage.SignedURL(ctx, "doc.docx", Permissions{Read: true}, expiry)
+	if err != nil {
+		t.Fatalf("SignedURL failed: %v", err)
+	}
+	u, _ := url.Parse(signedURL)
+	query := u.Query()
+	if query.Get("skoid") != "oid" || query.Get("sktid") != "tid" || query.Get("sp") != "r" || len(blobServer.delegationKeys) != 1 {
+		t.Errorf("SignedURL returned %q after %d user delegation keys, expected a user delegation SAS", signedURL, len(blobServer.delegationKeys))
+	}
+	// The signature is computed for the configured account, not for the host of the service URL.
+	keyStart, _ := time.Parse(time.RFC3339, query.Get("skt"))
+	keyExpiry, _ := time.Parse(time.RFC3339, query.Get("ske"))
+	sasExpiry, _ := time.Parse(time.RFC3339, query.Get("se"))
+	expected, err := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPS,
+		ExpiryTime:    sasExpiry,
+		ContainerName: "translator",
+		BlobName:      "doc.docx",
+		Permissions:   "r",
+	}.NewSASQueryParameters(azblob.NewUserDelegationCredential("devstoreaccount1", azblob.UserDelegationKey{
+		SignedOid: "oid", SignedTid: "tid", SignedStart: keyStart, SignedExpiry: keyExpiry,
+		SignedService: "b", SignedVersion: azblob.SASVersion, Value: blockBlobDelegationKey,
+	}))
+	if err != nil || expected.Signature() != query.Get("sig") {
+		t.Errorf("SignedURL signed %q, expected %q (%v)", query.Get("sig"), expected.Signature(), err)
+	}
+
+	// The key is reused by the signatures it outlives, and renewed for the others.
+	if _, err := storage.SignedURL(ctx, "", Permissions{Read: true, List: true}, expiry.Add(30*time.Minute)); err != nil || len(blobServer.delegationKeys) != 1 {
+		t.Errorf("SignedURL returned %v after %d user delegation keys, expected the key to be reused", err, len(blobServer.delegationKeys))
+	}
+	if _, err := storage.SignedURL(ctx, "doc.docx", Permissions{Read: true}, expiry.Add(3*time.Hour)); err != nil || len(blobServer.delegationKeys) != 2 {
+		t.Errorf("SignedURL returned %v after %d user delegation keys, expected a new key", err, len(blobServer.delegationKeys))
+	}
+	if _, err := storage.SignedURL(ctx, "doc.docx", Permissions{Read: true}, time.Now().Add(8*24*time.Hour)); err == nil {
+		t.Error("SignedURL accepted a user delegation SAS expiring after 7 days")
+	}
+}
+
+// TestSignedURLBlobError is a test function that checks that a URL that cannot be signed, because no user delegation key
+// can be obtained, is reported as a *BlobError.
+func TestSignedURLBlobError(t *testing.T) {
+	blobServer := &blockBlobServer{staged: map[string][]byte{}, blobs: map[string][]byte{}, md5s: map[string]string{}, token: "storage-token"}
+	server := httptest.NewTLSServer(blobServer)
+	defer server.Close()
+	token := newBlobToken(staticCredential{storageScope: "other-token"})
+	storage, err := newAzureBlobStorage(server.URL+"/devstoreaccount1", "devstoreaccount1", "translator", token.token, server.Client())
+	if err != nil {
+		t.Fatalf("newAzureBlobStorage failed: %v", err)
+	}
+	storage.token = token
+	config := TranslatorConfig{Storage: storage}
+
+	_, err = getBlobURLWithSASToken(context.Background(), config, "doc.docx", sourcePermissions)
+	var blobErr *BlobError
+	if !errors.As(err, &blobErr) || blobErr.Op != "sign" {
+		t.Errorf("getBlobURLWithSASToken returned %v, expected a *BlobError", err)
+	}
+}
@@ -0,0 +1,222 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileFormat is a file format supported by the Document Translation service,
+// as returned by GET /translator/document/formats.
+type FileFormat struct {
+	Format         string   `json:"format"`
+	FileExtensions []string `json:"fileExtensions"`
+	ContentTypes   []string `json:"contentTypes"`
+	DefaultVersion string   `json:"defaultVersion,omitempty"`
+	Versions       []string `json:"versions,omitempty"`
+	Type           string   `json:"type,omitempty"`
+}
+
+// fileFormatsResponse is the list of supported file formats.
+type fileFormatsResponse struct {
+	Value []FileFormat `json:"value"`
+}
+
+// Language is a language supported by the Translator service, as returned by GET /languages.
+type Language struct {
+	Name       string `json:"name"`
+	NativeName string `json:"nativeName"`
+	Dir        string `json:"dir"`
+}
+
+// languagesResponse is the list of the languages supported for translation.
+type languagesResponse struct {
+	Translation map[string]Language `json:"translation"`
+}
+
+// Client is a long-lived Document Translation client built once from a TranslatorConfig.
+// It owns the HTTP client of the Translator service, the staging storage and the logger,
+// so that the translation jobs of a process share their connections and settings.
+// A Client is safe for concurrent use.
+type Client struct {
+	config   TranslatorConfig
+	redactor *Redactor
+}
+
+// NewClient creates a Client from a TranslatorConfig.
+// It takes the TranslatorConfig object, whose Storage, HTTPClient and Logger are created if nil:
+// - the HTTP client uses its own transport and no timeout: the requests are bounded by their context, and the
+// polling of a job by the timeout of the configuration, so that a synchronous translation is not cut at the job timeout,
+// - the storage is the Azure Blob Storage container of the blob settings, it is left nil if no blob account is set,
+// which restricts the client to synchronous translations,
+// - the logger is a logger of the client writing to the output of the standard logrus logger, with its formatter,
+// level and hooks.
+// Unless UnsafeLogSecrets is set, the Redactor of the configuration is added to the hooks of the logger, unless
+// an identical Redactor is already registered on it, and the messages of the errors returned by the client
+// are redacted, so that the SAS signatures and the keys never appear in the logs.
+// It returns the client and an error if the configuration is invalid.
+func NewClient(config TranslatorConfig) (*Client, error) {
+	if config.TranslatorEndpoint == "" {
+		return nil, fmt.Errorf("missing translator endpoint")
+	}
+	if config.SAS.IPRange != "" {
+		if _, _, err := parseIPRange(config.SAS.IPRange); err != nil {
+			return nil, err
+		}
+	}
+	if config.Logger == nil {
+		config.Logger = newLogger(logrus.StandardLogger())
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{
+			Transport: http.DefaultTransport.(*http.Transport).Clone(),
+		}
+	}
+	// Share the credential and the storage across the jobs of the client.
+	config, err := config.withSharedClients()
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{config: config}
+	if !config.UnsafeLogSecrets {
+		client.redactor = config.Redactor()
+		addRedactor(config.Logger, client.redactor)
+	}
+	return client, nil
+}
+
+// newLogger creates a logger with the output, the formatter, the level and the hooks of base,
+// whose hooks can be changed without changing those of base.
+func newLogger(base *logrus.Logger) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(base.Out)
+	logger.SetFormatter(base.Formatter)
+	logger.SetLevel(base.GetLevel())
+	logger.SetReportCaller(base.ReportCaller)
+	for level, hooks := range base.Hooks {
+		logger.Hooks[level] = slices.Clone(hooks)
+	}
+	return logger
+}
+
+// redact redacts the message of an error returned by the client, unless UnsafeLogSecrets is set.
+func (c *Client) redact(err error) error {
+	if c.redactor == nil {
+		return err
+	}
+	return c.redactor.Error(err)
+}
+
+// Config returns the configuration of the client, with its HTTP client, storage and logger set.
+func (c *Client) Config() TranslatorConfig {
+	return c.config
+}
+
+// WithProgress returns a copy of the client reporting its progress events to progress.
+// The copy shares the HTTP client, the storage and the logger of c.
+func (c *Client) WithProgress(progress ProgressReporter) *Client {
+	config := c.config
+	config.Progress = progress
+	return &Client{config: config, redactor: c.redactor}
+}
+
+// Translate translates a document to one or several languages in a single translation job.
+// See TranslateDocumentToLanguagesContext for the parameters and the returned errors.
+func (c *Client) Translate(ctx context.Context, fileToTranslate, destinationTemplate, sourceLanguage string, targetLanguages []string) error {
+	return c.redact(TranslateDocumentToLanguagesContext(ctx, fileToTranslate, destinationTemplate, sourceLanguage, targetLanguages, c.config))
+}
+
+// TranslateFolder translates every document of a local directory tree in a single translation job.
+// See TranslateFolderContext for the parameters and the returned errors.
+func (c *Client) TranslateFolder(ctx context.Context, sourceDir, destinationTemplate, sourceLanguage string, targetLanguages []string, filter *Filter) ([]DocumentResult, error) {
+	results, err := TranslateFolderContext(ctx, sourceDir, destinationTemplate, sourceLanguage, targetLanguages, filter, c.config)
+	return results, c.redact(err)
+}
+
+// Resume resumes a translation job recorded in the job store of the client after its process was interrupted.
+// See ResumeJob for the parameters and the returned errors.
+func (c *Client) Resume(ctx context.Context, jobID string) ([]DocumentResult, error) {
+	results, err := ResumeJob(ctx, jobID, c.config)
+	return results, c.redact(err)
+}
+
+// StoredJobs returns the records of the job store of the client, the most recent first.
+func (c *Client) StoredJobs() ([]JobRecord, error) {
+	if c.config.Jobs == nil {
+		return nil, fmt.Errorf("no job store configured")
+	}
+	return c.config.Jobs.List()
+}
+
+// CollectGarbage deletes the orphaned temporary blobs of the staging storage of the client.
+// See CollectGarbage for the options and the report.
+func (c *Client) CollectGarbage(ctx context.Context, options GCOptions) (*GCReport, error) {
+	report, err := CollectGarbage(ctx, c.config, options)
+	return report, c.redact(err)
+}
+
+// Status returns the status of the translation job jobID.
+func (c *Client) Status(ctx context.Context, jobID string) (*BatchStatus, error) {
+	status, err := getBatchStatus(ctx, c.config, operationURLFromBatchID(c.config, jobID))
+	return status, c.redact(err)
+}
+
+// Documents returns the status of every document of the translation job jobID.
+func (c *Client) Documents(ctx context.Context, jobID string) ([]DocumentStatus, error) {
+	documents, err := getDocumentsStatus(ctx, c.config, operationURLFromBatchID(c.config, jobID))
+	return documents, c.redact(err)
+}
+
+// Cancel cancels the translation job jobID.
+// Documents already being translated are still translated and charged.
+func (c *Client) Cancel(ctx context.Context, jobID string) error {
+	return c.redact(cancelTranslation(ctx, c.config, operationURLFromBatchID(c.config, jobID)))
+}
+
+// ListJobs returns the status of the translation jobs of the Translator resource, the most recent first.
+func (c *Client) ListJobs(ctx context.Context) ([]BatchStatus, error) {
+	jobs, err := getBatchesStatus(ctx, c.config)
+	return jobs, c.redact(err)
+}
+
+// SupportedFormats returns the document formats supported by the Translator service.
+func (c *Client) SupportedFormats(ctx context.Context) ([]FileFormat, error) {
+	formats, err := getSupportedFormats(ctx, c.config, "document")
+	return formats, c.redact(err)
+}
+
+// SupportedGlossaryFormats returns the glossary formats supported by the Translator service.
+func (c *Client) SupportedGlossaryFormats(ctx context.Context) ([]FileFormat, error) {
+	formats, err := getSupportedFormats(ctx, c.config, "glossary")
+	return formats, c.redact(err)
+}
+
+// SupportedLanguages returns the languages supported for translation by the Translator service, by language code.
+func (c *Client) SupportedLanguages(ctx context.Context) (map[string]Language, error) {
+	var languages languagesResponse
+	uri := fmt.Sprintf("%s/translator/text/v3.0/languages?%s", strings.TrimSuffix(c.config.TranslatorEndpoint, "/"),
+		url.Values{"api-version": {"3.0"}, "scope": {"translation"}}.Encode())
+	if err := getJSON(ctx, c.config, uri, &languages); err != nil {
+		return nil, c.redact(fmt.Errorf("error getting supported languages: %v", err))
+	}
+	return languages.Translation, nil
+}
+
+// getSupportedFormats retrieves the file formats of a type ("document" or "glossary") supported by the Translator service.
+func getSupportedFormats(ctx context.Context, config TranslatorConfig, formatType string) ([]FileFormat, error) {
+	var formats fileFormatsResponse
+	uri := translatorURL(config, "/translator/document/formats", url.Values{"type": {formatType}})
+	if err := getJSON(ctx, config, uri, &formats); err != nil {
+		return nil, fmt.Errorf("error getting supported %s formats: %v", formatType, err)
+	}
+	return formats.Value, nil
+}
@@ -0,0 +1,196 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// The kinds of credentials authenticating the requests to the Translator service and to Azure Blob Storage.
+const (
+	// CredentialKey authenticates with the Translator subscription key and the storage account key, the default.
+	CredentialKey = "key"
+	// CredentialClientSecret authenticates a Microsoft Entra ID application with a client secret.
+	CredentialClientSecret = "clientSecret"
+	// CredentialClientCertificate authenticates a Microsoft Entra ID application with a certificate.
+	CredentialClientCertificate = "clientCertificate"
+	// CredentialWorkloadIdentity authenticates a Kubernetes workload with a federated service account token.
+	CredentialWorkloadIdentity = "workloadIdentity"
+	// CredentialManagedIdentity authenticates the managed identity of the Azure host, through its IMDS endpoint.
+	CredentialManagedIdentity = "managedIdentity"
+	// CredentialAzureCLI authenticates the user logged in with the Azure CLI.
+	CredentialAzureCLI = "azureCli"
+)
+
+const (
+	// cognitiveServicesScope is the scope of the tokens of the Translator service in the public cloud.
+	cognitiveServicesScope = "https://cognitiveservices.azure.com/.default"
+	// governmentCognitiveServicesScope is the scope of the tokens of the Translator service in Azure Government.
+	governmentCognitiveServicesScope = "https://cognitiveservices.azure.us/.default"
+	// chinaCognitiveServicesScope is the scope of the tokens of the Translator service in Azure China.
+	chinaCognitiveServicesScope = "https://cognitiveservices.azure.cn/.default"
+	// storageScope is the scope of the tokens of Azure Blob Storage.
+	storageScope = "https://storage.azure.com/.default"
+)
+
+// CredentialOptions select the Microsoft Entra ID (Azure AD) credential authenticating the requests
+// to the Translator service and to Azure Blob Storage instead of their keys.
+// The Translator requests carry a Bearer token, and the signed URLs are user delegation SAS,
+// signed with a key obtained from the storage account with the token rather than with the account key.
+type CredentialOptions struct {
+	// Type is the kind of credential, CredentialKey if empty.
+	Type string `json:"type"`
+	// TenantID is the directory of the application, for the client secret, certificate and workload identity credentials.
+	TenantID string `json:"tenantId,omitempty"`
+	// ClientID is the application ID, or the client ID of a user-assigned managed identity.
+	ClientID string `json:"clientId,omitempty"`
+	// ClientSecret is the secret of the application, for the client secret credential.
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// CertificatePath is the PEM or PKCS#12 file holding the certificate and the private key of the application.
+	CertificatePath string `json:"certificatePath,omitempty"`
+	// CertificatePassword decrypts the certificate file, if it is encrypted.
+	CertificatePassword string `json:"certificatePassword,omitempty"`
+	// TokenFilePath is the file of the service account token of the workload identity, AZURE_FEDERATED_TOKEN_FILE if empty.
+	TokenFilePath string `json:"tokenFilePath,omitempty"`
+	// AuthorityHost is the Microsoft Entra ID host of a sovereign cloud, e.g. https://login.chinacloudapi.cn/,
+	// the public cloud if empty.
+	AuthorityHost string `json:"authorityHost,omitempty"`
+	// Scope is the scope of the tokens of the Translator service, derived from AuthorityHost if empty, see TranslatorScope.
+	Scope string `json:"scope,omitempty"`
+}
+
+// TranslatorScope returns the scope of the tokens of the Translator service: Scope if set, otherwise the scope
+// of the cloud of AuthorityHost for Azure Government and Azure China, or of the public cloud.
+func (o CredentialOptions) TranslatorScope() string {
+	if o.Scope != "" {
+		return o.Scope
+	}
+	switch strings.TrimSuffix(strings.ToLower(o.AuthorityHost), "/") {
+	case strings.TrimSuffix(cloud.AzureGovernment.ActiveDirectoryAuthorityHost, "/"):
+		return governmentCognitiveServicesScope
+	case strings.TrimSuffix(cloud.AzureChina.ActiveDirectoryAuthorityHost, "/"):
+		return chinaCognitiveServicesScope
+	}
+	return cognitiveServicesScope
+}
+
+// UsesKeys reports whether the options authenticate with the keys rather than with a Microsoft Entra ID credential.
+func (o CredentialOptions) UsesKeys() bool {
+	return o.Type == "" || o.Type == CredentialKey
+}
+
+// NewTokenCredential creates the Microsoft Entra ID credential selected by options.
+// It takes the following parameters:
+// - options: The kind of credential and its settings.
+// - httpClient: The HTTP client sending the token requests, the default client of the Azure SDK if nil.
+// It returns nil if options select the keys, and an error if the kind is unknown or its settings are incomplete.
+func NewTokenCredential(options CredentialOptions, httpClient *http.Client) (azcore.TokenCredential, error) {
+	clientOptions := azcore.ClientOptions{}
+	if httpClient != nil {
+		clientOptions.Transport = httpClient
+	}
+	if options.AuthorityHost != "" {
+		clientOptions.Cloud = cloud.Configuration{ActiveDirectoryAuthorityHost: options.AuthorityHost}
+	}
+
+	missing := func(fields ...string) error {
+		return fmt.Errorf("the %s credential requires %s", options.Type, strings.Join(fields, ", "))
+	}
+	switch options.Type {
+	case "", CredentialKey:
+		return nil, nil
+	case CredentialClientSecret:
+		if options.TenantID == "" || options.ClientID == "" || options.ClientSecret == "" {
+			return nil, missing("tenantId", "clientId", "clientSecret")
+		}
+		return azidentity.NewClientSecretCredential(options.TenantID, options.ClientID, options.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+	case CredentialClientCertificate:
+		if options.TenantID == "" || options.ClientID == "" || options.CertificatePath == "" {
+			return nil, missing("tenantId", "clientId", "certificatePath")
+		}
+		data, err := os.ReadFile(options.CertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading certificate: %v", err)
+		}
+		certificates, key, err := azidentity.ParseCertificates(data, []byte(options.CertificatePassword))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate %s: %v", options.CertificatePath, err)
+		}
+		return azidentity.NewClientCertificateCredential(options.TenantID, options.ClientID, certificates, key,
+			&azidentity.ClientCertificateCredentialOptions{ClientOptions: clientOptions})
+	case CredentialWorkloadIdentity:
+		// The tenant, the client ID and the token file default to the variables set by the workload identity webhook.
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      options.TenantID,
+			ClientID:      options.ClientID,
+			TokenFilePath: options.TokenFilePath,
+		})
+	case CredentialManagedIdentity:
+		managedOptions := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if options.ClientID != "" {
+			managedOptions.ID = azidentity.ClientID(options.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(managedOptions)
+	case CredentialAzureCLI:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: options.TenantID})
+	default:
+		return nil, fmt.Errorf("unknown credential type %q, expected %s, %s, %s, %s, %s or %s", options.Type, CredentialKey,
+			CredentialClientSecret, CredentialClientCertificate, CredentialWorkloadIdentity, CredentialManagedIdentity, CredentialAzureCLI)
+	}
+}
+
+// tokenCredential returns the Microsoft Entra ID credential of the configuration: its TokenCredential if set,
+// otherwise a new credential created from its Credential options, or nil if it authenticates with the keys.
+func (config TranslatorConfig) tokenCredential() (azcore.TokenCredential, error) {
+	if config.TokenCredential != nil {
+		return config.TokenCredential, nil
+	}
+	return NewTokenCredential(config.Credential, config.HTTPClient)
+}
+
+// getToken returns a token of credential for scope.
+func getToken(ctx context.Context, credential azcore.TokenCredential, scope string) (string, error) {
+	token, err := credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}})
+	if err != nil {
+		return "", fmt.Errorf("error getting an access token for %s: %v", scope, err)
+	}
+	return token.Token, nil
+}
+
+// blobToken authenticates the storage requests with the tokens of a Microsoft Entra ID credential.
+// The token is set on demand before each operation rather than by a background timer:
+// the credential caches it and only requests a new one when it nears its expiry.
+type blobToken struct {
+	credential azcore.TokenCredential
+	token      azblob.TokenCredential
+}
+
+// newBlobToken returns the blobToken of credential, without a token: the first token is obtained
+// by the first operation, with its context, see refresh.
+func newBlobToken(credential azcore.TokenCredential) *blobToken {
+	return &blobToken{credential: credential, token: azblob.NewTokenCredential("", nil)}
+}
+
+// refresh sets the current token of the credential, obtained from its cache if it is still valid.
+func (t *blobToken) refresh(ctx context.Context) error {
+	token, err := getToken(ctx, t.credential, storageScope)
+	if err != nil {
+		return err
+	}
+	t.token.SetToken(token)
+	return nil
+}
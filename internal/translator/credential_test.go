@@ -0,0 +1,271 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the Microsoft Entra ID credentials, against a local fake token endpoint.
+*/
+
+package translator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// fakeTokenServer is a local Microsoft Entra ID token endpoint and IMDS endpoint.
+// It answers the metadata requests of the authority, and issues a token per client authentication method.
+type fakeTokenServer struct {
+	mu sync.Mutex
+	// forms are the forms of the token requests received, or their query for IMDS.
+	forms []url.Values
+}
+
+func (f *fakeTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	authority := "https://" + r.Host
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/discovery/instance"):
+		tenant := strings.Split(r.URL.Query().Get("authorization_endpoint"), "/")[3]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tenant_discovery_endpoint": fmt.Sprintf("%s/%s/v2.0/.well-known/openid-configuration", authority, tenant),
+			"api-version":               "1.1",
+			"metadata":                  []interface{}{},
+		})
+	case strings.HasSuffix(r.URL.Path, "/.well-known/openid-configuration"):
+		tenant := strings.Split(r.URL.Path, "/")[1]
+		json.NewEncoder(w).Encode(map[string]string{
+			"token_endpoint":         fmt.Sprintf("%s/%s/oauth2/v2.0/token", authority, tenant),
+			"authorization_endpoint": fmt.Sprintf("%s/%s/oauth2/v2.0/authorize", authority, tenant),
+			"issuer":                 fmt.Sprintf("%s/%s/v2.0", authority, tenant),
+		})
+	case strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token"):
+		r.ParseForm()
+		f.forms = append(f.forms, r.PostForm)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"access_token": "aad-" + r.PostForm.Get("client_id") + "-token",
+		})
+	case r.URL.Path == "/metadata/identity/oauth2/token" && r.Header.Get("Metadata") == "true":
+		f.forms = append(f.forms, r.URL.Query())
+		json.NewEncoder(w).Encode(map[string]string{
+			"token_type":   "Bearer",
+			"expires_in":   "3600",
+			"expires_on":   fmt.Sprint(time.Now().Add(time.Hour).Unix()),
+			"resource":     r.URL.Query().Get("resource"),
+			"access_token": "imds-token",
+		})
+	default:
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+	}
+}
+
+// lastForm returns the last token request received.
+func (f *fakeTokenServer) lastForm() url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.forms) == 0 {
+		return url.Values{}
+	}
+	return f.forms[len(f.forms)-1]
+}
+
+// redirectTransport sends every request to a local server, whatever its host, as if it were the real endpoint.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// writeCertificate writes a self-signed certificate and its private key to a PEM file and returns its path.
+func writeCertificate(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "translator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKey})...)
+	path := filepath.Join(t.TempDir(), "translator.pem")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestNewTokenCredential is a test function that obtains tokens with each kind of credential from a local fake token endpoint.
+func TestNewTokenCredential(t *testing.T) {
+	tokens := &fakeTokenServer{}
+	server := httptest.NewServer(tokens)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	httpClient := &http.Client{Transport: redirectTransport{target: target}}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("service-account-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	azDir := t.TempDir()
+	az := "#!/bin/sh\necho '{\"accessToken\":\"cli-token\",\"expires_on\":" + fmt.Sprint(time.Now().Add(time.Hour).Unix()) + "}'\n"
+	if err := os.WriteFile(filepath.Join(azDir, "az"), []byte(az), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", azDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		options CredentialOptions
+		token   string
+		check   func(form url.Values) bool
+	}{
+		{
+			CredentialOptions{Type: CredentialClientSecret, TenantID: "tenant", ClientID: "secret-app", ClientSecret: "s3cr3t"},
+			"aad-secret-app-token",
+			func(form url.Values) bool {
+				return form.Get("client_secret") == "s3cr3t" && strings.HasPrefix(form.Get("scope"), cognitiveServicesScope)
+			},
+		},
+		{
+			CredentialOptions{Type: CredentialClientCertificate, TenantID: "tenant", ClientID: "certificate-app", CertificatePath: writeCertificate(t)},
+			"aad-certificate-app-token",
+			func(form url.Values) bool {
+				return strings.Count(form.Get("client_assertion"), ".") == 2 && form.Get("client_secret") == ""
+			},
+		},
+		{
+			CredentialOptions{Type: CredentialWorkloadIdentity, TenantID: "tenant", ClientID: "workload-app", TokenFilePath: tokenFile},
+			"aad-workload-app-token",
+			func(form url.Values) bool {
+				return form.Get("client_assertion") == "service-account-token"
+			},
+		},
+		{
+			CredentialOptions{Type: CredentialManagedIdentity, ClientID: "identity"},
+			"imds-token",
+			func(form url.Values) bool {
+				return form.Get("client_id") == "identity" && form.Get("resource") == "https://cognitiveservices.azure.com"
+			},
+		},
+		{
+			CredentialOptions{Type: CredentialAzureCLI},
+			"cli-token",
+			func(form url.Values) bool { return true },
+		},
+	}
+	for _, test := range tests {
+		credential, err := NewTokenCredential(test.options, httpClient)
+		if err != nil {
+			t.Fatalf("NewTokenCredential(%s) failed: %v", test.options.Type, err)
+		}
+		token, err := getToken(context.Background(), credential, cognitiveServicesScope)
+		if err != nil {
+			t.Fatalf("%s credential failed: %v", test.options.Type, err)
+		}
+		if token != test.token || !test.check(tokens.lastForm()) {
+			t.Errorf("%s credential returned %q after the request %v, expected %q", test.options.Type, token, tokens.lastForm(), test.token)
+		}
+	}
+
+	if credential, err := NewTokenCredential(CredentialOptions{}, nil); credential != nil || err != nil {
+		t.Errorf("NewTokenCredential of the keys returned %v, %v, expected no credential", credential, err)
+	}
+	invalid := []CredentialOptions{
+		{Type: "password"},
+		{Type: CredentialClientSecret, TenantID: "tenant", ClientID: "app"},
+		{Type: CredentialClientCertificate, TenantID: "tenant", ClientID: "app", CertificatePath: tokenFile},
+	}
+	for _, options := range invalid {
+		if _, err := NewTokenCredential(options, nil); err == nil {
+			t.Errorf("NewTokenCredential accepted %+v", options)
+		}
+	}
+}
+
+// staticCredential is a credential returning a fixed token per scope.
+type staticCredential map[string]string
+
+func (c staticCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	token, ok := c[options.Scopes[0]]
+	if !ok {
+		return azcore.AccessToken{}, fmt.Errorf("no token for %v", options.Scopes)
+	}
+	return azcore.AccessToken{Token: token, ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// TestTranslatorRequestCredential is a test function that authenticates the Translator requests with a Bearer token instead of the key.
+func TestTranslatorRequestCredential(t *testing.T) {
+	config := TranslatorConfig{TranslatorKey: "key", TranslatorRegion: "westeurope"}
+	req, err := newTranslatorRequest(context.Background(), config, http.MethodGet, "https://translator.example/", nil)
+	if err != nil || req.Header.Get("Ocp-Apim-Subscription-Key") != "key" || req.Header.Get("Authorization") != "" {
+		t.Errorf("newTranslatorRequest with a key returned the headers %v, %v", req.Header, err)
+	}
+
+	config.TokenCredential = staticCredential{cognitiveServicesScope: "translator-token"}
+	req, err = newTranslatorRequest(context.Background(), config, http.MethodGet, "https://translator.example/", nil)
+	if err != nil || req.Header.Get("Authorization") != "Bearer translator-token" || req.Header.Get("Ocp-Apim-Subscription-Key") != "" ||
+		req.Header.Get("Ocp-Apim-Subscription-Region") != "westeurope" {
+		t.Errorf("newTranslatorRequest with a credential returned the headers %v, %v", req.Header, err)
+	}
+
+	config.TokenCredential = staticCredential{}
+	if _, err := newTranslatorRequest(context.Background(), config, http.MethodGet, "https://translator.example/", nil); err == nil {
+		t.Error("newTranslatorRequest succeeded without a token")
+	}
+}
+
+// TestTranslatorScope is a test function that checks the scope of the Translator tokens of each cloud.
+func TestTranslatorScope(t *testing.T) {
+	tests := []struct {
+		options  CredentialOptions
+		expected string
+	}{
+		{CredentialOptions{}, cognitiveServicesScope},
+		{CredentialOptions{AuthorityHost: "https://login.microsoftonline.us/"}, governmentCognitiveServicesScope},
+		{CredentialOptions{AuthorityHost: "https://login.chinacloudapi.cn"}, chinaCognitiveServicesScope},
+		{CredentialOptions{AuthorityHost: "https://login.chinacloudapi.cn/", Scope: "https://translator.example/.default"}, "https://translator.example/.default"},
+	}
+	for _, test := range tests {
+		if scope := test.options.TranslatorScope(); scope != test.expected {
+			t.Errorf("TranslatorScope(%+v) returned %q, expected %q", test.options, scope, test.expected)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SubmissionError is returned when the Translator service rejects a batch submission.
+type SubmissionError struct {
+	StatusCode int
+	Status     string
+	Err        *ServiceError
+}
+
+func (e *SubmissionError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("translation request rejected with status %s: %s", e.Status, e.Err.String())
+	}
+	return fmt.Sprintf("translation request rejected with status %s", e.Status)
+}
+
+// CancelError is returned when the Translator service does not accept the cancellation of a translation job.
+type CancelError struct {
+	JobID      string
+	StatusCode int
+	Status     string
+	Err        *ServiceError
+}
+
+func (e *CancelError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("cancellation of translation job %s rejected with status %s: %s", e.JobID, e.Status, e.Err.String())
+	}
+	return fmt.Sprintf("cancellation of translation job %s rejected with status %s", e.JobID, e.Status)
+}
+
+// JobError is returned when a translation job ends in a status other than Succeeded.
+type JobError struct {
+	JobID  string
+	Status Status
+	Err    *ServiceError
+}
+
+func (e *JobError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("translation job %s %s: %s", e.JobID, e.Status, e.Err.String())
+	}
+	return fmt.Sprintf("translation job %s %s", e.JobID, e.Status)
+}
+
+// DocumentError is returned when a document of a translation job could not be translated.
+type DocumentError struct {
+	JobID      string
+	DocumentID string
+	Language   string
+	Status     Status
+	Err        *ServiceError
+}
+
+func (e *DocumentError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("document %s translation to %s %s: %s", e.DocumentID, e.Language, e.Status, e.Err.String())
+	}
+	return fmt.Sprintf("document %s translation to %s %s", e.DocumentID, e.Language, e.Status)
+}
+
+// TimeoutError is returned when a translation job does not complete within the configured timeout.
+type TimeoutError struct {
+	JobID   string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout waiting for translation job %s after %s", e.JobID, e.Timeout)
+}
+
+// runningJobError wraps the error of a translation that ended without collecting the outcome of its submitted job:
+// the job could not be cancelled and may still be running on the service, or its status could not be retrieved.
+// The temporary blobs of such a job are kept.
+type runningJobError struct {
+	err error
+}
+
+func (e *runningJobError) Error() string {
+	return e.err.Error()
+}
+
+func (e *runningJobError) Unwrap() error {
+	return e.err
+}
+
+// BlobError is returned when an operation on the blob storage fails.
+type BlobError struct {
+	Op   string
+	Blob string
+	Err  error
+}
+
+func (e *BlobError) Error() string {
+	return fmt.Sprintf("error during blob %s of %s: %v", e.Op, e.Blob, e.Err)
+}
+
+func (e *BlobError) Unwrap() error {
+	return e.Err
+}
+
+// The kinds of the errors recorded in the job store, see errorKind.
+const (
+	errorKindSubmission = "submission"
+	errorKindJob        = "job"
+	errorKindDocument   = "document"
+	errorKindTimeout    = "timeout"
+	errorKindBlob       = "blob"
+	errorKindCancelled  = "cancelled"
+)
+
+// errorKind returns the kind of a translation error recorded with its job: the first of its types, checked in the order
+// of the exit codes of the command-line, or empty if it has none.
+func errorKind(err error) string {
+	var submissionErr *SubmissionError
+	var jobErr *JobError
+	var documentErr *DocumentError
+	var timeoutErr *TimeoutError
+	var blobErr *BlobError
+	switch {
+	case errors.As(err, &submissionErr):
+		return errorKindSubmission
+	case errors.As(err, &jobErr):
+		return errorKindJob
+	case errors.As(err, &documentErr):
+		return errorKindDocument
+	case errors.As(err, &timeoutErr):
+		return errorKindTimeout
+	case errors.As(err, &blobErr):
+		return errorKindBlob
+	case errors.Is(err, context.Canceled):
+		return errorKindCancelled
+	}
+	return ""
+}
+
+// recordedError rebuilds the error that ended a job from its record.
+// The returned error has the recorded message and wraps an error of the recorded kind, so that errors.As
+// and errors.Is see the type of the original error, whose other fields are not recorded.
+func recordedError(record *JobRecord) error {
+	var err error
+	switch record.ErrorKind {
+	case errorKindSubmission:
+		err = &SubmissionError{}
+	case errorKindJob:
+		err = &JobError{JobID: record.BatchID}
+	case errorKindDocument:
+		err = &DocumentError{JobID: record.BatchID}
+	case errorKindTimeout:
+		err = &TimeoutError{JobID: record.BatchID}
+	case errorKindBlob:
+		err = &BlobError{Err: errors.New(record.Error)}
+	case errorKindCancelled:
+		err = context.Canceled
+	default:
+		return errors.New(record.Error)
+	}
+	return &restoredError{message: record.Error, err: err}
+}
+
+// restoredError is an error rebuilt from a job record, with the message of the original error.
+type restoredError struct {
+	message string
+	err     error
+}
+
+func (e *restoredError) Error() string {
+	return e.message
+}
+
+func (e *restoredError) Unwrap() error {
+	return e.err
+}
+
+// newSubmissionError builds a SubmissionError from a non-2xx response of the Translator service.
+// The Azure error body {"error": {"code", "message", "innerError"}} is parsed when present.
+func newSubmissionError(res *http.Response) *SubmissionError {
+	return &SubmissionError{StatusCode: res.StatusCode, Status: res.Status, Err: readServiceError(res)}
+}
+
+// newCancelError builds a CancelError for the job jobID from a non-2xx response of the Translator service.
+// The Azure error body is parsed when present, as for newSubmissionError.
+func newCancelError(jobID string, res *http.Response) *CancelError {
+	return &CancelError{JobID: jobID, StatusCode: res.StatusCode, Status: res.Status, Err: readServiceError(res)}
+}
+
+// readServiceError reads the Azure error body {"error": {"code", "message", "innerError"}} of a response.
+// A body that is not an Azure error is returned as the message of the error, and nil is returned for an empty body.
+func readServiceError(res *http.Response) *ServiceError {
+	body, err := io.ReadAll(res.Body)
+	if err != nil || len(body) == 0 {
+		return nil
+	}
+	var errorResponse struct {
+		Error *ServiceError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errorResponse); err == nil && errorResponse.Error != nil {
+		return errorResponse.Error
+	}
+	return &ServiceError{Message: string(body)}
+}
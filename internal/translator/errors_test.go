@@ -0,0 +1,57 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the typed errors of the translator package.
+*/
+
+package translator
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestNewSubmissionError is a test function that tests the parsing of the Azure error body of a rejected submission.
+func TestNewSubmissionError(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Status:     "400 Bad Request",
+		Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"InvalidRequest","message":"Target language is not supported","innerError":{"code":"InvalidTargetDocumentLanguage","message":"xx is not a supported language"}}}`)),
+	}
+
+	err := newSubmissionError(res)
+	if err.StatusCode != http.StatusBadRequest {
+		t.Errorf("newSubmissionError returned status code %d, expected 400", err.StatusCode)
+	}
+	if err.Err == nil || err.Err.Code != "InvalidRequest" || err.Err.InnerError.Code != "InvalidTargetDocumentLanguage" {
+		t.Errorf("newSubmissionError did not parse the error body: %+v", err.Err)
+	}
+
+	// A body that is not an Azure error is kept as the message
+	res.Body = io.NopCloser(strings.NewReader("Access denied"))
+	err = newSubmissionError(res)
+	if err.Err == nil || err.Err.Message != "Access denied" {
+		t.Errorf("newSubmissionError did not keep the raw body: %+v", err.Err)
+	}
+}
+
+// TestNewCancelError is a test function that checks that a rejected cancellation carries the Azure error of its response.
+func TestNewCancelError(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Status:     "404 Not Found",
+		Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"NotFound","message":"Resource not found"}}`)),
+	}
+
+	err := newCancelError("batch", res)
+	if err.JobID != "batch" || err.StatusCode != http.StatusNotFound || err.Err == nil || err.Err.Code != "NotFound" {
+		t.Errorf("newCancelError returned %+v", err)
+	}
+	if !strings.Contains(err.Error(), "cancellation of translation job batch rejected") {
+		t.Errorf("newCancelError returned the message %q", err.Error())
+	}
+}
@@ -0,0 +1,369 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DocumentResult is the outcome of the translation of one document of a folder to one language.
+type DocumentResult struct {
+	SourcePath       string
+	Language         string
+	Status           Status
+	DestinationFile  string
+	CharacterCharged int64
+	Error            *ServiceError
+}
+
+// uploadFolderToBlobStorage uploads the files of a local directory tree to the staging storage.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object.
+// - sourceDir: The local directory to upload.
+// - blobPrefix: The prefix of the blob names, the relative path of each file is appended to it.
+// - filter: The prefix and suffix the relative path of a file must match to be uploaded, may be nil.
+// It returns the number of uploaded files and an error if any.
+func uploadFolderToBlobStorage(ctx context.Context, config TranslatorConfig, sourceDir, blobPrefix string, filter *Filter) (int, error) {
+	count := 0
+	err := filepath.WalkDir(sourceDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(sourceDir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if filter != nil && (!strings.HasPrefix(rel, filter.Prefix) || !strings.HasSuffix(rel, filter.Suffix)) {
+			return nil
+		}
+		blobName := blobPrefix + rel
+		if err := uploadFileToBlobStorage(ctx, config, filePath, blobName); err != nil {
+			return &BlobError{Op: "upload", Blob: blobName, Err: err}
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// blobNameFromURL extracts the blob name starting at prefix from a blob URL returned by the service.
+// The URL is decoded, and prefix is matched regardless of its case, since the service may change the case
+// of the language of a target folder; the returned name starts with prefix as given.
+// It returns an empty string if the URL does not contain prefix.
+func blobNameFromURL(blobURL, prefix string) string {
+	u, err := url.Parse(blobURL)
+	if err != nil {
+		return ""
+	}
+	needle := "/" + prefix
+	for index := 0; index+len(needle) <= len(u.Path); index++ {
+		if strings.EqualFold(u.Path[index:index+len(needle)], needle) {
+			return prefix + u.Path[index+len(needle):]
+		}
+	}
+	return ""
+}
+
+// TranslateFolder translates every document of a local directory tree in a single translation job.
+// It is a shorthand for TranslateFolderContext with a background context.
+func TranslateFolder(sourceDir, destinationTemplate, sourceLanguage string, targetLanguages []string, filter *Filter, config TranslatorConfig) ([]DocumentResult, error) {
+	return TranslateFolderContext(context.Background(), sourceDir, destinationTemplate, sourceLanguage, targetLanguages, filter, config)
+}
+
+// TranslateFolderContext translates every document of a local directory tree in a single translation job.
+// It takes the following parameters:
+// - ctx: The context of the translation, cancelling it cancels the translation job and deletes the job files.
+// - sourceDir: The local directory containing the documents to translate.
+// - destinationTemplate: The template of the output directory, see OutputPath. It must contain {lang} when several target languages are given.
+// - sourceLanguage: The language of the source documents if the provided string has zero length, the service will attempt to auto-detect the language.
+// - targetLanguages: The languages to translate the documents to.
+// - filter: The prefix and suffix the relative path of a document must match to be translated, may be nil.
+// - config: The TranslatorConfig object.
+// It returns the result of each document translation and an error if any.
+// The translated documents are written to the output directory with the same relative paths as their source.
+// The process of translation involves the following steps:
+// 1. Generate a UUID for the translation job.
+// 2. Upload the directory tree and the glossary, if any, to the staging storage under a job prefix,
+// in a container created for the job if the storage implements ContainerStorage.
+// 3. Generate a JSON document for the translation of the folder with one target folder per language.
+// 4. Translate the documents.
+// 5. Poll the job status until the translation completes and download the translated documents.
+// 6. Delete the job files from the staging storage, on every exit path unless KeepBlobs is set.
+func TranslateFolderContext(ctx context.Context, sourceDir, destinationTemplate, sourceLanguage string, targetLanguages []string, filter *Filter, config TranslatorConfig) (results []DocumentResult, err error) {
+	if len(targetLanguages) == 0 {
+		return nil, fmt.Errorf("no target language")
+	}
+	if len(targetLanguages) > 1 && !strings.Contains(destinationTemplate, "{lang}") {
+		return nil, fmt.Errorf("destination %q must contain {lang} when translating to several languages", destinationTemplate)
+	}
+
+	if config.GlossaryFile != "" {
+		if _, err := GlossaryFormat(config.GlossaryFile, config.GlossaryFormat); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the credential and the storage once for all the operations of the job.
+	config, err = config.withSharedClients()
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate a UUID for the translation job.
+	jobID := generateUUIDv4WithoutHyphens()
+	config.Logger.Debugf("Starting folder translation job %s", jobID)
+	srcPrefix := fmt.Sprintf("%s-source/", jobID)
+	targets := make([]JobTarget, 0, len(targetLanguages))
+	for _, language := range targetLanguages {
+		targets = append(targets, JobTarget{
+			Language:        language,
+			Blob:            fmt.Sprintf("%s-translated-%s/", jobID, language),
+			DestinationFile: OutputPath(destinationTemplate, "", language),
+		})
+	}
+	now := time.Now().UTC()
+	record := &JobRecord{ID: jobID, Source: sourceDir, Folder: true, SourceBlob: srcPrefix, SourceLanguage: sourceLanguage, Targets: targets, State: JobUploading, Created: now}
+	if _, ok := config.Storage.(ContainerStorage); ok {
+		record.Container = jobContainerPrefix + jobID
+	}
+	config.saveJob(record)
+
+	// Delete the job files on every exit path, even if the context was cancelled or a panic occurred.
+	// endJob keeps the storage of the configuration, from which the container of the job is deleted.
+	defer config.endJob(ctx, record, &err)
+	config, err = config.jobStorage(ctx, record, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Upload the directory tree to Azure Blob Storage.
+	count, err := uploadFolderToBlobStorage(ctx, config, sourceDir, srcPrefix, filter)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("no document to translate in %s", sourceDir)
+	}
+	return translateUploadedFolder(ctx, config, record, filter)
+}
+
+// translateUploadedFolder submits the translation of the documents of a job uploaded under its source prefix,
+// waits for the job to complete and downloads the translated documents.
+func translateUploadedFolder(ctx context.Context, config TranslatorConfig, record *JobRecord, filter *Filter) ([]DocumentResult, error) {
+	containerSASurl, err := getBlobURLWithSASToken(ctx, config, "", sourceFolderPermissions)
+	if err != nil {
+		return nil, fmt.Errorf("error generating container SAS token: %w", err)
+	}
+	config.Logger.Debugf("containerSASurl: %s", containerSASurl)
+
+	glossaries, err := uploadGlossary(ctx, config, record.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]Target, 0, len(record.Targets))
+	for _, target := range record.Targets {
+		targetSASUrl, err := getBlobURLWithSASToken(ctx, config, target.Blob, targetFolderPermissions)
+		if err != nil {
+			return nil, fmt.Errorf("error generating target SAS URL: %w", err)
+		}
+		config.Logger.Debugf("targetSASUrl (%s): %s", target.Language, targetSASUrl)
+		targets = append(targets, Target{TargetURL: targetSASUrl, Language: target.Language, Category: config.Category(target.Language), Glossaries: glossaries})
+	}
+
+	// The service filters on the full blob name, which starts with the job prefix.
+	serviceFilter := &Filter{Prefix: record.SourceBlob}
+	if filter != nil {
+		serviceFilter.Prefix += filter.Prefix
+		serviceFilter.Suffix = filter.Suffix
+	}
+	jsonDocument, err := generateFolderJSONDocument(containerSASurl, serviceFilter, record.SourceLanguage, targets)
+	if err != nil {
+		return nil, fmt.Errorf("error generating JSON document: %v", err)
+	}
+
+	operationURL, err := submitTranslation(ctx, config, jsonDocument)
+	if err != nil {
+		return nil, err
+	}
+	record.State = JobSubmitted
+	record.OperationURL = operationURL
+	record.BatchID = batchIDFromOperationURL(operationURL)
+	config.saveJob(record)
+	status, err := waitForBatch(ctx, config, operationURL)
+	if err != nil {
+		return nil, err
+	}
+	return finishFolderTranslation(ctx, config, record, status)
+}
+
+// finishFolderTranslation handles a folder translation job that reached a terminal status.
+// It downloads the documents that were translated successfully to the output directory of their language.
+// It returns the result of each document, and the job error or else the first document or download error.
+// An error getting the documents status is wrapped in a *runningJobError, so that the job can be resumed.
+func finishFolderTranslation(ctx context.Context, config TranslatorConfig, record *JobRecord, status *BatchStatus) ([]DocumentResult, error) {
+	documents, err := getDocumentsStatus(ctx, config, record.OperationURL)
+	if err != nil {
+		return nil, &runningJobError{err: err}
+	}
+	// The languages of the documents are matched with those of the targets regardless of their case.
+	targets := map[string]JobTarget{}
+	for _, target := range record.Targets {
+		targets[strings.ToLower(target.Language)] = target
+	}
+	srcPrefix := record.SourceBlob
+
+	// Mirror the translated tree to the output directory.
+	results := make([]DocumentResult, 0, len(documents))
+	var firstErr error
+	for _, document := range documents {
+		rel := strings.TrimPrefix(blobNameFromURL(document.SourcePath, srcPrefix), srcPrefix)
+		result := DocumentResult{
+			SourcePath:       rel,
+			Language:         document.To,
+			Status:           document.Status,
+			CharacterCharged: document.CharacterCharged,
+			Error:            document.Error,
+		}
+		target, known := targets[strings.ToLower(document.To)]
+		if document.Status == StatusSucceeded {
+			if err := downloadFolderDocument(ctx, config, record, document, target, known, &result); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		} else if firstErr == nil {
+			firstErr = &DocumentError{JobID: status.ID, DocumentID: document.ID, Language: document.To, Status: document.Status, Err: document.Error}
+		}
+		results = append(results, result)
+	}
+
+	if status.Status != StatusSucceeded && (status.Error != nil || firstErr == nil) {
+		return results, &JobError{JobID: status.ID, Status: status.Status, Err: status.Error}
+	}
+	return results, firstErr
+}
+
+// downloadFolderDocument downloads a translated document of a folder job to the output directory of its target,
+// with the relative path of its source, and sets the destination file of its result.
+// known reports whether the language of the document is the language of target.
+// It returns an error if the document has no target, its source or translated blob cannot be resolved
+// from the paths returned by the service or its relative path leaves the output directory,
+// and a *BlobError if the download fails.
+func downloadFolderDocument(ctx context.Context, config TranslatorConfig, record *JobRecord, document DocumentStatus, target JobTarget, known bool, result *DocumentResult) error {
+	if !known {
+		return fmt.Errorf("translation job %s returned a document in %s, which is not a target language", record.BatchID, document.To)
+	}
+	if result.SourcePath == "" {
+		return fmt.Errorf("source %s of document %s of translation job %s is not under the job folder %s", document.SourcePath, document.ID, record.BatchID, record.SourceBlob)
+	}
+	blobName := blobNameFromURL(document.Path, target.Blob)
+	if blobName == "" {
+		return fmt.Errorf("translated document %s of translation job %s is not under the target folder %s", document.Path, record.BatchID, target.Blob)
+	}
+	// The source path is decoded from a URL returned by the service, it must not escape the output directory.
+	rel := path.Clean(result.SourcePath)
+	if path.IsAbs(rel) || filepath.IsAbs(filepath.FromSlash(rel)) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("source %s of document %s of translation job %s is outside of the output directory", result.SourcePath, document.ID, record.BatchID)
+	}
+	result.DestinationFile = filepath.Join(target.DestinationFile, filepath.FromSlash(rel))
+	if err := downloadFileFromBlobStorage(ctx, config, result.DestinationFile, blobName); err != nil {
+		return &BlobError{Op: "download", Blob: blobName, Err: err}
+	}
+	return nil
+}
+
+// jobContainerPrefix is the prefix of the containers of the folder translation jobs, followed by the job ID.
+const jobContainerPrefix = "translator-job-"
+
+// jobStorage returns the configuration whose storage is the container of a folder translation job,
+// or the configuration unchanged if the job has no container of its own.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - record: The record of the job.
+// - create: Whether to create the container.
+// If the credentials of the storage are not authorized to create the container, the job falls back
+// to the configured container, whose whole content the container SAS of the job then gives access to.
+// It returns an error if the storage does not implement ContainerStorage or the container cannot be created.
+func (config TranslatorConfig) jobStorage(ctx context.Context, record *JobRecord, create bool) (TranslatorConfig, error) {
+	if record.Container == "" {
+		return config, nil
+	}
+	containers, err := config.containerStorage()
+	if err != nil {
+		return config, err
+	}
+	if create {
+		err := containers.CreateContainer(ctx, record.Container)
+		if errors.Is(err, ErrForbidden) {
+			config.Logger.Warnf("Cannot create the container %s of translation job %s, falling back to the configured container, "+
+				"to all of whose blobs the SAS of the job give access: %v", record.Container, record.ID, err)
+			record.Container = ""
+			config.saveJob(record)
+			return config, nil
+		}
+		if err != nil {
+			return config, &BlobError{Op: "create", Blob: record.Container, Err: err}
+		}
+	}
+	storage, err := containers.Container(record.Container)
+	if err != nil {
+		return config, &BlobError{Op: "open", Blob: record.Container, Err: err}
+	}
+	config.Storage = storage
+	return config, nil
+}
+
+// containerStorage returns the storage of the configuration as a ContainerStorage.
+// It returns an error if the storage cannot be created or cannot manage containers.
+func (config TranslatorConfig) containerStorage() (ContainerStorage, error) {
+	storage, err := config.storage()
+	if err != nil {
+		return nil, err
+	}
+	containers, ok := storage.(ContainerStorage)
+	if !ok {
+		return nil, fmt.Errorf("the storage cannot manage containers")
+	}
+	return containers, nil
+}
+
+// deleteJobBlobs deletes every blob of a translation job from the staging storage, with the container of the job if it has one.
+// It tries to delete all the blobs and returns the errors of the deletions that failed, joined.
+func deleteJobBlobs(ctx context.Context, config TranslatorConfig, record *JobRecord) error {
+	if record.Container != "" {
+		containers, err := config.containerStorage()
+		if err != nil {
+			return err
+		}
+		if err := containers.DeleteContainer(ctx, record.Container); err != nil {
+			return &BlobError{Op: "delete", Blob: record.Container, Err: err}
+		}
+		return nil
+	}
+	jobID := record.ID
+	names, err := listBlobsFromBlobStorage(ctx, config, jobID+"-")
+	if err != nil {
+		return &BlobError{Op: "list", Blob: jobID + "-", Err: err}
+	}
+	var errs []error
+	for _, name := range names {
+		if err := deleteFileFromBlobStorage(ctx, config, name); err != nil {
+			errs = append(errs, &BlobError{Op: "delete", Blob: name, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
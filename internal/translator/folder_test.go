@@ -0,0 +1,87 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the folder translation.
+*/
+
+package translator
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestGenerateFolderJSONDocument is a test function that tests the generateFolderJSONDocument function.
+func TestGenerateFolderJSONDocument(t *testing.T) {
+	jsonDoc, err := generateFolderJSONDocument("https://example.com/container?sas", &Filter{Prefix: "job-source/", Suffix: ".docx"}, "", []Target{
+		{TargetURL: "https://example.com/container/job-translated-fr?sas", Language: "fr"},
+		{TargetURL: "https://example.com/container/job-translated-de?sas", Language: "de"},
+	})
+	if err != nil {
+		t.Fatalf("generateFolderJSONDocument failed: %v", err)
+	}
+
+	for _, expected := range []string{`"storageType": "Folder"`, `"prefix": "job-source/"`, `"suffix": ".docx"`, `"language": "de"`} {
+		if !strings.Contains(jsonDoc, expected) {
+			t.Errorf("generateFolderJSONDocument JSON does not contain %s:\n%s", expected, jsonDoc)
+		}
+	}
+	if strings.Contains(jsonDoc, `"language": ""`) {
+		t.Errorf("generateFolderJSONDocument JSON contains an empty source language:\n%s", jsonDoc)
+	}
+}
+
+// TestBlobNameFromURL is a test function that tests the blobNameFromURL function.
+func TestBlobNameFromURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		prefix   string
+		expected string
+	}{
+		{"https://account.blob.core.windows.net/container/job-source/docs/a%20b.docx?sig=x", "job-source/", "job-source/docs/a b.docx"},
+		{"http://127.0.0.1:10000/devstoreaccount1/container/job-translated-fr/docs/a.docx", "job-translated-fr", "job-translated-fr/docs/a.docx"},
+		{"https://account.blob.core.windows.net/container/job-translated-zh-hans/a.docx", "job-translated-zh-Hans/", "job-translated-zh-Hans/a.docx"},
+		{"https://account.blob.core.windows.net/container/job-translated-fr%2Fdocs%2Fa.docx", "job-translated-fr/", "job-translated-fr/docs/a.docx"},
+		{"https://account.blob.core.windows.net/container/other.docx", "job-source/", ""},
+	}
+
+	for _, test := range tests {
+		if name := blobNameFromURL(test.url, test.prefix); name != test.expected {
+			t.Errorf("blobNameFromURL(%q) returned %q, expected %q", test.url, name, test.expected)
+		}
+	}
+}
+
+// TestDownloadFolderDocumentUnresolved is a test function that checks that a translated document whose blob
+// cannot be resolved is reported with a clear error instead of a download of an empty blob name.
+func TestDownloadFolderDocumentUnresolved(t *testing.T) {
+	record := &JobRecord{ID: "job", BatchID: "batch", SourceBlob: "job-source/"}
+	target := JobTarget{Language: "fr", Blob: "job-translated-fr/", DestinationFile: t.TempDir()}
+	document := DocumentStatus{ID: "doc", To: "fr", Path: "https://account.blob.core.windows.net/container/elsewhere/a.docx"}
+	result := DocumentResult{SourcePath: "a.docx"}
+	err := downloadFolderDocument(context.Background(), TranslatorConfig{}, record, document, target, true, &result)
+	if err == nil || !strings.Contains(err.Error(), "not under the target folder job-translated-fr/") || result.DestinationFile != "" {
+		t.Errorf("downloadFolderDocument returned %v, expected an unresolved blob error", err)
+	}
+	if err := downloadFolderDocument(context.Background(), TranslatorConfig{}, record, document, target, false, &result); err == nil || !strings.Contains(err.Error(), "not a target language") {
+		t.Errorf("downloadFolderDocument returned %v, expected an unknown language error", err)
+	}
+}
+
+// TestDownloadFolderDocumentOutside is a test function that checks that a translated document whose source path
+// leaves the output directory is rejected instead of being written outside of it.
+func TestDownloadFolderDocumentOutside(t *testing.T) {
+	record := &JobRecord{ID: "job", BatchID: "batch", SourceBlob: "job-source/"}
+	target := JobTarget{Language: "fr", Blob: "job-translated-fr/", DestinationFile: t.TempDir()}
+	document := DocumentStatus{ID: "doc", To: "fr", Path: "https://account.blob.core.windows.net/container/job-translated-fr/a.docx"}
+	for _, sourcePath := range []string{"../a.docx", "docs/../../a.docx", "..", "/etc/a.docx"} {
+		result := DocumentResult{SourcePath: sourcePath}
+		err := downloadFolderDocument(context.Background(), TranslatorConfig{}, record, document, target, true, &result)
+		if err == nil || !strings.Contains(err.Error(), "outside of the output directory") || result.DestinationFile != "" {
+			t.Errorf("downloadFolderDocument(%q) returned %v, expected an outside path error", sourcePath, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DefaultGCMinAge is the age from which CollectGarbage deletes the blobs of a job when GCOptions.MinAge is zero.
+const DefaultGCMinAge = 24 * time.Hour
+
+// jobBlobPattern matches the names of the temporary blobs of a translation job: the job ID,
+// a UUID without hyphens, followed by a dash and the name of the document, glossary or folder.
+var jobBlobPattern = regexp.MustCompile(`^([0-9a-f]{32})-.+`)
+
+// jobContainerPattern matches the names of the containers of the folder translation jobs, see jobContainerPrefix.
+var jobContainerPattern = regexp.MustCompile(`^` + jobContainerPrefix + `([0-9a-f]{32})$`)
+
+// GCOptions control the garbage collection of the temporary blobs.
+type GCOptions struct {
+	// MinAge is the age of the last modification from which a blob is deleted, and of the last update from which
+	// the record of an unfinished job no longer protects its blobs, DefaultGCMinAge if zero.
+	MinAge time.Duration
+	// DryRun reports the blobs that would be deleted without deleting them.
+	DryRun bool
+}
+
+// GCReport is the summary of a garbage collection.
+type GCReport struct {
+	// Scanned is the number of blobs of the storage and of the containers of the folder jobs.
+	Scanned int
+	// Recent is the number of job blobs kept because they are younger than the minimum age.
+	Recent int
+	// Active is the number of job blobs kept because their job is recorded as unfinished in the job store
+	// and its record was updated after the minimum age, they are deleted by resuming the job.
+	Active int
+	// Deleted are the blobs deleted, or that would be deleted in a dry run.
+	Deleted []BlobInfo
+	// Bytes is the size of the deleted blobs.
+	Bytes int64
+	// Errors are the errors of the deletions that failed.
+	Errors []error
+}
+
+// CollectGarbage deletes the orphaned temporary blobs left in the staging storage by the translation jobs
+// that were interrupted or whose cleanup failed.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object, its storage must implement InfoLister.
+// - options: The minimum age of the blobs to delete and the dry run switch.
+// Only the blobs named after the job naming scheme, "<job ID>-<name>", and the containers of the folder jobs,
+// "translator-job-<job ID>", if the storage implements ContainerStorage, are considered. The blobs of the jobs
+// the job store of the configuration records as unfinished are kept, so that the jobs can still be resumed,
+// unless their record was last updated before the minimum age: such a job was abandoned, for instance interrupted
+// during its upload, or submitted and never resumed while the service deleted it.
+// It returns the report of the collection, and an error if the storage cannot be listed or some blobs could not be deleted.
+func CollectGarbage(ctx context.Context, config TranslatorConfig, options GCOptions) (*GCReport, error) {
+	if options.MinAge <= 0 {
+		options.MinAge = DefaultGCMinAge
+	}
+	storage, err := config.storage()
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := storage.(InfoLister)
+	if !ok {
+		return nil, fmt.Errorf("the storage cannot list the modification time of its blobs")
+	}
+	blobs, err := lister.ListInfo(ctx, "")
+	if err != nil {
+		return nil, &BlobError{Op: "list", Blob: "", Err: err}
+	}
+	cutoff := time.Now().Add(-options.MinAge)
+	active, err := activeJobs(config, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GCReport{Scanned: len(blobs)}
+	for _, blob := range blobs {
+		match := jobBlobPattern.FindStringSubmatch(blob.Name)
+		switch {
+		case match == nil:
+			continue
+		case active[match[1]]:
+			report.Active++
+			continue
+		case blob.LastModified.After(cutoff):
+			report.Recent++
+			continue
+		}
+		if !options.DryRun {
+			if err := storage.Delete(ctx, blob.Name); err != nil {
+				report.Errors = append(report.Errors, &BlobError{Op: "delete", Blob: blob.Name, Err: err})
+				continue
+			}
+			config.Logger.Debugf("Deleted orphaned blob %s", blob.Name)
+		}
+		report.Deleted = append(report.Deleted, blob)
+		report.Bytes += blob.Size
+	}
+	if containers, ok := storage.(ContainerStorage); ok {
+		if err := collectJobContainers(ctx, config, containers, active, cutoff, options.DryRun, report); err != nil {
+			return report, err
+		}
+	}
+	return report, errors.Join(report.Errors...)
+}
+
+// collectJobContainers deletes the containers of the folder translation jobs that are not recorded as unfinished
+// and whose container and blobs were last modified before cutoff, and adds them to report.
+// The deleted blobs are reported with their container name as a prefix, "<container>/<name>".
+// The containers are skipped if the credentials of the storage are not authorized to list them.
+// It returns an error if the containers or the blobs of a container cannot be listed.
+func collectJobContainers(ctx context.Context, config TranslatorConfig, containers ContainerStorage, active map[string]bool, cutoff time.Time, dryRun bool, report *GCReport) error {
+	items, err := containers.ListContainers(ctx, jobContainerPrefix)
+	if errors.Is(err, ErrForbidden) {
+		config.Logger.Warnf("Not authorized to list the containers of the folder translation jobs, they are not collected: %v", err)
+		return nil
+	}
+	if err != nil {
+		return &BlobError{Op: "list", Blob: jobContainerPrefix, Err: err}
+	}
+	for _, item := range items {
+		match := jobContainerPattern.FindStringSubmatch(item.Name)
+		if match == nil {
+			continue
+		}
+		storage, err := containers.Container(item.Name)
+		if err != nil {
+			return &BlobError{Op: "open", Blob: item.Name, Err: err}
+		}
+		lister, ok := storage.(InfoLister)
+		if !ok {
+			return fmt.Errorf("the storage cannot list the modification time of its blobs")
+		}
+		blobs, err := lister.ListInfo(ctx, "")
+		if err != nil {
+			return &BlobError{Op: "list", Blob: item.Name + "/", Err: err}
+		}
+		report.Scanned += len(blobs)
+		recent := item.LastModified.After(cutoff)
+		for _, blob := range blobs {
+			recent = recent || blob.LastModified.After(cutoff)
+		}
+		switch {
+		case active[match[1]]:
+			report.Active += len(blobs)
+			continue
+		case recent:
+			report.Recent += len(blobs)
+			continue
+		}
+		if !dryRun {
+			if err := containers.DeleteContainer(ctx, item.Name); err != nil {
+				report.Errors = append(report.Errors, &BlobError{Op: "delete", Blob: item.Name, Err: err})
+				continue
+			}
+			config.Logger.Debugf("Deleted orphaned container %s", item.Name)
+		}
+		for _, blob := range blobs {
+			blob.Name = item.Name + "/" + blob.Name
+			report.Deleted = append(report.Deleted, blob)
+			report.Bytes += blob.Size
+		}
+	}
+	return nil
+}
+
+// activeJobs returns the IDs of the jobs the job store of the configuration records as unfinished,
+// whose record was updated, or created if it was never updated, after cutoff.
+func activeJobs(config TranslatorConfig, cutoff time.Time) (map[string]bool, error) {
+	active := map[string]bool{}
+	if config.Jobs == nil {
+		return active, nil
+	}
+	records, err := config.Jobs.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		updated := record.Updated
+		if updated.IsZero() {
+			updated = record.Created
+		}
+		if !record.State.IsFinished() && updated.After(cutoff) {
+			active[record.ID] = true
+		}
+	}
+	return active, nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the garbage collection of the temporary blobs.
+*/
+
+package translator
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestCollectGarbage is a test function that deletes the old blobs of the jobs, in a dry run and for real.
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	storage := NewMemoryStorage()
+	store := NewFileJobStore(filepath.Join(t.TempDir(), "jobs.json"))
+	config := TranslatorConfig{Storage: storage, Jobs: store, Logger: log}
+
+	old := time.Now().Add(-48 * time.Hour)
+	orphan := strings.Repeat("a", 32)
+	resumable := strings.Repeat("b", 32)
+	recent := strings.Repeat("c", 32)
+	abandoned := strings.Repeat("d", 32)
+	blobs := map[string]time.Time{
+		orphan + "-report.docx":               old,
+		orphan + "-translated-fr-report.docx": old,
+		orphan + "-source/docs/notes.txt":     old,
+		resumable + "-report.docx":            old,
+		recent + "-report.docx":               time.Now(),
+		abandoned + "-report.docx":            old,
+		"report.docx":                         old,
+		"1234-not-a-job.docx":                 old,
+	}
+	for name, modified := range blobs {
+		storage.Put(ctx, name, strings.NewReader("content"))
+		storage.SetLastModified(name, modified)
+	}
+	store.Save(JobRecord{ID: resumable, State: JobSubmitted, Created: old, Updated: time.Now()})
+	// An unfinished job whose record was not updated for longer than the minimum age was abandoned.
+	store.Save(JobRecord{ID: abandoned, State: JobUploading, Created: old})
+
+	report, err := CollectGarbage(ctx, config, GCOptions{MinAge: time.Hour, DryRun: true})
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if report.Scanned != 8 || len(report.Deleted) != 4 || report.Active != 1 || report.Recent != 1 || report.Bytes != 28 {
+		t.Errorf("unexpected dry run report: %+v", report)
+	}
+	if names, _ := storage.List(ctx, ""); len(names) != 8 {
+		t.Errorf("the dry run deleted blobs: %v", names)
+	}
+
+	report, err = CollectGarbage(ctx, config, GCOptions{MinAge: time.Hour})
+	if err != nil || len(report.Deleted) != 4 {
+		t.Fatalf("CollectGarbage returned %+v, %v", report, err)
+	}
+	if names, _ := storage.List(ctx, orphan); len(names) != 0 {
+		t.Errorf("orphaned blobs were not deleted: %v", names)
+	}
+	if names, _ := storage.List(ctx, ""); len(names) != 4 {
+		t.Errorf("unexpected remaining blobs: %v", names)
+	}
+
+	// The containers of the folder jobs are deleted when they and their blobs are old.
+	for _, id := range []string{orphan, resumable, recent} {
+		name := jobContainerPrefix + id
+		storage.CreateContainer(ctx, name)
+		storage.SetContainerLastModified(name, old)
+		container, _ := storage.Container(name)
+		container.Put(ctx, id+"-source/a.txt", strings.NewReader("content"))
+		if id != recent {
+			container.(*MemoryStorage).SetLastModified(id+"-source/a.txt", old)
+		}
+	}
+	report, err = CollectGarbage(ctx, config, GCOptions{MinAge: time.Hour})
+	if err != nil || len(report.Deleted) != 1 || report.Deleted[0].Name != jobContainerPrefix+orphan+"/"+orphan+"-source/a.txt" || report.Active != 2 || report.Recent != 2 {
+		t.Fatalf("CollectGarbage returned %+v, %v", report, err)
+	}
+	if containers, _ := storage.ListContainers(ctx, jobContainerPrefix); len(containers) != 2 {
+		t.Errorf("unexpected remaining containers: %+v", containers)
+	}
+	// Without the rights to list the containers, the blobs of the configured container are still collected.
+	storage.ForbidContainers = true
+	storage.Put(ctx, orphan+"-report.docx", strings.NewReader("content"))
+	storage.SetLastModified(orphan+"-report.docx", old)
+	report, err = CollectGarbage(ctx, config, GCOptions{MinAge: time.Hour})
+	if err != nil || len(report.Deleted) != 1 {
+		t.Errorf("CollectGarbage without the rights on the containers returned %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,71 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Glossary references a glossary file to apply to a translation target.
+type Glossary struct {
+	GlossaryURL string `json:"glossaryUrl"`
+	Format      string `json:"format"`
+	Version     string `json:"version,omitempty"`
+}
+
+// glossaryFormats maps the supported glossary file extensions to their format name.
+var glossaryFormats = map[string]string{
+	".tsv":   "TSV",
+	".tab":   "TSV",
+	".csv":   "CSV",
+	".xlf":   "XLIFF",
+	".xliff": "XLIFF",
+}
+
+// GlossaryFormat returns the glossary format matching the extension of a glossary file.
+// If format is not empty, it is checked against the extension instead.
+// It returns an error if the extension is not a supported glossary format or does not match format.
+func GlossaryFormat(glossaryFile, format string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(glossaryFile))
+	expected, ok := glossaryFormats[ext]
+	if !ok {
+		return "", fmt.Errorf("unsupported glossary file extension %q, expected .tsv, .tab, .csv, .xlf or .xliff", ext)
+	}
+	if format != "" && !strings.EqualFold(format, expected) {
+		return "", fmt.Errorf("glossary format %s does not match the %s file extension", format, ext)
+	}
+	return expected, nil
+}
+
+// uploadGlossary uploads the glossary file of the configuration next to the job source documents.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object.
+// - jobID: The translation job ID used to name the glossary blob.
+// It returns the glossaries to reference in the targets (nil if no glossary is configured) and an error if any.
+func uploadGlossary(ctx context.Context, config TranslatorConfig, jobID string) ([]Glossary, error) {
+	if config.GlossaryFile == "" {
+		return nil, nil
+	}
+	format, err := GlossaryFormat(config.GlossaryFile, config.GlossaryFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	blobName := fmt.Sprintf("%s-glossary-%s", jobID, filepath.Base(config.GlossaryFile))
+	if err := uploadFileToBlobStorage(ctx, config, config.GlossaryFile, blobName); err != nil {
+		return nil, &BlobError{Op: "upload", Blob: blobName, Err: err}
+	}
+	glossaryURL, err := getBlobURLWithSASToken(ctx, config, blobName, sourcePermissions)
+	if err != nil {
+		return nil, fmt.Errorf("error generating glossary SAS URL: %w", err)
+	}
+	config.Logger.Debugf("glossaryUrl: %s", glossaryURL)
+
+	return []Glossary{{GlossaryURL: glossaryURL, Format: format}}, nil
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the glossary support.
+*/
+
+package translator
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestGlossaryFormat is a test function that tests the GlossaryFormat function.
+func TestGlossaryFormat(t *testing.T) {
+	tests := []struct {
+		file     string
+		format   string
+		expected string
+		wantErr  bool
+	}{
+		{"terms.tsv", "", "TSV", false},
+		{"terms.TAB", "", "TSV", false},
+		{"terms.csv", "csv", "CSV", false},
+		{"terms.xliff", "", "XLIFF", false},
+		{"terms.xlf", "TSV", "", true},
+		{"terms.txt", "", "", true},
+	}
+
+	for _, test := range tests {
+		format, err := GlossaryFormat(test.file, test.format)
+		if (err != nil) != test.wantErr {
+			t.Errorf("GlossaryFormat(%q, %q) returned error %v", test.file, test.format, err)
+		}
+		if format != test.expected {
+			t.Errorf("GlossaryFormat(%q, %q) returned %q, expected %q", test.file, test.format, format, test.expected)
+		}
+	}
+}
+
+// TestGenerateJSONDocumentWithGlossary is a test function that checks that glossaries are serialized in the targets.
+func TestGenerateJSONDocumentWithGlossary(t *testing.T) {
+	jsonDoc, err := generateJSONDocument("https://example.com/source", "en", []Target{
+		{TargetURL: "https://example.com/target", Language: "fr", Glossaries: []Glossary{{GlossaryURL: "https://example.com/glossary.tsv", Format: "TSV"}}},
+	})
+	if err != nil {
+		t.Fatalf("generateJSONDocument failed: %v", err)
+	}
+	if !strings.Contains(jsonDoc, `"glossaryUrl": "https://example.com/glossary.tsv"`) || !strings.Contains(jsonDoc, `"format": "TSV"`) {
+		t.Errorf("generateJSONDocument JSON does not contain the glossary:\n%s", jsonDoc)
+	}
+}
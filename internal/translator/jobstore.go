@@ -0,0 +1,289 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned by a JobStore when no job has the requested ID.
+var ErrJobNotFound = errors.New("job not found")
+
+// JobState is the local progress of a translation job, as recorded in a JobStore.
+type JobState string
+
+const (
+	// JobUploading means the documents are being uploaded, the job is not submitted yet.
+	JobUploading JobState = "Uploading"
+	// JobSubmitted means the job was submitted to the service and is polled until it completes.
+	JobSubmitted JobState = "Submitted"
+	// JobCleanup means the translation ended and the temporary blobs of the job are being deleted.
+	JobCleanup JobState = "Cleanup"
+	// JobCompleted means the translated documents were downloaded and the temporary blobs deleted.
+	JobCompleted JobState = "Completed"
+	// JobFailed means the translation failed or was cancelled, and the temporary blobs were deleted.
+	JobFailed JobState = "Failed"
+)
+
+// IsFinished reports whether nothing is left to do for a job in this state.
+func (s JobState) IsFinished() bool {
+	return s == JobCompleted || s == JobFailed
+}
+
+// JobTarget associates a target language with its temporary blob and its local destination.
+// For a folder translation, Blob is the prefix of the translated blobs and DestinationFile the output directory.
+type JobTarget struct {
+	Language        string `json:"language"`
+	Blob            string `json:"blob"`
+	DestinationFile string `json:"destination"`
+}
+
+// JobRecord is the metadata of a translation job persisted in a JobStore,
+// so that an interrupted translation can be resumed by another process with ResumeJob.
+type JobRecord struct {
+	// ID is the local job ID, the prefix of every temporary blob of the job.
+	ID string `json:"id"`
+	// BatchID and OperationURL identify the job on the Translator service once it is submitted.
+	BatchID      string `json:"batchId,omitempty"`
+	OperationURL string `json:"operationUrl,omitempty"`
+	// Source is the translated file, or directory for a folder translation.
+	Source string `json:"source"`
+	Folder bool   `json:"folder,omitempty"`
+	// SourceBlob is the blob of the source document, or the prefix of the source blobs for a folder.
+	SourceBlob string `json:"sourceBlob"`
+	// Container is the container created for the blobs of a folder translation, see ContainerStorage.
+	// The blobs are in the container of the storage if it is empty.
+	Container      string      `json:"container,omitempty"`
+	SourceLanguage string      `json:"sourceLanguage,omitempty"`
+	Targets        []JobTarget `json:"targets"`
+	State          JobState    `json:"state"`
+	// Error is the error that ended the translation, if any.
+	Error string `json:"error,omitempty"`
+	// ErrorKind is the type of Error, so that a resumed job returns an error of the same type, see errorKind.
+	ErrorKind string    `json:"errorKind,omitempty"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Languages returns the target languages of the job.
+func (r JobRecord) Languages() []string {
+	languages := make([]string, 0, len(r.Targets))
+	for _, target := range r.Targets {
+		languages = append(languages, target.Language)
+	}
+	return languages
+}
+
+// JobStore persists the records of the translation jobs.
+type JobStore interface {
+	// Save creates or replaces the record of a job.
+	Save(record JobRecord) error
+	// Load returns the record of a job from its local ID or its batch ID, ErrJobNotFound if there is none.
+	Load(id string) (*JobRecord, error)
+	// List returns the records of the jobs, the most recent first.
+	List() ([]JobRecord, error)
+	// Delete removes the record of a job.
+	Delete(id string) error
+}
+
+const (
+	// DefaultJobRetention is how long the records of the finished jobs are kept by a FileJobStore whose Retention is zero.
+	DefaultJobRetention = 30 * 24 * time.Hour
+	// jobStoreLockTimeout bounds the wait for the lock of a FileJobStore held by another process.
+	jobStoreLockTimeout = 10 * time.Second
+	// jobStoreStaleLock is the age after which the lock of a FileJobStore is considered left by a crashed process.
+	jobStoreStaleLock = 30 * time.Second
+)
+
+// FileJobStore is a JobStore keeping the records in a JSON file.
+// The file is read before and written after each change under a lock file, <path>.lock, created exclusively,
+// so that several processes may share it, and it is replaced atomically so that a crash never leaves it half written.
+// The records of the jobs finished for longer than Retention are pruned when a record is saved.
+type FileJobStore struct {
+	// Retention is how long the records of the finished jobs are kept, DefaultJobRetention if zero.
+	Retention time.Duration
+
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileJobStore creates a FileJobStore using the JSON file at path, created on the first save.
+func NewFileJobStore(path string) *FileJobStore {
+	return &FileJobStore{path: path}
+}
+
+// lock serializes the changes of the file by the processes sharing it, by creating its lock file exclusively.
+// A lock file older than jobStoreStaleLock was left by a crashed process, it is removed.
+// It returns the function releasing the lock, and an error if the lock is not obtained within jobStoreLockTimeout.
+func (s *FileJobStore) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating job store directory: %v", err)
+	}
+	lockPath := s.path + ".lock"
+	deadline := time.Now().Add(jobStoreLockTimeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("error locking job store: %v", err)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > jobStoreStaleLock {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("error locking job store: %s is held by another process", lockPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Path returns the path of the JSON file of the store.
+func (s *FileJobStore) Path() string {
+	return s.path
+}
+
+// read returns the records of the file by ID, an empty map if the file does not exist.
+func (s *FileJobStore) read() (map[string]JobRecord, error) {
+	records := map[string]JobRecord{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading job store: %v", err)
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("error decoding job store %s: %v", s.path, err)
+	}
+	return records, nil
+}
+
+// write replaces the file with the records.
+func (s *FileJobStore) write(records map[string]JobRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing job store: %v", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing job store: %v", err)
+	}
+	return nil
+}
+
+// Save creates or replaces the record of a job, and prunes the records of the jobs finished for longer than Retention.
+func (s *FileJobStore) Save(record JobRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+	retention := s.Retention
+	if retention <= 0 {
+		retention = DefaultJobRetention
+	}
+	for id, old := range records {
+		if old.State.IsFinished() && time.Since(old.Updated) > retention {
+			delete(records, id)
+		}
+	}
+	records[record.ID] = record
+	return s.write(records)
+}
+
+// Load returns the record of a job from its local ID or its batch ID, ErrJobNotFound if there is none.
+func (s *FileJobStore) Load(id string) (*JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	if record, ok := records[id]; ok {
+		return &record, nil
+	}
+	for _, record := range records {
+		if record.BatchID == id {
+			return &record, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+}
+
+// List returns the records of the jobs, the most recent first.
+func (s *FileJobStore) List() ([]JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]JobRecord, 0, len(records))
+	for _, record := range records {
+		list = append(list, record)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list, nil
+}
+
+// Delete removes the record of a job.
+func (s *FileJobStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := records[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	delete(records, id)
+	return s.write(records)
+}
+
+// saveJob records the current state of a job in the job store of the configuration, if any.
+// A failure to persist the record does not stop the translation, it is logged as a warning.
+func (config TranslatorConfig) saveJob(record *JobRecord) {
+	if config.Jobs == nil {
+		return
+	}
+	record.Updated = time.Now().UTC()
+	if err := config.Jobs.Save(*record); err != nil {
+		config.Logger.Warnf("Translation job %s could not be recorded, it cannot be resumed: %v", record.ID, err)
+	}
+}
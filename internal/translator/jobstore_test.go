@@ -0,0 +1,109 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the job store.
+*/
+
+package translator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestFileJobStore is a test function that saves, loads, lists and deletes job records in a JSON file.
+func TestFileJobStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "jobs.json")
+	store := NewFileJobStore(path)
+	if records, err := store.List(); err != nil || len(records) != 0 {
+		t.Fatalf("List of a missing file returned %v, %v", records, err)
+	}
+
+	now := time.Now().UTC()
+	first := JobRecord{ID: "a", Source: "a.docx", State: JobUploading, Created: now}
+	second := JobRecord{ID: "b", BatchID: "batch-b", Source: "b.docx", State: JobSubmitted, Created: now.Add(time.Minute)}
+	for _, record := range []JobRecord{first, second} {
+		if err := store.Save(record); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	first.State = JobCompleted
+	if err := store.Save(first); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Another store on the same file sees the records.
+	store = NewFileJobStore(path)
+	if record, err := store.Load("batch-b"); err != nil || record.ID != "b" {
+		t.Errorf("Load by batch ID returned %+v, %v", record, err)
+	}
+	if record, err := store.Load("a"); err != nil || record.State != JobCompleted {
+		t.Errorf("Load returned %+v, %v, expected the last saved state", record, err)
+	}
+	records, err := store.List()
+	if err != nil || len(records) != 2 || records[0].ID != "b" || records[1].ID != "a" {
+		t.Errorf("List returned %+v, %v, expected the most recent first", records, err)
+	}
+
+	if err := store.Delete("a"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err := store.Load("a"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Load of a deleted record returned %v", err)
+	}
+	if err := store.Delete("a"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Delete of a deleted record returned %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary files were left next to the store: %v", entries)
+	}
+}
+
+// TestFileJobStoreSharing is a test function that saves records concurrently through several stores sharing a file,
+// as several processes do, and checks that the records of the finished jobs are pruned after their retention.
+func TestFileJobStoreSharing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	stores := []*FileJobStore{NewFileJobStore(path), NewFileJobStore(path)}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := stores[i%2].Save(JobRecord{ID: fmt.Sprint(i), State: JobSubmitted, Created: time.Now()}); err != nil {
+				t.Errorf("Save failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if records, err := stores[0].List(); err != nil || len(records) != 20 {
+		t.Fatalf("List returned %d records, %v, expected the 20 records saved concurrently", len(records), err)
+	}
+
+	// A lock left by a crashed process is taken over once stale.
+	lockPath := path + ".lock"
+	os.WriteFile(lockPath, nil, 0600)
+	stale := time.Now().Add(-2 * jobStoreStaleLock)
+	os.Chtimes(lockPath, stale, stale)
+	store := NewFileJobStore(path)
+	store.Retention = time.Hour
+	old := time.Now().Add(-2 * time.Hour)
+	if err := store.Save(JobRecord{ID: "0", State: JobCompleted, Created: old, Updated: old}); err != nil {
+		t.Fatalf("Save with a stale lock failed: %v", err)
+	}
+	if err := store.Save(JobRecord{ID: "1", State: JobCompleted, Created: time.Now(), Updated: time.Now()}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := store.Load("0"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Load of a record finished before the retention returned %v, expected it to be pruned", err)
+	}
+	if _, err := os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the lock file was not removed: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage is a Storage keeping the content in memory, for tests and offline development.
+// Its containers, see ContainerStorage, are MemoryStorage whose signed URLs start with BaseURL/<container>.
+// It is safe for concurrent use.
+type MemoryStorage struct {
+	// BaseURL is the prefix of the signed URLs, "memory://storage" by default.
+	BaseURL string
+	// ForbidContainers makes the creation, the deletion and the listing of the containers fail with ErrForbidden,
+	// as with credentials whose role is scoped to a single container.
+	ForbidContainers bool
+
+	// name is the name of the container, empty for the root storage.
+	name       string
+	mu         sync.Mutex
+	blobs      map[string][]byte
+	modified   map[string]time.Time
+	containers map[string]*MemoryStorage
+	created    map[string]time.Time
+}
+
+// NewMemoryStorage creates an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{BaseURL: "memory://storage", blobs: map[string][]byte{}, modified: map[string]time.Time{},
+		containers: map[string]*MemoryStorage{}, created: map[string]time.Time{}}
+}
+
+// Put stores the content read from r under name.
+func (s *MemoryStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[name] = content
+	s.modified[name] = time.Now()
+	return nil
+}
+
+// Get writes the content stored under name to w.
+func (s *MemoryStorage) Get(ctx context.Context, name string, w io.Writer) error {
+	s.mu.Lock()
+	content, ok := s.blobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("blob %s not found", name)
+	}
+	_, err := io.Copy(w, bytes.NewReader(content))
+	return err
+}
+
+// Delete removes the content stored under name.
+func (s *MemoryStorage) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[name]; !ok {
+		return fmt.Errorf("blob %s not found", name)
+	}
+	delete(s.blobs, name)
+	delete(s.modified, name)
+	return nil
+}
+
+// List returns the sorted names starting with prefix.
+func (s *MemoryStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name := range s.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ListInfo returns the blobs whose names start with prefix, sorted by name.
+func (s *MemoryStorage) ListInfo(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	names, _ := s.List(ctx, prefix)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blobs := make([]BlobInfo, 0, len(names))
+	for _, name := range names {
+		if content, ok := s.blobs[name]; ok {
+			blobs = append(blobs, BlobInfo{Name: name, Size: int64(len(content)), LastModified: s.modified[name]})
+		}
+	}
+	return blobs, nil
+}
+
+// SetLastModified changes the modification time of a blob, to test the processing of old blobs.
+func (s *MemoryStorage) SetLastModified(name string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[name]; ok {
+		s.modified[name] = t
+	}
+}
+
+// Container returns the container name created by CreateContainer.
+// It returns an error if the container does not exist.
+func (s *MemoryStorage) Container(name string) (Storage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	container, ok := s.containers[name]
+	if !ok {
+		return nil, fmt.Errorf("container %s not found", name)
+	}
+	return container, nil
+}
+
+// CreateContainer creates the container name, whose signed URLs start with BaseURL/name.
+// It succeeds if the container already exists.
+func (s *MemoryStorage) CreateContainer(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ForbidContainers {
+		return ErrForbidden
+	}
+	if _, ok := s.containers[name]; ok {
+		return nil
+	}
+	container := NewMemoryStorage()
+	container.BaseURL = s.BaseURL + "/" + name
+	container.name = name
+	s.containers[name] = container
+	s.created[name] = time.Now()
+	return nil
+}
+
+// DeleteContainer deletes the container name and its blobs, it succeeds if the container does not exist.
+func (s *MemoryStorage) DeleteContainer(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ForbidContainers {
+		return ErrForbidden
+	}
+	delete(s.containers, name)
+	delete(s.created, name)
+	return nil
+}
+
+// ListContainers returns the containers whose names start with prefix, sorted by name.
+func (s *MemoryStorage) ListContainers(ctx context.Context, prefix string) ([]ContainerInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ForbidContainers {
+		return nil, ErrForbidden
+	}
+	containers := []ContainerInfo{}
+	for name := range s.containers {
+		if strings.HasPrefix(name, prefix) {
+			containers = append(containers, ContainerInfo{Name: name, LastModified: s.created[name]})
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers, nil
+}
+
+// SetContainerLastModified changes the modification time of a container, to test the processing of old containers.
+func (s *MemoryStorage) SetContainerLastModified(name string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.containers[name]; ok {
+		s.created[name] = t
+	}
+}
+
+// SignedURL returns BaseURL/name with the permissions, the expiry time and the signed resource as query parameters,
+// named after those of an Azure SAS: sp, se, sr ("b" for a blob, "c" for a folder or the container) and sig.
+// The URL is not verified by MemoryStorage, and sig is not secret: it is the signed resource, the container name
+// for a container SAS and the container name and the blob name joined by "/" for a blob SAS,
+// so that a server exposing the storage can check the scope of the URL.
+func (s *MemoryStorage) SignedURL(ctx context.Context, name string, permissions Permissions, expiry time.Time) (string, error) {
+	u, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = fmt.Sprintf("%s/%s", u.Path, strings.TrimSuffix(name, "/"))
+	query := url.Values{}
+	query.Set("sp", permissions.String())
+	query.Set("se", expiry.UTC().Format(time.RFC3339))
+	if name == "" || strings.HasSuffix(name, "/") {
+		query.Set("sr", "c")
+		query.Set("sig", s.name)
+	} else {
+		query.Set("sr", "b")
+		query.Set("sig", strings.TrimPrefix(s.name+"/"+name, "/"))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,124 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"io"
+)
+
+// ProgressKind is the kind of a ProgressEvent.
+type ProgressKind int
+
+const (
+	// ProgressUpload reports the bytes of a document or glossary uploaded so far.
+	ProgressUpload ProgressKind = iota
+	// ProgressSubmitted reports that the service accepted the translation job JobID.
+	ProgressSubmitted
+	// ProgressStatus reports a change of the status or of the document counters of a job.
+	ProgressStatus
+	// ProgressCharged reports the characters charged so far for a job.
+	ProgressCharged
+	// ProgressDownload reports the bytes of a translated document downloaded so far.
+	ProgressDownload
+)
+
+// String returns the name of the kind of event.
+func (k ProgressKind) String() string {
+	switch k {
+	case ProgressUpload:
+		return "upload"
+	case ProgressSubmitted:
+		return "submitted"
+	case ProgressStatus:
+		return "status"
+	case ProgressCharged:
+		return "charged"
+	case ProgressDownload:
+		return "download"
+	}
+	return "unknown"
+}
+
+// ProgressEvent is a step of a translation reported to a ProgressReporter.
+type ProgressEvent struct {
+	Kind ProgressKind
+	// JobID is the ID of the translation job, empty before the submission and for synchronous translations.
+	JobID string
+	// Name is the file uploaded or downloaded.
+	Name string
+	// Bytes is the number of bytes transferred so far.
+	Bytes int64
+	// Total is the size of the transfer in bytes, 0 while it is unknown.
+	// A transfer is complete when Bytes equals Total.
+	Total int64
+	// Status and Summary are the status and the document counters of the job.
+	Status  Status
+	Summary StatusSummary
+	// Characters is the number of characters charged so far.
+	Characters int64
+}
+
+// ProgressReporter receives the progress events of the translations.
+// Progress may be called concurrently by parallel transfers and must return quickly.
+type ProgressReporter interface {
+	Progress(event ProgressEvent)
+}
+
+// ProgressFunc is a function used as a ProgressReporter.
+type ProgressFunc func(event ProgressEvent)
+
+// Progress calls f(event).
+func (f ProgressFunc) Progress(event ProgressEvent) {
+	f(event)
+}
+
+// progress reports an event to the progress reporter of the configuration, if any.
+func (config TranslatorConfig) progress(event ProgressEvent) {
+	if config.Progress != nil {
+		config.Progress.Progress(event)
+	}
+}
+
+// progressReader is an io.Reader reporting the bytes read from r as upload events.
+type progressReader struct {
+	r     io.Reader
+	bytes int64
+	event ProgressEvent
+	// report is called with each event, it is the progress method of the configuration.
+	report func(ProgressEvent)
+}
+
+// Read reads from the underlying reader and reports the bytes read so far.
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.bytes += int64(n)
+		event := p.event
+		event.Bytes = p.bytes
+		p.report(event)
+	}
+	return n, err
+}
+
+// progressWriter is an io.Writer reporting the bytes written to w as download events.
+type progressWriter struct {
+	w     io.Writer
+	bytes int64
+	event ProgressEvent
+	// report is called with each event, it is the progress method of the configuration.
+	report func(ProgressEvent)
+}
+
+// Write writes to the underlying writer and reports the bytes written so far.
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if n > 0 {
+		p.bytes += int64(n)
+		event := p.event
+		event.Bytes = p.bytes
+		p.report(event)
+	}
+	return n, err
+}
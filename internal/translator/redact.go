@@ -0,0 +1,120 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redacted replaces the secrets masked by a Redactor.
+const redacted = "REDACTED"
+
+// secretPatterns match the secrets of well-known formats, the first group is kept and the rest is masked:
+// the signature of the SAS tokens, the account keys of the storage connection strings,
+// the subscription key headers and the Bearer tokens.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(\bsig=)[^&\s"']+`),
+	regexp.MustCompile(`(?i)(\bAccountKey=)[^;\s"']+`),
+	regexp.MustCompile(`(?i)(Ocp-Apim-Subscription-Key:?\s*\[?)[^\]\s,"']+`),
+	regexp.MustCompile(`(?i)(\bBearer\s+)[^\]\s,"']+`),
+}
+
+// Redactor masks the secrets in the log lines and the error messages.
+// It is a logrus hook masking the messages and the string fields of the entries of a logger.
+type Redactor struct {
+	secrets []string
+}
+
+// NewRedactor creates a Redactor masking the SAS signatures, the account keys of the connection strings,
+// the subscription key headers, and the given secret values, such as the configured keys. Empty secrets are ignored.
+func NewRedactor(secrets ...string) *Redactor {
+	r := &Redactor{}
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	return r
+}
+
+// Redactor returns a Redactor masking the secrets of the configuration: its Translator and storage account keys,
+// and the client secret and certificate password of its credential.
+func (config TranslatorConfig) Redactor() *Redactor {
+	return NewRedactor(config.TranslatorKey, config.BlobAccountKey, config.Credential.ClientSecret, config.Credential.CertificatePassword)
+}
+
+// hooksMu serializes the additions of Redactor hooks by addRedactor.
+var hooksMu sync.Mutex
+
+// addRedactor adds r to the hooks of logger, unless a Redactor masking the same secrets is already registered,
+// so that the clients created from one configuration with a shared logger do not stack duplicate hooks on it.
+func addRedactor(logger *logrus.Logger, r *Redactor) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	for _, hook := range logger.Hooks[logrus.InfoLevel] {
+		if registered, ok := hook.(*Redactor); ok && slices.Equal(registered.secrets, r.secrets) {
+			return
+		}
+	}
+	logger.AddHook(r)
+}
+
+// Redact returns s with its secrets replaced by REDACTED.
+func (r *Redactor) Redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
+
+// Error returns err with its message redacted, nil if err is nil.
+// The returned error wraps err, so that errors.Is and errors.As still see the original error.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{message: r.Redact(err.Error()), err: err}
+}
+
+// Levels returns all the levels, every log line is redacted.
+func (r *Redactor) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redacts the message and the string and error fields of a log entry.
+func (r *Redactor) Fire(entry *logrus.Entry) error {
+	entry.Message = r.Redact(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = r.Redact(v)
+		case error:
+			entry.Data[key] = r.Redact(v.Error())
+		}
+	}
+	return nil
+}
+
+// redactedError is an error whose message was redacted.
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
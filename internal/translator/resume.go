@@ -0,0 +1,67 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"fmt"
+)
+
+// ResumeJob resumes a translation job recorded in the job store of the configuration, after its process was interrupted.
+// It takes the following parameters:
+// - ctx: The context of the translation, cancelling it cancels the translation job and deletes the temporary blobs.
+// - jobID: The local ID or the batch ID of the job.
+// - config: The TranslatorConfig object, with the Translator and storage settings the job was started with.
+// The job is picked up where it was left:
+// - a job interrupted while uploading its documents was never submitted, its blobs are deleted and it is marked as failed,
+// - a submitted job is polled until it completes, then its translated documents are downloaded and its blobs deleted,
+// - a job interrupted during its cleanup has its remaining blobs deleted,
+// - a finished job is left unchanged.
+// It returns the result of each document for a folder translation, and the error of the translation if any.
+func ResumeJob(ctx context.Context, jobID string, config TranslatorConfig) (results []DocumentResult, err error) {
+	if config.Jobs == nil {
+		return nil, fmt.Errorf("no job store configured")
+	}
+	record, err := config.Jobs.Load(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if record.State.IsFinished() {
+		config.Logger.Infof("Translation job %s is already %s", record.ID, record.State)
+		return nil, nil
+	}
+	config, err = config.withSharedClients()
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete the blobs of the job on every exit path, even if the context was cancelled or a panic occurred.
+	defer config.endJob(ctx, record, &err)
+
+	switch record.State {
+	case JobUploading:
+		return nil, fmt.Errorf("translation job %s was interrupted before its submission, translate the documents again", record.ID)
+	case JobSubmitted:
+		config.Logger.Infof("Resuming translation job %s", record.BatchID)
+		config, err := config.jobStorage(ctx, record, false)
+		if err != nil {
+			return nil, err
+		}
+		status, err := waitForBatch(ctx, config, record.OperationURL)
+		if err != nil {
+			return nil, err
+		}
+		if record.Folder {
+			return finishFolderTranslation(ctx, config, record, status)
+		}
+		return nil, finishTranslation(ctx, config, record.OperationURL, status, record.Targets)
+	case JobCleanup:
+		config.Logger.Infof("Deleting the temporary blobs of translation job %s", record.ID)
+		if record.Error != "" {
+			return nil, recordedError(record)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,231 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryPolicy is the retry policy used for the fields of TranslatorConfig.Retry left to zero,
+// and as a whole when TranslatorConfig.Retry is left to zero.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   Duration(1 * time.Second),
+	MaxDelay:    Duration(30 * time.Second),
+	Jitter:      0.5,
+}
+
+// Duration is a time.Duration read from and written to configuration files as a string such as "500ms" or "2s".
+type Duration time.Duration
+
+// MarshalText returns the duration as a string such as "1m30s".
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText parses a duration string such as "500ms" or "2s".
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// RetryPolicy controls how the requests to the Translator service and the staging storage operations
+// are retried when they fail with a network error, 408 Request Timeout, 429 Too Many Requests or a 5xx status.
+// The delay before the attempt n+1 is BaseDelay * 2^(n-1), capped at MaxDelay, of which a random fraction
+// of up to Jitter is removed. A Retry-After header longer than this delay is honoured, up to MaxDelay.
+// The fields left to zero take the values of DefaultRetryPolicy, except Jitter: a zero Jitter disables the jitter,
+// unless the whole policy is left to zero.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of an operation including the first one, 1 disables the retries.
+	MaxAttempts int `json:"maxAttempts"`
+	// BaseDelay is the delay before the first retry.
+	BaseDelay Duration `json:"baseDelay"`
+	// MaxDelay caps the exponential delay between two attempts.
+	MaxDelay Duration `json:"maxDelay"`
+	// Jitter is the fraction of each delay, between 0 and 1, that is randomized to spread the retries of concurrent clients.
+	// 0 disables the jitter, a value above 1 is clamped to 1.
+	Jitter float64 `json:"jitter"`
+}
+
+// withDefaults returns the policy with the zero fields set to the values of DefaultRetryPolicy, or DefaultRetryPolicy
+// if the whole policy is zero. The jitter of a policy that is set is kept, clamped between 0 and 1.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p == (RetryPolicy{}) {
+		return DefaultRetryPolicy
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	p.Jitter = min(max(p.Jitter, 0), 1)
+	return p
+}
+
+// delay returns the time to wait after the failed attempt number attempt, starting at 1.
+// retryAfter is the delay requested by the server, 0 if none, it is honoured up to MaxDelay so that a misbehaving
+// server or proxy cannot stall an operation for hours.
+// It returns the delay, and whether retryAfter was capped.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	delay := time.Duration(p.MaxDelay)
+	if attempt < 32 {
+		if backoff := time.Duration(p.BaseDelay) << (attempt - 1); backoff > 0 && backoff < delay {
+			delay = backoff
+		}
+	}
+	delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+	if retryAfter > time.Duration(p.MaxDelay) {
+		return time.Duration(p.MaxDelay), true
+	}
+	return max(delay, retryAfter), false
+}
+
+// wait logs a failed attempt and waits before the next one.
+// It returns an error wrapping the context error if ctx is done before the delay has elapsed.
+func (p RetryPolicy) wait(ctx context.Context, config TranslatorConfig, attempt int, retryAfter time.Duration, operation, reason string) error {
+	delay, capped := p.delay(attempt, retryAfter)
+	if capped {
+		config.Logger.Warnf("%s asked to retry after %s, waiting for the maximum delay %s instead", operation, retryAfter, delay)
+	}
+	config.Logger.Warnf("%s failed (%s), attempt %d/%d, retrying in %s", operation, reason, attempt, p.MaxAttempts, delay.Round(time.Millisecond))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%s interrupted: %w", operation, ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryableStatus reports whether a response status code denotes a transient failure.
+func retryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// idempotent reports whether a request can be sent again without side effects once it reached the service.
+// The POST and PATCH requests, such as the submission of a translation job, are not idempotent.
+func idempotent(method string) bool {
+	return method != http.MethodPost && method != http.MethodPatch
+}
+
+// retryableResponse reports whether a request answered with statusCode can be sent again.
+// An idempotent request is resent on any transient failure, see retryableStatus. A request that is not idempotent
+// is only resent when the service reports it did not process it, with 429 or 503: after a 408 or another 5xx,
+// the service may have processed it, and resending a job submission could start a duplicate job.
+func retryableResponse(method string, statusCode int) bool {
+	if idempotent(method) {
+		return retryableStatus(statusCode)
+	}
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either a number of seconds or an HTTP date.
+// It returns 0 if the value is empty or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// doRequest sends the request built by newRequest with the HTTP client of the configuration.
+// The request is built again and resent according to the retry policy of the configuration when the service
+// answers with a transient failure status, see retryableResponse, or when an idempotent request fails with a network error.
+// It returns the response of the last attempt, the caller must close its body.
+func doRequest(ctx context.Context, config TranslatorConfig, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	policy := config.Retry.withDefaults()
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("error creating HTTP request: %v", err)
+		}
+		operation := fmt.Sprintf("Translator request %s %s", req.Method, req.URL.Path)
+		res, err := config.httpClient().Do(req)
+		var reason string
+		var retryAfter time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || !idempotent(req.Method) || attempt >= policy.MaxAttempts {
+				return nil, fmt.Errorf("error sending HTTP request: %w", err)
+			}
+			reason = err.Error()
+		case retryableResponse(req.Method, res.StatusCode) && attempt < policy.MaxAttempts:
+			reason = res.Status
+			retryAfter = parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		default:
+			return res, nil
+		}
+		if err := policy.wait(ctx, config, attempt, retryAfter, operation, reason); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// responseError is implemented by the errors carrying the HTTP response of a failed request,
+// such as the errors of the Azure Blob Storage client.
+type responseError interface {
+	Response() *http.Response
+}
+
+// retryableError reports whether an error returned by a storage operation is transient,
+// and the delay requested by the server in a Retry-After header if any.
+func retryableError(err error) (bool, time.Duration) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+	var resErr responseError
+	if errors.As(err, &resErr) && resErr.Response() != nil {
+		res := resErr.Response()
+		return retryableStatus(res.StatusCode), parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr), 0
+}
+
+// retry runs a storage operation, running it again according to the retry policy of the configuration
+// as long as it fails with a transient error.
+// It takes the context, the TranslatorConfig object, a description of the operation for the logs and the operation.
+// It returns the error of the last attempt.
+func retry(ctx context.Context, config TranslatorConfig, operation string, fn func() error) error {
+	policy := config.Retry.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		retryable, retryAfter := retryableError(err)
+		if !retryable {
+			return err
+		}
+		if err := policy.wait(ctx, config, attempt, retryAfter, operation, err.Error()); err != nil {
+			return err
+		}
+	}
+}
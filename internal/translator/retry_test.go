@@ -0,0 +1,237 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the retry policy.
+*/
+
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// statusError is a storage error carrying an HTTP response, like the errors of the Azure Blob Storage client.
+type statusError struct {
+	res *http.Response
+}
+
+func (e statusError) Error() string {
+	return e.res.Status
+}
+
+func (e statusError) Response() *http.Response {
+	return e.res
+}
+
+// newRetryConfig returns a configuration with a silent logger and short retry delays.
+func newRetryConfig() TranslatorConfig {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return TranslatorConfig{
+		TranslatorKey: "key",
+		Retry:         RetryPolicy{MaxAttempts: 3, BaseDelay: Duration(time.Millisecond), MaxDelay: Duration(5 * time.Millisecond)},
+		Logger:        log,
+	}
+}
+
+// TestRetryPolicyDelay is a test function that checks the exponential backoff, its cap, its jitter and the Retry-After override.
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: Duration(100 * time.Millisecond), MaxDelay: Duration(time.Second), Jitter: 0.5}.withDefaults()
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{1, 0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{3, 0, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 0, 500 * time.Millisecond, time.Second},
+		{100, 0, 500 * time.Millisecond, time.Second},
+		{1, 800 * time.Millisecond, 800 * time.Millisecond, 800 * time.Millisecond},
+		{1, 5 * time.Hour, time.Second, time.Second},
+	}
+	for _, test := range tests {
+		if delay, _ := policy.delay(test.attempt, test.retryAfter); delay < test.min || delay > test.max {
+			t.Errorf("delay(%d, %s) = %s, expected between %s and %s", test.attempt, test.retryAfter, delay, test.min, test.max)
+		}
+	}
+	if policy.MaxAttempts != DefaultRetryPolicy.MaxAttempts {
+		t.Errorf("withDefaults did not set MaxAttempts: %+v", policy)
+	}
+	if _, capped := policy.delay(1, 5*time.Hour); !capped {
+		t.Error("delay did not report the capped Retry-After")
+	}
+}
+
+// TestRetryPolicyJitter is a test function that checks that a zero jitter disables the jitter,
+// unless the whole policy is left to zero.
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: Duration(100 * time.Millisecond), Jitter: 0}.withDefaults()
+	if policy.Jitter != 0 {
+		t.Errorf("withDefaults replaced a zero jitter: %+v", policy)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		if delay, _ := policy.delay(attempt, 0); delay != 100*time.Millisecond<<(attempt-1) {
+			t.Errorf("delay(%d) = %s without jitter, expected %s", attempt, delay, 100*time.Millisecond<<(attempt-1))
+		}
+	}
+	if policy := (RetryPolicy{}).withDefaults(); policy != DefaultRetryPolicy {
+		t.Errorf("withDefaults of a zero policy returned %+v, expected DefaultRetryPolicy", policy)
+	}
+	if policy := (RetryPolicy{MaxAttempts: 2, Jitter: 5}).withDefaults(); policy.Jitter != 1 {
+		t.Errorf("withDefaults did not clamp the jitter: %+v", policy)
+	}
+}
+
+// TestParseRetryAfter is a test function that parses the seconds and HTTP date forms of Retry-After.
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Sat, 01 Jun 2024 12:00:10 GMT": 10 * time.Second,
+		"Sat, 01 Jun 2024 11:00:00 GMT": 0,
+	}
+	for value, expected := range tests {
+		if delay := parseRetryAfter(value, now); delay != expected {
+			t.Errorf("parseRetryAfter(%q) = %s, expected %s", value, delay, expected)
+		}
+	}
+}
+
+// TestDurationText is a test function that reads and writes a Duration in a JSON document.
+func TestDurationText(t *testing.T) {
+	var policy RetryPolicy
+	if err := json.Unmarshal([]byte(`{"maxAttempts":5,"baseDelay":"500ms","maxDelay":"1m"}`), &policy); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if policy.MaxAttempts != 5 || time.Duration(policy.BaseDelay) != 500*time.Millisecond || time.Duration(policy.MaxDelay) != time.Minute {
+		t.Errorf("unexpected policy: %+v", policy)
+	}
+	if err := json.Unmarshal([]byte(`{"baseDelay":"often"}`), &policy); err == nil {
+		t.Error("json.Unmarshal accepted an invalid duration")
+	}
+	data, _ := json.Marshal(policy)
+	if string(data) != `{"maxAttempts":5,"baseDelay":"500ms","maxDelay":"1m0s","jitter":0}` {
+		t.Errorf("json.Marshal returned %s", data)
+	}
+}
+
+// TestRetry is a test function that retries a storage operation failing with transient and permanent errors.
+func TestRetry(t *testing.T) {
+	config := newRetryConfig()
+	busy := statusError{&http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Server Busy", Header: http.Header{}}}
+	notFound := statusError{&http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Header: http.Header{}}}
+
+	attempts := 0
+	err := retry(context.Background(), config, "Upload", func() error {
+		attempts++
+		if attempts < 3 {
+			return busy
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("retry returned %v after %d attempts, expected success after 3 attempts", err, attempts)
+	}
+
+	attempts = 0
+	err = retry(context.Background(), config, "Upload", func() error {
+		attempts++
+		return busy
+	})
+	if !errors.Is(err, busy) || attempts != 3 {
+		t.Errorf("retry returned %v after %d attempts, expected the last error after 3 attempts", err, attempts)
+	}
+
+	attempts = 0
+	err = retry(context.Background(), config, "Download", func() error {
+		attempts++
+		return fmt.Errorf("download failed: %w", notFound)
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("retry returned %v after %d attempts, expected no retry of a permanent error", err, attempts)
+	}
+}
+
+// TestDoRequest is a test function that retries requests answered with 503 and stops when the context is cancelled.
+func TestDoRequest(t *testing.T) {
+	failures := 2
+	failureStatus := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(failureStatus)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	config := newRetryConfig()
+	newRequest := func() (*http.Request, error) {
+		return newTranslatorRequest(context.Background(), config, http.MethodGet, server.URL, nil)
+	}
+
+	res, err := doRequest(context.Background(), config, newRequest)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("doRequest returned %v, %v, expected 200 OK after two retries", res, err)
+	}
+	res.Body.Close()
+
+	// The last response is returned when the attempts are exhausted.
+	failures = 5
+	res, err = doRequest(context.Background(), config, newRequest)
+	if err != nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("doRequest returned %v, %v, expected the 503 response of the last attempt", res, err)
+	}
+	res.Body.Close()
+
+	// A job submission answered with 500 may have started a job, it is not sent again, unlike a status request.
+	for _, test := range []struct {
+		method   string
+		status   int
+		attempts int
+	}{
+		{http.MethodPost, http.StatusInternalServerError, 1},
+		{http.MethodPost, http.StatusRequestTimeout, 1},
+		{http.MethodPost, http.StatusServiceUnavailable, 2},
+		{http.MethodPost, http.StatusTooManyRequests, 2},
+		{http.MethodGet, http.StatusInternalServerError, 2},
+	} {
+		failures, failureStatus = 1, test.status
+		attempts := 0
+		res, err := doRequest(context.Background(), config, func() (*http.Request, error) {
+			attempts++
+			return newTranslatorRequest(context.Background(), config, test.method, server.URL, nil)
+		})
+		if err != nil || attempts != test.attempts {
+			t.Errorf("doRequest of %s answered with %d returned %v after %d attempts, expected %d", test.method, test.status, err, attempts, test.attempts)
+		} else {
+			res.Body.Close()
+		}
+	}
+	failureStatus = http.StatusServiceUnavailable
+
+	// A cancelled context interrupts the wait between two attempts.
+	failures = 5
+	config.Retry.BaseDelay = Duration(time.Hour)
+	config.Retry.MaxDelay = Duration(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := doRequest(ctx, config, newRequest); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("doRequest returned %v, expected the context error", err)
+	}
+}
@@ -0,0 +1,120 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The prefixes of the secret references, accepted by the secret fields of the configuration instead of the secret itself.
+const (
+	// SecretFilePrefix references a file holding the secret, e.g. file:/run/secrets/translator-key.
+	SecretFilePrefix = "file:"
+	// SecretEnvPrefix references an environment variable holding the secret, e.g. env:TRANSLATOR_KEY.
+	SecretEnvPrefix = "env:"
+	// SecretCmdPrefix references a command printing the secret, e.g. cmd:pass show translator.
+	SecretCmdPrefix = "cmd:"
+)
+
+// secretCommandTimeout bounds the run of a secret command.
+const secretCommandTimeout = 30 * time.Second
+
+// IsSecretReference reports whether value references a secret rather than being the secret itself.
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, SecretFilePrefix) || strings.HasPrefix(value, SecretEnvPrefix) || strings.HasPrefix(value, SecretCmdPrefix)
+}
+
+// ResolveSecret returns the secret referenced by value, or value itself if it is not a reference.
+// It takes the following parameters:
+// - ctx: The context of the resolution, cancelling it kills a secret command.
+// - value: The secret, or a reference: "file:<path>", "env:<variable>" or "cmd:<command>".
+// The content of a file and the output of a command are trimmed of their trailing line break.
+// A command is run by the shell and must print the secret on its standard output.
+// It returns an error if the file cannot be read, the variable is not set, the command fails, or the secret is empty.
+// The errors never contain the secret.
+func ResolveSecret(ctx context.Context, value string) (string, error) {
+	var secret string
+	switch {
+	case strings.HasPrefix(value, SecretFilePrefix):
+		path := strings.TrimPrefix(value, SecretFilePrefix)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file: %v", err)
+		}
+		secret = strings.TrimRight(string(data), "\r\n")
+	case strings.HasPrefix(value, SecretEnvPrefix):
+		name := strings.TrimPrefix(value, SecretEnvPrefix)
+		secret = os.Getenv(name)
+		if secret == "" {
+			return "", fmt.Errorf("secret environment variable %s is not set", name)
+		}
+	case strings.HasPrefix(value, SecretCmdPrefix):
+		command := strings.TrimPrefix(value, SecretCmdPrefix)
+		ctx, cancel := context.WithTimeout(ctx, secretCommandTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd.exe", "/c", command)
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("error running secret command %q: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+		}
+		secret = strings.TrimRight(string(output), "\r\n")
+	default:
+		return value, nil
+	}
+	if secret == "" {
+		return "", fmt.Errorf("secret reference %q is empty", value)
+	}
+	return secret, nil
+}
+
+// secretFields returns the secret fields of the configuration by their name in the configuration file.
+func (config *TranslatorConfig) secretFields() map[string]*string {
+	return map[string]*string{
+		"translatorKey":                  &config.TranslatorKey,
+		"blobAccountKey":                 &config.BlobAccountKey,
+		"credential.clientSecret":        &config.Credential.ClientSecret,
+		"credential.certificatePassword": &config.Credential.CertificatePassword,
+	}
+}
+
+// SecretReferences returns the names of the secret fields of the configuration holding a reference, sorted.
+func (config TranslatorConfig) SecretReferences() []string {
+	names := []string{}
+	for name, value := range config.secretFields() {
+		if IsSecretReference(*value) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveSecrets replaces the secret references of the configuration by the secrets they reference, see ResolveSecret.
+// The secret fields are the Translator and storage account keys, and the client secret and certificate password of the credential.
+// It returns an error naming the field whose reference cannot be resolved.
+func (config *TranslatorConfig) ResolveSecrets(ctx context.Context) error {
+	for _, name := range config.SecretReferences() {
+		field := config.secretFields()[name]
+		secret, err := ResolveSecret(ctx, *field)
+		if err != nil {
+			return fmt.Errorf("error resolving %s: %v", name, err)
+		}
+		*field = secret
+	}
+	return nil
+}
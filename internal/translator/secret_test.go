@@ -0,0 +1,72 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the resolution of the secret references.
+*/
+
+package translator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestResolveSecret is a test function that resolves the file, environment variable and command references.
+func TestResolveSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TRANSLATOR_TEST_SECRET", "env-secret")
+	tests := map[string]string{
+		"plain-secret":                                "plain-secret",
+		"file:" + path:                                "file-secret",
+		"env:TRANSLATOR_TEST_SECRET":                  "env-secret",
+		"cmd:echo cmd-secret":                         "cmd-secret",
+		"cmd:printf '%s' \"$TRANSLATOR_TEST_SECRET\"": "env-secret",
+	}
+	for value, expected := range tests {
+		if secret, err := ResolveSecret(context.Background(), value); err != nil || secret != expected {
+			t.Errorf("ResolveSecret(%q) returned %q, %v, expected %q", value, secret, err, expected)
+		}
+	}
+
+	invalid := []string{"file:" + path + ".missing", "env:TRANSLATOR_TEST_UNSET", "cmd:echo cmd-secret; exit 3", "cmd:true"}
+	for _, value := range invalid {
+		if secret, err := ResolveSecret(context.Background(), value); err == nil {
+			t.Errorf("ResolveSecret(%q) returned %q, expected an error", value, secret)
+		} else if strings.Contains(err.Error(), "cmd-secret\n") {
+			t.Errorf("ResolveSecret(%q) returned an error containing the secret: %v", value, err)
+		}
+	}
+}
+
+// TestResolveSecrets is a test function that resolves the secret references of a configuration.
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("TRANSLATOR_TEST_SECRET", "env-secret")
+	config := TranslatorConfig{
+		TranslatorKey:  "env:TRANSLATOR_TEST_SECRET",
+		BlobAccountKey: "plain-key",
+		Credential:     CredentialOptions{ClientSecret: "cmd:echo client-secret"},
+	}
+	if references := config.SecretReferences(); !reflect.DeepEqual(references, []string{"credential.clientSecret", "translatorKey"}) {
+		t.Errorf("SecretReferences returned %v", references)
+	}
+	if err := config.ResolveSecrets(context.Background()); err != nil {
+		t.Fatalf("ResolveSecrets failed: %v", err)
+	}
+	if config.TranslatorKey != "env-secret" || config.BlobAccountKey != "plain-key" || config.Credential.ClientSecret != "client-secret" {
+		t.Errorf("ResolveSecrets resolved %+v", config)
+	}
+
+	config.BlobAccountKey = "env:TRANSLATOR_TEST_UNSET"
+	if err := config.ResolveSecrets(context.Background()); err == nil || !strings.Contains(err.Error(), "blobAccountKey") {
+		t.Errorf("ResolveSecrets returned %v, expected an error naming blobAccountKey", err)
+	}
+}
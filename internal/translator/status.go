@@ -0,0 +1,253 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// Status is the state of a translation job or of a single document within a job,
+// as reported by the Document Translation batches API.
+type Status string
+
+const (
+	StatusNotStarted       Status = "NotStarted"
+	StatusRunning          Status = "Running"
+	StatusSucceeded        Status = "Succeeded"
+	StatusFailed           Status = "Failed"
+	StatusCancelled        Status = "Cancelled"
+	StatusCancelling       Status = "Cancelling"
+	StatusValidationFailed Status = "ValidationFailed"
+)
+
+// IsTerminal reports whether the status is final, i.e. the service will not update it anymore.
+func (s Status) IsTerminal() bool {
+	switch s {
+	case StatusSucceeded, StatusFailed, StatusCancelled, StatusValidationFailed:
+		return true
+	}
+	return false
+}
+
+// ServiceError is the error object returned by the Document Translation service
+// for a job or for a document.
+type ServiceError struct {
+	Code       string        `json:"code"`
+	Message    string        `json:"message"`
+	Target     string        `json:"target,omitempty"`
+	InnerError *ServiceError `json:"innerError,omitempty"`
+}
+
+// String returns the error code and message, followed by the inner error if any.
+func (e *ServiceError) String() string {
+	if e == nil {
+		return ""
+	}
+	s := e.Message
+	if e.Code != "" {
+		s = fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	if e.InnerError != nil {
+		s = fmt.Sprintf("%s (%s)", s, e.InnerError.String())
+	}
+	return s
+}
+
+// StatusSummary holds the document counters of a translation job.
+type StatusSummary struct {
+	Total                 int   `json:"total"`
+	Failed                int   `json:"failed"`
+	Success               int   `json:"success"`
+	InProgress            int   `json:"inProgress"`
+	NotYetStarted         int   `json:"notYetStarted"`
+	Cancelled             int   `json:"cancelled"`
+	TotalCharacterCharged int64 `json:"totalCharacterCharged"`
+}
+
+// BatchStatus is the status of a translation job returned by GET /translator/document/batches/{id}.
+type BatchStatus struct {
+	ID                    string        `json:"id"`
+	CreatedDateTimeUtc    time.Time     `json:"createdDateTimeUtc"`
+	LastActionDateTimeUtc time.Time     `json:"lastActionDateTimeUtc"`
+	Status                Status        `json:"status"`
+	Error                 *ServiceError `json:"error,omitempty"`
+	Summary               StatusSummary `json:"summary"`
+}
+
+// DocumentStatus is the status of a single document returned by GET /translator/document/batches/{id}/documents.
+type DocumentStatus struct {
+	ID                    string        `json:"id"`
+	Path                  string        `json:"path"`
+	SourcePath            string        `json:"sourcePath"`
+	CreatedDateTimeUtc    time.Time     `json:"createdDateTimeUtc"`
+	LastActionDateTimeUtc time.Time     `json:"lastActionDateTimeUtc"`
+	Status                Status        `json:"status"`
+	To                    string        `json:"to"`
+	Error                 *ServiceError `json:"error,omitempty"`
+	Progress              float64       `json:"progress"`
+	CharacterCharged      int64         `json:"characterCharged"`
+}
+
+// batchesStatusResponse is one page of the translation jobs list.
+type batchesStatusResponse struct {
+	Value    []BatchStatus `json:"value"`
+	NextLink string        `json:"@nextLink"`
+}
+
+// documentsStatusResponse is one page of the documents status list.
+type documentsStatusResponse struct {
+	Value    []DocumentStatus `json:"value"`
+	NextLink string           `json:"@nextLink"`
+}
+
+// newTranslatorRequest creates an HTTP request to the Translator service with the authentication headers set:
+// a Bearer token of the Microsoft Entra ID credential of the configuration if it has one, its subscription key otherwise.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object.
+// - method: The HTTP method.
+// - uri: The full request URI.
+// - body: The request body, may be nil.
+// It returns the request and an error if any.
+func newTranslatorRequest(ctx context.Context, config TranslatorConfig, method, uri string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := config.tokenCredential()
+	if err != nil {
+		return nil, err
+	}
+	if credential != nil {
+		token, err := getToken(ctx, credential, config.Credential.TranslatorScope())
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+token)
+		if config.TranslatorRegion != "" {
+			req.Header.Add("Ocp-Apim-Subscription-Region", config.TranslatorRegion)
+		}
+		return req, nil
+	}
+	req.Header.Add("Ocp-Apim-Subscription-Key", config.TranslatorKey)
+	req.Header.Add("Ocp-Apim-Subscription-Region", config.TranslatorRegion)
+	return req, nil
+}
+
+// cancelTranslation cancels the translation job at operationURL.
+// Documents already being translated are still translated and charged.
+// It returns a *CancelError if the service did not accept the cancellation, or an error if the request failed.
+func cancelTranslation(ctx context.Context, config TranslatorConfig, operationURL string) error {
+	res, err := doRequest(ctx, config, func() (*http.Request, error) {
+		return newTranslatorRequest(ctx, config, http.MethodDelete, operationURL, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return newCancelError(batchIDFromOperationURL(operationURL), res)
+	}
+	return nil
+}
+
+// getJSON sends a GET request to the Translator service and decodes the JSON response into v.
+// The request is retried according to the retry policy of the configuration.
+// It returns an error if the request fails or if the service does not answer with 200 OK.
+func getJSON(ctx context.Context, config TranslatorConfig, uri string, v interface{}) error {
+	res, err := doRequest(ctx, config, func() (*http.Request, error) {
+		return newTranslatorRequest(ctx, config, http.MethodGet, uri, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("unexpected response status %s: %s", res.Status, string(body))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// getBatchStatus retrieves the status of a translation job.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object.
+// - operationURL: The job URL returned in the Operation-Location header of the batch submission.
+// It returns the job status and an error if any.
+func getBatchStatus(ctx context.Context, config TranslatorConfig, operationURL string) (*BatchStatus, error) {
+	var status BatchStatus
+	if err := getJSON(ctx, config, operationURL, &status); err != nil {
+		return nil, fmt.Errorf("error getting translation status: %w", err)
+	}
+	return &status, nil
+}
+
+// getDocumentsStatus retrieves the status of every document of a translation job, following pagination.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object.
+// - operationURL: The job URL returned in the Operation-Location header of the batch submission.
+// It returns the documents status and an error if any.
+func getDocumentsStatus(ctx context.Context, config TranslatorConfig, operationURL string) ([]DocumentStatus, error) {
+	u, err := url.Parse(operationURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operation URL: %v", err)
+	}
+	u.Path = path.Join(u.Path, "documents")
+	next := u.String()
+
+	documents := []DocumentStatus{}
+	for next != "" {
+		var page documentsStatusResponse
+		if err := getJSON(ctx, config, next, &page); err != nil {
+			return nil, fmt.Errorf("error getting documents status: %w", err)
+		}
+		documents = append(documents, page.Value...)
+		next = page.NextLink
+	}
+	return documents, nil
+}
+
+// getBatchesStatus retrieves the status of the translation jobs of the Translator resource, following pagination.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object.
+// It returns the jobs status, the most recent first, and an error if any.
+func getBatchesStatus(ctx context.Context, config TranslatorConfig) ([]BatchStatus, error) {
+	next := translatorURL(config, "/translator/document/batches", url.Values{"$orderBy": {"createdDateTimeUtc desc"}})
+	batches := []BatchStatus{}
+	for next != "" {
+		var page batchesStatusResponse
+		if err := getJSON(ctx, config, next, &page); err != nil {
+			return nil, fmt.Errorf("error listing translation jobs: %w", err)
+		}
+		batches = append(batches, page.Value...)
+		next = page.NextLink
+	}
+	return batches, nil
+}
+
+// operationURLFromBatchID builds the job URL of a translation job from its ID.
+func operationURLFromBatchID(config TranslatorConfig, batchID string) string {
+	return translatorURL(config, "/translator/document/batches/"+url.PathEscape(batchID), nil)
+}
+
+// batchIDFromOperationURL extracts the job ID from the Operation-Location URL.
+func batchIDFromOperationURL(operationURL string) string {
+	u, err := url.Parse(operationURL)
+	if err != nil {
+		return ""
+	}
+	return path.Base(u.Path)
+}
@@ -0,0 +1,179 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the translation job status polling.
+*/
+
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newStatusTestServer starts a server answering the batch status and documents status endpoints
+// with the given JSON bodies.
+func newStatusTestServer(t *testing.T, batchJSON, documentsJSON string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Ocp-Apim-Subscription-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/documents"):
+			fmt.Fprint(w, documentsJSON)
+		default:
+			fmt.Fprint(w, batchJSON)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestGetBatchStatus is a test function that tests the getBatchStatus and getDocumentsStatus functions.
+func TestGetBatchStatus(t *testing.T) {
+	server := newStatusTestServer(t,
+		`{"id":"job1","status":"Running","summary":{"total":2,"inProgress":1,"success":1}}`,
+		`{"value":[{"id":"doc1","status":"Succeeded","to":"fr"},{"id":"doc2","status":"Running","to":"de"}]}`)
+	config := TranslatorConfig{TranslatorKey: "key"}
+	operationURL := server.URL + "/translator/document/batches/job1?api-version=" + APIVersion
+
+	status, err := getBatchStatus(context.Background(), config, operationURL)
+	if err != nil {
+		t.Fatalf("getBatchStatus failed: %v", err)
+	}
+	if status.ID != "job1" || status.Status != StatusRunning || status.Summary.Total != 2 {
+		t.Errorf("getBatchStatus returned unexpected status: %+v", status)
+	}
+
+	documents, err := getDocumentsStatus(context.Background(), config, operationURL)
+	if err != nil {
+		t.Fatalf("getDocumentsStatus failed: %v", err)
+	}
+	if len(documents) != 2 || documents[1].To != "de" || documents[1].Status != StatusRunning {
+		t.Errorf("getDocumentsStatus returned unexpected documents: %+v", documents)
+	}
+
+	if id := batchIDFromOperationURL(operationURL); id != "job1" {
+		t.Errorf("batchIDFromOperationURL returned %q, expected job1", id)
+	}
+}
+
+// TestWaitForBatchTimeout is a test function that checks that the timeout includes the time of the status requests.
+func TestWaitForBatchTimeout(t *testing.T) {
+	var cancelled atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			cancelled.Store(true)
+			fmt.Fprint(w, `{"id":"job1","status":"Cancelling"}`)
+			return
+		}
+		time.Sleep(700 * time.Millisecond)
+		fmt.Fprint(w, `{"id":"job1","status":"Running"}`)
+	}))
+	defer server.Close()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	config := TranslatorConfig{TranslatorKey: "key", Timeout: 2, Logger: log}
+
+	start := time.Now()
+	_, err := waitForBatch(context.Background(), config, server.URL+"/translator/document/batches/job1")
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !cancelled.Load() {
+		t.Fatalf("waitForBatch returned %v, expected a *TimeoutError and the job cancelled", err)
+	}
+	// Counting the polls instead of the time would wait for 3 status requests and 2 sleeps, more than 4s.
+	if elapsed := time.Since(start); elapsed > 3500*time.Millisecond {
+		t.Errorf("waitForBatch timed out after %v, expected about 2s", elapsed)
+	}
+}
+
+// TestWaitForBatchCancel is a test function that cancels the context during a status request
+// and checks that the job is cancelled on the service and the context error is returned.
+func TestWaitForBatchCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var cancelled atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			cancelled.Store(true)
+			fmt.Fprint(w, `{"id":"job1","status":"Cancelling"}`)
+			return
+		}
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	config := TranslatorConfig{TranslatorKey: "key", Timeout: 30, Logger: log}
+
+	_, err := waitForBatch(ctx, config, server.URL+"/translator/document/batches/job1")
+	if !errors.Is(err, context.Canceled) || !cancelled.Load() {
+		t.Errorf("waitForBatch returned %v, cancelled on the service: %v, expected the context error and the job cancelled", err, cancelled.Load())
+	}
+}
+
+// TestFinishTranslationLanguages is a test function that matches the languages of the documents with those of the targets
+// regardless of their case, and reports a target without a translated document.
+func TestFinishTranslationLanguages(t *testing.T) {
+	server := newStatusTestServer(t, `{"id":"job1","status":"Succeeded"}`,
+		`{"value":[{"id":"doc1","status":"Succeeded","to":"zh-hans"}]}`)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	storage := NewMemoryStorage()
+	config := TranslatorConfig{TranslatorKey: "key", Timeout: 5, Logger: log, Storage: storage}
+	storage.Put(context.Background(), "job-translated-zh-Hans-report.txt", strings.NewReader("translated"))
+	dir := t.TempDir()
+	status := &BatchStatus{ID: "job1", Status: StatusSucceeded}
+	operationURL := server.URL + "/translator/document/batches/job1"
+
+	target := JobTarget{Language: "zh-Hans", Blob: "job-translated-zh-Hans-report.txt", DestinationFile: filepath.Join(dir, "report.zh-Hans.txt")}
+	if err := finishTranslation(context.Background(), config, operationURL, status, []JobTarget{target}); err != nil {
+		t.Fatalf("finishTranslation failed: %v", err)
+	}
+	if content, err := os.ReadFile(target.DestinationFile); err != nil || string(content) != "translated" {
+		t.Errorf("finishTranslation wrote %q, %v", content, err)
+	}
+
+	missing := JobTarget{Language: "fr", Blob: "job-translated-fr-report.txt", DestinationFile: filepath.Join(dir, "report.fr.txt")}
+	if err := finishTranslation(context.Background(), config, operationURL, status, []JobTarget{target, missing}); err == nil || !strings.Contains(err.Error(), "no translated document in fr") {
+		t.Errorf("finishTranslation returned %v, expected an error for the target without a document", err)
+	}
+}
+
+// TestWaitForTranslatedFileFailure is a test function that checks that a failed job is reported with the service error code.
+func TestWaitForTranslatedFileFailure(t *testing.T) {
+	server := newStatusTestServer(t,
+		`{"id":"job1","status":"ValidationFailed","error":{"code":"InvalidRequest","message":"Cannot access source document location","innerError":{"code":"InvalidDocumentAccessLevel","message":"The SAS token does not have read permission"}}}`,
+		`{"value":[]}`)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	config := TranslatorConfig{TranslatorKey: "key", Timeout: 5, Logger: log}
+
+	err := waitForTranslatedFile(context.Background(), config, server.URL+"/translator/document/batches/job1", nil)
+	if err == nil {
+		t.Fatal("waitForTranslatedFile succeeded on a failed job")
+	}
+	var jobErr *JobError
+	if !errors.As(err, &jobErr) {
+		t.Fatalf("waitForTranslatedFile returned %T, expected *JobError", err)
+	}
+	if jobErr.Status != StatusValidationFailed || jobErr.Err.InnerError.Code != "InvalidDocumentAccessLevel" {
+		t.Errorf("waitForTranslatedFile error does not carry the service error: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBlockSize is the size in bytes of the blocks uploaded and downloaded in parallel.
+	DefaultBlockSize = 4 << 20
+	// DefaultParallelism is the number of blocks transferred in parallel.
+	DefaultParallelism = 4
+	// MaxBlockSize is the largest block accepted by Azure Blob Storage.
+	MaxBlockSize = 4000 << 20
+	// MaxParallelism is the largest number of blocks transferred in parallel.
+	MaxParallelism = 64
+)
+
+// Storage is the staging store where the documents are uploaded before the translation
+// and where the Translator service writes the translated documents.
+// Names are blob names relative to the store root, "/" separates virtual folders.
+type Storage interface {
+	// Put stores the content read from r under name, replacing any existing content.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Get writes the content stored under name to w.
+	Get(ctx context.Context, name string, w io.Writer) error
+	// Delete removes the content stored under name.
+	Delete(ctx context.Context, name string) error
+	// List returns the names starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	// SignedURL returns a URL granting the Translator service the given permissions on name until expiry.
+	// A name ending with "/" or an empty name designates a folder, the URL is then signed for the whole store,
+	// see ContainerStorage.
+	SignedURL(ctx context.Context, name string, permissions Permissions, expiry time.Time) (string, error)
+}
+
+// FileGetter is implemented by the storages able to download a blob to a file faster than Storage.Get,
+// for instance with parallel ranged requests.
+type FileGetter interface {
+	// GetFile writes the content stored under name to file, from its start.
+	// progress is called with the number of bytes downloaded so far and the size of the content.
+	GetFile(ctx context.Context, name string, file *os.File, progress func(bytes, total int64)) error
+}
+
+// DefaultSASExpiryMargin is added to the job timeout to compute the lifetime of the signed URLs when SASOptions.Expiry is zero.
+const DefaultSASExpiryMargin = 15 * time.Minute
+
+// SASOptions control the signed URLs granting the Translator service access to the staging storage.
+type SASOptions struct {
+	// Expiry is the lifetime of the signed URLs, the job timeout plus DefaultSASExpiryMargin if zero.
+	Expiry Duration `json:"expiry"`
+	// IPRange restricts the signed URLs to an IP address or to an inclusive range "start-end" of addresses,
+	// such as the outbound addresses of the Translator resource. Any address is allowed if empty.
+	IPRange string `json:"ipRange"`
+}
+
+// parseIPRange parses an IP address or an inclusive range "start-end" of IP addresses.
+// It returns the first and the last address, equal for a single address, and an error if the range is invalid.
+func parseIPRange(ipRange string) (net.IP, net.IP, error) {
+	first, last, isRange := strings.Cut(ipRange, "-")
+	start := net.ParseIP(strings.TrimSpace(first))
+	end := start
+	if isRange {
+		end = net.ParseIP(strings.TrimSpace(last))
+	}
+	if start == nil || end == nil {
+		return nil, nil, fmt.Errorf("invalid IP range %q, expected an address or start-end", ipRange)
+	}
+	return start, end, nil
+}
+
+// sasExpiry returns the expiry time of the signed URLs issued now.
+func (config TranslatorConfig) sasExpiry() time.Time {
+	lifetime := time.Duration(config.SAS.Expiry)
+	if lifetime <= 0 {
+		lifetime = time.Duration(config.Timeout)*time.Second + DefaultSASExpiryMargin
+	}
+	return time.Now().Add(lifetime)
+}
+
+// BlobInfo describes a blob of a storage.
+type BlobInfo struct {
+	Name         string
+	Size         int64
+	LastModified time.Time
+}
+
+// InfoLister is implemented by the storages able to list their blobs with their size and modification time.
+type InfoLister interface {
+	// ListInfo returns the blobs whose names start with prefix.
+	ListInfo(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
+
+// ContainerInfo describes a container of a storage account.
+type ContainerInfo struct {
+	Name         string
+	LastModified time.Time
+}
+
+// ErrForbidden is wrapped by the errors of the ContainerStorage operations that the credentials of the storage
+// are not authorized to perform, e.g. because their role is scoped to a single container.
+var ErrForbidden = errors.New("operation not authorized")
+
+// ContainerStorage is implemented by the storages able to manage the other containers of their account.
+// A folder translation job gets a container of its own, so that the container SAS it grants the Translator service,
+// which cannot be restricted to a virtual folder, only gives access to the blobs of the job.
+// The operations the credentials are not authorized to perform return an error wrapping ErrForbidden.
+type ContainerStorage interface {
+	// Container returns the Storage of the container name of the same account, with the same settings.
+	Container(name string) (Storage, error)
+	// CreateContainer creates the container name, it succeeds if the container already exists.
+	CreateContainer(ctx context.Context, name string) error
+	// DeleteContainer deletes the container name and its blobs, it succeeds if the container does not exist.
+	DeleteContainer(ctx context.Context, name string) error
+	// ListContainers returns the containers whose names start with prefix.
+	ListContainers(ctx context.Context, prefix string) ([]ContainerInfo, error)
+}
+
+// TransferOptions control how the documents are uploaded to and downloaded from the staging storage.
+// The memory used by a transfer is about BlockSize * Parallelism, whatever the size of the document.
+type TransferOptions struct {
+	// BlockSize is the size in bytes of the blocks of a transfer, DefaultBlockSize if zero.
+	BlockSize int64 `json:"blockSize"`
+	// Parallelism is the number of blocks transferred in parallel, DefaultParallelism if zero.
+	Parallelism int `json:"parallelism"`
+}
+
+// withDefaults returns the options with the zero fields set to their default value.
+func (o TransferOptions) withDefaults() TransferOptions {
+	if o.BlockSize <= 0 {
+		o.BlockSize = DefaultBlockSize
+	}
+	if o.Parallelism <= 0 {
+		o.Parallelism = DefaultParallelism
+	}
+	return o
+}
+
+// validate returns the options with their defaults, see withDefaults.
+// It returns an error if the block size exceeds MaxBlockSize or the parallelism exceeds MaxParallelism.
+func (o TransferOptions) validate() (TransferOptions, error) {
+	o = o.withDefaults()
+	if o.BlockSize > MaxBlockSize {
+		return o, fmt.Errorf("invalid block size %d, the maximum is %d", o.BlockSize, MaxBlockSize)
+	}
+	if o.Parallelism > MaxParallelism {
+		return o, fmt.Errorf("invalid parallelism %d, the maximum is %d", o.Parallelism, MaxParallelism)
+	}
+	return o, nil
+}
+
+// Permissions are the operations granted by a signed URL.
+type Permissions struct {
+	Read   bool
+	Write  bool
+	Delete bool
+	List   bool
+}
+
+// String returns the permissions in the "rwdl" order used by Azure SAS tokens.
+func (p Permissions) String() string {
+	s := ""
+	if p.Read {
+		s += "r"
+	}
+	if p.Write {
+		s += "w"
+	}
+	if p.Delete {
+		s += "d"
+	}
+	if p.List {
+		s += "l"
+	}
+	return s
+}
+
+// storage returns the Storage of the configuration, or the Azure Blob Storage
+// built from the blob account settings if none is set, authenticated with the Microsoft Entra ID
+// credential of the configuration if it has one, with the account key otherwise.
+func (config TranslatorConfig) storage() (Storage, error) {
+	if config.Storage != nil {
+		return config.Storage, nil
+	}
+	if _, err := config.Transfer.validate(); err != nil {
+		return nil, err
+	}
+	credential, err := config.tokenCredential()
+	if err != nil {
+		return nil, err
+	}
+	var storage *AzureBlobStorage
+	if credential != nil {
+		storage, err = NewAzureBlobStorageWithCredential(config.BlobServiceURL, config.BlobAccountName, config.BlobContainerName, credential)
+	} else {
+		storage, err = NewAzureBlobStorage(config.BlobServiceURL, config.BlobAccountName, config.BlobAccountKey, config.BlobContainerName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	storage.Transfer = config.Transfer
+	storage.IPRange = config.SAS.IPRange
+	return storage, nil
+}
+
+// withSharedClients returns the configuration with its TokenCredential and its Storage created once if they are nil,
+// the storage only if a blob account is set. The operations of a translation then share the credential and its
+// cached tokens, and the storage and its user delegation keys, instead of creating them for each request.
+// It returns an error if the credential or the storage cannot be created.
+func (config TranslatorConfig) withSharedClients() (TranslatorConfig, error) {
+	if config.TokenCredential == nil {
+		credential, err := NewTokenCredential(config.Credential, config.HTTPClient)
+		if err != nil {
+			return config, err
+		}
+		config.TokenCredential = credential
+	}
+	if config.Storage == nil && config.BlobAccountName != "" {
+		storage, err := config.storage()
+		if err != nil {
+			return config, err
+		}
+		config.Storage = storage
+	}
+	return config, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the staging storage.
+*/
+
+package translator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestMemoryStorage is a test function that tests the MemoryStorage operations.
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	for _, name := range []string{"job-a.docx", "job-b.docx", "other.docx"} {
+		if err := storage.Put(context.Background(), name, strings.NewReader(name)); err != nil {
+			t.Fatalf("Put(%s) failed: %v", name, err)
+		}
+	}
+
+	var content bytes.Buffer
+	if err := storage.Get(context.Background(), "job-b.docx", &content); err != nil || content.String() != "job-b.docx" {
+		t.Errorf("Get returned %q (%v)", content.String(), err)
+	}
+
+	names, err := storage.List(context.Background(), "job-")
+	if err != nil || !reflect.DeepEqual(names, []string{"job-a.docx", "job-b.docx"}) {
+		t.Errorf("List returned %v (%v)", names, err)
+	}
+
+	if err := storage.Delete(context.Background(), "job-a.docx"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if err := storage.Get(context.Background(), "job-a.docx", io.Discard); err == nil {
+		t.Error("Get succeeded on a deleted blob")
+	}
+
+	signedURL, err := storage.SignedURL(context.Background(), "job-b.docx", Permissions{Read: true}, time.Now().Add(time.Hour))
+	if err != nil || !strings.HasPrefix(signedURL, "memory://storage/job-b.docx?") || !strings.Contains(signedURL, "sp=r") {
+		t.Errorf("SignedURL returned %q (%v)", signedURL, err)
+	}
+}
+
+// TestTranslateDocumentWithMemoryStorage is a test function that runs the whole asynchronous translation workflow offline,
+// against a MemoryStorage and a minimal batches API.
+func TestTranslateDocumentWithMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	var server *httptest.Server
+	var targets []Target
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			var doc ATranslateDocument
+			json.NewDecoder(r.Body).Decode(&doc)
+			source, _ := url.Parse(doc.Inputs[0].Source.SourceURL)
+			var content bytes.Buffer
+			storage.Get(context.Background(), strings.TrimPrefix(source.Path, "/"), &content)
+			targets = doc.Inputs[0].Targets
+			for _, target := range targets {
+				targetURL, _ := url.Parse(target.TargetURL)
+				storage.Put(context.Background(), strings.TrimPrefix(targetURL.Path, "/"), bytes.NewReader(bytes.ToUpper(content.Bytes())))
+			}
+			w.Header().Set("Operation-Location", server.URL+"/translator/document/batches/job1")
+			w.WriteHeader(http.StatusAccepted)
+		case strings.HasSuffix(r.URL.Path, "/documents"):
+			documents := []DocumentStatus{}
+			for i, target := range targets {
+				documents = append(documents, DocumentStatus{ID: fmt.Sprint(i), Status: StatusSucceeded, To: target.Language})
+			}
+			json.NewEncoder(w).Encode(documentsStatusResponse{Value: documents})
+		default:
+			fmt.Fprint(w, `{"id":"job1","status":"Succeeded"}`)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	config := TranslatorConfig{TranslatorEndpoint: server.URL, Timeout: 5, Storage: storage, Logger: log}
+
+	err := TranslateDocumentToLanguages(source, filepath.Join(dir, "{name}.{lang}{ext}"), "", []string{"fr", "de"}, config)
+	if err != nil {
+		t.Fatalf("TranslateDocumentToLanguages failed: %v", err)
+	}
+	for _, language := range []string{"fr", "de"} {
+		translated, err := os.ReadFile(filepath.Join(dir, "source."+language+".txt"))
+		if err != nil || string(translated) != "HELLO" {
+			t.Errorf("translation to %s is %q (%v), expected HELLO", language, translated, err)
+		}
+	}
+	if names, _ := storage.List(context.Background(), ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+}
+
+// TestWithSharedClients is a test function that checks that the credential and the storage are created once per translation.
+func TestWithSharedClients(t *testing.T) {
+	config := TranslatorConfig{BlobAccountName: "devstoreaccount1", BlobAccountKey: azuriteAccountKey, BlobContainerName: "translator"}
+	shared, err := config.withSharedClients()
+	if err != nil {
+		t.Fatalf("withSharedClients failed: %v", err)
+	}
+	storage, err := shared.storage()
+	if _, ok := storage.(*AzureBlobStorage); !ok || err != nil || storage != shared.Storage {
+		t.Errorf("storage returned %v, %v, expected the shared Azure Blob Storage", storage, err)
+	}
+
+	config = TranslatorConfig{TokenCredential: staticCredential{storageScope: "storage-token"}}
+	if shared, err := config.withSharedClients(); err != nil || shared.Storage != nil {
+		t.Errorf("withSharedClients without a blob account returned the storage %v, %v", shared.Storage, err)
+	}
+	// No storage token is requested before the first blob operation.
+	config = TranslatorConfig{TokenCredential: staticCredential{}, BlobAccountName: "devstoreaccount1", BlobContainerName: "translator"}
+	if shared, err := config.withSharedClients(); err != nil || shared.Storage == nil {
+		t.Errorf("withSharedClients with a credential returned the storage %v, %v, expected a storage without a token", shared.Storage, err)
+	}
+	config.Credential.Type = "unknown"
+	config.TokenCredential = nil
+	if _, err := config.withSharedClients(); err == nil {
+		t.Error("withSharedClients accepted an unknown credential")
+	}
+}
@@ -0,0 +1,166 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package translator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// glossaryContentTypes maps the glossary formats to the content type of the multipart glossary part.
+var glossaryContentTypes = map[string]string{
+	"TSV":   "text/tab-separated-values",
+	"CSV":   "text/csv",
+	"XLIFF": "application/xliff+xml",
+}
+
+// UseSyncTranslation reports whether a document is translated with the synchronous endpoint.
+// It is the case when config.Sync is set, or when the file size does not exceed config.SyncThreshold.
+// A zero or negative threshold disables the automatic selection.
+func UseSyncTranslation(config TranslatorConfig, fileToTranslate string) bool {
+	if config.Sync {
+		return true
+	}
+	if config.SyncThreshold <= 0 {
+		return false
+	}
+	info, err := os.Stat(fileToTranslate)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return info.Size() <= config.SyncThreshold
+}
+
+// writeMultipartFile writes a file as a part of a multipart form.
+// It takes the multipart writer, the form field name, the file path, the content type of the part
+// and a function reporting the upload progress, which may be nil.
+// It returns an error if any.
+func writeMultipartFile(writer *multipart.Writer, fieldName, filePath, contentType string, report func(ProgressEvent)) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if report != nil {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		r = &progressReader{r: file, event: ProgressEvent{Kind: ProgressUpload, Name: filePath, Total: info.Size()}, report: report}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, fieldName, filepath.Base(filePath)))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, r)
+	return err
+}
+
+// translateDocumentSync translates a document with the synchronous translator/document:translate endpoint.
+// The document is sent as a multipart upload and the translated document is streamed to destinationFile,
+// no Azure Blob Storage account is involved.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object.
+// - fileToTranslate: The path to the local file to be translated.
+// - destinationFile: The path to save the translated file.
+// - sourceLanguage: The language of the source document if the provided string has zero length, the service will attempt to auto-detect the language.
+// - targetLanguage: The language to translate the document to.
+// It returns a *SubmissionError if the service rejected the document, or an error if any.
+func translateDocumentSync(ctx context.Context, config TranslatorConfig, fileToTranslate, destinationFile, sourceLanguage, targetLanguage string) error {
+	query := url.Values{}
+	query.Set("targetLanguage", targetLanguage)
+	if sourceLanguage != "" {
+		query.Set("sourceLanguage", sourceLanguage)
+	}
+	if category := config.Category(targetLanguage); category != "" {
+		query.Set("category", category)
+	}
+	uri := translatorURL(config, "/translator/document:translate", query)
+
+	var glossaryFormat string
+	if config.GlossaryFile != "" {
+		format, err := GlossaryFormat(config.GlossaryFile, config.GlossaryFormat)
+		if err != nil {
+			return err
+		}
+		glossaryFormat = format
+	}
+
+	config.Logger.Debugf("Translating %s to %s synchronously", fileToTranslate, targetLanguage)
+	res, err := doRequest(ctx, config, func() (*http.Request, error) {
+		// Stream the multipart body instead of buffering the whole document, the files are read again on each attempt.
+		bodyReader, bodyWriter := io.Pipe()
+		writer := multipart.NewWriter(bodyWriter)
+		go func() {
+			contentType := mime.TypeByExtension(filepath.Ext(fileToTranslate))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			err := writeMultipartFile(writer, "document", fileToTranslate, contentType, config.progress)
+			if err == nil && glossaryFormat != "" {
+				err = writeMultipartFile(writer, "glossary", config.GlossaryFile, glossaryContentTypes[glossaryFormat], nil)
+			}
+			if err == nil {
+				err = writer.Close()
+			}
+			bodyWriter.CloseWithError(err)
+		}()
+
+		req, err := newTranslatorRequest(ctx, config, http.MethodPost, uri, bodyReader)
+		if err != nil {
+			bodyReader.Close()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	config.Logger.Debugf("response status: %s", res.Status)
+	if res.StatusCode != http.StatusOK {
+		return newSubmissionError(res)
+	}
+
+	// Stream the translated document to the destination file.
+	err = os.MkdirAll(filepath.Dir(destinationFile), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(destinationFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	event := ProgressEvent{Kind: ProgressDownload, Name: destinationFile, Total: max(res.ContentLength, 0)}
+	writer := &progressWriter{w: file, event: event, report: config.progress}
+	if _, err := io.Copy(writer, res.Body); err != nil {
+		return fmt.Errorf("error writing translated document: %v", err)
+	}
+	if event.Total != writer.bytes {
+		// The size is known once the download is complete.
+		event.Bytes, event.Total = writer.bytes, writer.bytes
+		config.progress(event)
+	}
+
+	config.Logger.Infof("Translated document (%s) saved to %s", targetLanguage, destinationFile)
+	return nil
+}
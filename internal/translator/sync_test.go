@@ -0,0 +1,107 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the synchronous document translation.
+*/
+
+package translator
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestTranslateDocumentSync is a test function that tests the synchronous translation of a document.
+func TestTranslateDocumentSync(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/translator/document:translate" || r.URL.Query().Get("targetLanguage") != "fr" || r.URL.Query().Get("category") != "abc123" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":{"code":"InvalidRequest","message":"unexpected request"}}`)
+			return
+		}
+		document, _, err := r.FormFile("document")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer document.Close()
+		content, _ := io.ReadAll(document)
+		w.Write(bytes.ToUpper(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	config := TranslatorConfig{
+		TranslatorEndpoint: server.URL,
+		TranslatorKey:      "key",
+		Timeout:            5,
+		Sync:               true,
+		Categories:         map[string]string{"fr": "abc123"},
+		Logger:             log,
+	}
+
+	err := TranslateDocument(source, filepath.Join(dir, "out", "{name}.{lang}{ext}"), "", "fr", config)
+	if err != nil {
+		t.Fatalf("TranslateDocument failed: %v", err)
+	}
+	translated, err := os.ReadFile(filepath.Join(dir, "out", "source.fr.txt"))
+	if err != nil || string(translated) != "HELLO" {
+		t.Errorf("TranslateDocument wrote %q (%v), expected HELLO", translated, err)
+	}
+
+	// A rejected document is reported as a *SubmissionError
+	err = TranslateDocument(source, filepath.Join(dir, "out.txt"), "", "de", config)
+	var submissionErr *SubmissionError
+	if !errors.As(err, &submissionErr) || submissionErr.Err.Code != "InvalidRequest" {
+		t.Errorf("TranslateDocument returned %v, expected a *SubmissionError", err)
+	}
+}
+
+// TestUseSyncTranslation is a test function that tests the selection of the synchronous translation.
+func TestUseSyncTranslation(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source.txt")
+	if err := os.WriteFile(source, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if UseSyncTranslation(TranslatorConfig{}, source) {
+		t.Error("UseSyncTranslation selected the synchronous translation without threshold")
+	}
+	if !UseSyncTranslation(TranslatorConfig{SyncThreshold: 100}, source) {
+		t.Error("UseSyncTranslation did not select the synchronous translation below the threshold")
+	}
+	if UseSyncTranslation(TranslatorConfig{SyncThreshold: 99}, source) {
+		t.Error("UseSyncTranslation selected the synchronous translation above the threshold")
+	}
+	if !UseSyncTranslation(TranslatorConfig{Sync: true, SyncThreshold: 99}, source) {
+		t.Error("UseSyncTranslation did not select the forced synchronous translation")
+	}
+}
+
+// TestClientRequestTimeout is a test function that checks that the HTTP client of a Client does not bound each request
+// by the job timeout, which would cut the synchronous translations of large documents.
+func TestClientRequestTimeout(t *testing.T) {
+	client, err := NewClient(TranslatorConfig{TranslatorEndpoint: "https://example.com", Timeout: 1})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if timeout := client.Config().HTTPClient.Timeout; timeout != 0 {
+		t.Errorf("NewClient created an HTTP client with a %s timeout, expected none", timeout)
+	}
+}
@@ -5,9 +5,9 @@
 package translator
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -16,7 +16,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
@@ -32,17 +32,28 @@ type Input struct {
 }
 
 type Source struct {
-	SourceURL string `json:"sourceUrl"`
-	Language  string `json:"language,omitempty"`
+	SourceURL string  `json:"sourceUrl"`
+	Filter    *Filter `json:"filter,omitempty"`
+	Language  string  `json:"language,omitempty"`
+}
+
+// Filter selects the documents of a folder source by blob name prefix and suffix.
+type Filter struct {
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
 }
 
 type Target struct {
-	TargetURL string `json:"targetUrl"`
-	Language  string `json:"language"`
+	TargetURL  string     `json:"targetUrl"`
+	Language   string     `json:"language"`
+	Category   string     `json:"category,omitempty"`
+	Glossaries []Glossary `json:"glossaries,omitempty"`
 }
 
 const (
 	APIVersion = "2024-05-01"
+	// cleanupTimeout bounds the deletion of the temporary blobs and the cancellation of a job.
+	cleanupTimeout = 30 * time.Second
 )
 
 // TranslatorConfig represents the configuration for the Translator service.
@@ -50,64 +61,108 @@ type TranslatorConfig struct {
 	BlobAccountName    string `json:"blobAccountName"`
 	BlobAccountKey     string `json:"blobAccountKey"`
 	BlobContainerName  string `json:"blobContainerName"`
+	BlobServiceURL     string `json:"blobServiceUrl"`
 	TranslatorEndpoint string `json:"translatorEndpoint"`
 	TranslatorKey      string `json:"translatorKey"`
 	TranslatorRegion   string `json:"translatorRegion"`
 	Timeout            int    `json:"timeout"`
 	Verbose            bool   `json:"verbose"`
-	Logger             *logrus.Logger
+	// GlossaryFile is the glossary applied to every target language of a translation, none if empty.
+	// It is set per translation, not by the configuration file.
+	GlossaryFile string `json:"-"`
+	// GlossaryFormat is the format of the glossary, checked against the extension of GlossaryFile, derived from it if empty.
+	GlossaryFormat string `json:"-"`
+	// Sync forces the synchronous translation of single documents, without Azure Blob Storage.
+	// It is set per translation, not by the configuration file.
+	Sync bool `json:"-"`
+	// SyncThreshold is the file size in bytes up to which single documents are translated synchronously, 0 disables it.
+	SyncThreshold int64 `json:"syncThreshold"`
+	// Credential selects the Microsoft Entra ID credential used instead of the Translator and storage account keys.
+	Credential CredentialOptions `json:"credential"`
+	// TokenCredential authenticates the requests instead of the keys, the credential selected by Credential if nil.
+	TokenCredential azcore.TokenCredential `json:"-"`
+	// SAS sets the lifetime and the IP range of the signed URLs given to the Translator service.
+	SAS SASOptions `json:"sas"`
+	// Transfer sets the block size and the parallelism of the uploads and downloads of the staging storage.
+	Transfer TransferOptions `json:"transfer"`
+	// Retry is the retry policy of the requests to the Translator service and of the staging storage operations.
+	Retry RetryPolicy `json:"retry"`
+	// Categories maps a target language to the Custom Translator category ID used to translate to it,
+	// the languages are matched case-insensitively.
+	Categories map[string]string `json:"categories"`
+	// Progress receives the progress events of the translations, they are not reported if nil.
+	Progress ProgressReporter `json:"-"`
+	// Storage is the staging store of the documents, an AzureBlobStorage built from the blob settings if nil.
+	Storage Storage `json:"-"`
+	// HTTPClient sends the requests to the Translator service, a client with the configured timeout is used if nil.
+	HTTPClient *http.Client `json:"-"`
+	// UnsafeLogSecrets disables the redaction of the secrets in the logs and the errors of a Client, for debugging.
+	UnsafeLogSecrets bool `json:"-"`
+	// KeepBlobs keeps the temporary blobs of the jobs in the staging storage instead of deleting them, for debugging.
+	KeepBlobs bool `json:"keepBlobs"`
+	// Jobs records the translation jobs so that they can be resumed with ResumeJob, they are not recorded if nil.
+	Jobs   JobStore `json:"-"`
+	Logger *logrus.Logger
 }
 
-// uploadFileToBlobStorage uploads a local file to Azure Blob Storage.
+// Category returns the Custom Translator category ID configured for a target language,
+// or an empty string to use the general translation model.
+// The language tags are case-insensitive, so a category configured for zh-hans is used for zh-Hans.
+func (config TranslatorConfig) Category(language string) string {
+	if category, ok := config.Categories[strings.ToLower(language)]; ok {
+		return category
+	}
+	for categoryLanguage, category := range config.Categories {
+		if strings.EqualFold(categoryLanguage, language) {
+			return category
+		}
+	}
+	return ""
+}
+
+// httpClient returns the HTTP client of the configuration, or the default HTTP client if none is set.
+// The requests are bounded by their context, not by the timeout of the configuration, which bounds the polling of a job.
+func (config TranslatorConfig) httpClient() *http.Client {
+	if config.HTTPClient != nil {
+		return config.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// uploadFileToBlobStorage uploads a local file to the staging storage.
+// The upload is retried according to the retry policy of the configuration.
 // It takes the following parameters:
+// - ctx: The context of the operation.
 // - config: The TranslatorConfig object.
 // - filePath: The path to the local file to be uploaded.
-// - blobName: The name of the blob in Azure Blob Storage.
+// - blobName: The name of the blob in the storage.
 // It returns an error if any.
-func uploadFileToBlobStorage(config TranslatorConfig, filePath, blobName string) error {
+func uploadFileToBlobStorage(ctx context.Context, config TranslatorConfig, filePath, blobName string) error {
 	config.Logger.Debugf("Uploading file %s to blob storage as %s", filePath, blobName)
-	accountName := config.BlobAccountName
-	accountKey := config.BlobAccountKey
-	containerName := config.BlobContainerName
-	// Create a default request pipeline using your storage account name and account key.
-	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	storage, err := config.storage()
 	if err != nil {
 		return err
 	}
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-
-	// Create a URL to the blob storage container.
-	URL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, containerName))
 
-	// Create a container URL object.
-	containerURL := azblob.NewContainerURL(*URL, p)
-
-	// Open the local file.
-	file, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Get the file size.
-	fileInfo, _ := file.Stat()
-	fileSize := fileInfo.Size()
-
-	// Create a blob URL object.
-	blobURL := containerURL.NewBlockBlobURL(blobName)
-
-	// Create a context with cancellation.
-	ctx := context.Background()
-
-	// Create a buffer to read the file content.
-	buffer := make([]byte, fileSize)
-	_, err = file.Read(buffer)
-	if err != nil {
-		return err
-	}
+	err = retry(ctx, config, "Upload of "+blobName, func() error {
+		// Open the local file, from its start on each attempt.
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
 
-	// Upload the file to Azure Blob Storage.
-	_, err = azblob.UploadBufferToBlockBlob(ctx, buffer, blobURL, azblob.UploadToBlockBlobOptions{})
+		// Upload the file to the storage, reporting the bytes read.
+		return storage.Put(ctx, blobName, &progressReader{
+			r:      file,
+			event:  ProgressEvent{Kind: ProgressUpload, Name: filePath, Total: info.Size()},
+			report: config.progress,
+		})
+	})
 	if err != nil {
 		return err
 	}
@@ -116,156 +171,155 @@ func uploadFileToBlobStorage(config TranslatorConfig, filePath, blobName string)
 	return nil
 }
 
-// deleteFileFromBlobStorage deletes a file from Azure Blob Storage.
+// deleteFileFromBlobStorage deletes a file from the staging storage.
+// The deletion is retried according to the retry policy of the configuration.
 // It takes the following parameters:
+// - ctx: The context of the operation.
 // - config: The TranslatorConfig object.
-// - blobName: The name of the blob in Azure Blob Storage.
+// - blobName: The name of the blob in the storage.
 // It returns an error if any.
-func deleteFileFromBlobStorage(config TranslatorConfig, blobName string) error {
-	accountName := config.BlobAccountName
-	accountKey := config.BlobAccountKey
-	containerName := config.BlobContainerName
-	// Create a default request pipeline using your storage account name and account key.
-	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
-		return err
-	}
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-
-	// Create a URL to the blob storage container.
-	URL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, containerName))
-
-	// Create a container URL object.
-	containerURL := azblob.NewContainerURL(*URL, p)
-
-	// Create a blob URL object.
-	blobURL := containerURL.NewBlockBlobURL(blobName)
-
-	// Create a context with cancellation.
-	ctx := context.Background()
-
-	// Delete the file from Azure Blob Storage.
-	_, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+func deleteFileFromBlobStorage(ctx context.Context, config TranslatorConfig, blobName string) error {
+	storage, err := config.storage()
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return retry(ctx, config, "Deletion of "+blobName, func() error {
+		return storage.Delete(ctx, blobName)
+	})
 }
 
-// dowloadFileFromBlobStorage downloads a file from Azure Blob Storage.
+// dowloadFileFromBlobStorage downloads a file from the staging storage.
+// The download is retried according to the retry policy of the configuration.
 // It takes the following parameters:
+// - ctx: The context of the operation.
 // - config: The TranslatorConfig object.
 // - destFilePath: The path to save the downloaded file.
-// - blobName: The name of the blob in Azure Blob Storage.
+// - blobName: The name of the blob in the storage.
 // It returns an error if any.
-func downloadFileFromBlobStorage(config TranslatorConfig, destFilePath, blobName string) error {
-	accountName := config.BlobAccountName
-	accountKey := config.BlobAccountKey
-	containerName := config.BlobContainerName
-	// Create a default request pipeline using your storage account name and account key.
-	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+func downloadFileFromBlobStorage(ctx context.Context, config TranslatorConfig, destFilePath, blobName string) error {
+	storage, err := config.storage()
 	if err != nil {
 		return err
 	}
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-
-	// Create a URL to the blob storage container.
-	URL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, containerName))
-
-	// Create a container URL object.
-	containerURL := azblob.NewContainerURL(*URL, p)
-
-	// Create a blob URL object.
-	blobURL := containerURL.NewBlobURL(blobName)
-
-	// Create a context with cancellation.
-	ctx := context.Background()
 
 	err = os.MkdirAll(filepath.Dir(destFilePath), 0755)
 	if err != nil {
 		return err
 	}
 
-	file, err := os.Create(destFilePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	return retry(ctx, config, "Download of "+blobName, func() error {
+		// Create or truncate the file on each attempt.
+		file, err := os.Create(destFilePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		// Download the file from the storage, in parallel if the storage supports it.
+		event := ProgressEvent{Kind: ProgressDownload, Name: destFilePath}
+		if getter, ok := storage.(FileGetter); ok {
+			return getter.GetFile(ctx, blobName, file, func(bytes, total int64) {
+				event.Bytes, event.Total = bytes, total
+				config.progress(event)
+			})
+		}
+		writer := &progressWriter{w: file, event: event, report: config.progress}
+		if err := storage.Get(ctx, blobName, writer); err != nil {
+			return err
+		}
+		// The size is known once the download is complete.
+		event.Bytes, event.Total = writer.bytes, writer.bytes
+		config.progress(event)
+		return nil
+	})
+}
 
-	// Download the file from Azure Blob Storage.
-	err = azblob.DownloadBlobToFile(ctx, blobURL, 0, 0, file, azblob.DownloadFromBlobOptions{})
+// listBlobsFromBlobStorage lists the blobs whose name starts with prefix in the staging storage.
+// The listing is retried according to the retry policy of the configuration.
+// It takes the following parameters:
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object.
+// - prefix: The blob name prefix, use "" to list the whole container.
+// It returns the names of the blobs and an error if any.
+func listBlobsFromBlobStorage(ctx context.Context, config TranslatorConfig, prefix string) ([]string, error) {
+	storage, err := config.storage()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return nil
+	var names []string
+	err = retry(ctx, config, "Listing of "+prefix, func() error {
+		names, err = storage.List(ctx, prefix)
+		return err
+	})
+	return names, err
 }
 
-// getBlobURLWithSASToken generates a Shared Access Signature (SAS) token for a blob in Azure Blob Storage.
+// Permissions of the signed URLs given to the Translator service, the least privilege each access needs.
+var (
+	// sourcePermissions let the service read a source document or a glossary.
+	sourcePermissions = Permissions{Read: true}
+	// targetPermissions let the service write a translated document.
+	targetPermissions = Permissions{Write: true}
+	// sourceFolderPermissions let the service list and read the documents of a source folder.
+	sourceFolderPermissions = Permissions{Read: true, List: true}
+	// targetFolderPermissions let the service write the translated documents of a folder.
+	targetFolderPermissions = Permissions{Write: true, List: true}
+)
+
+// getBlobURLWithSASToken generates a signed URL for a blob in the staging storage.
 // It takes the following parameters:
-// - config: The TranslatorConfig object.
-// - blobName: The name of the blob in Azure Blob Storage (use "" for container SAS).
-// It returns the generated URL with the SAS query parameter as a string, the SAS token and an error if any.
-func getBlobURLWithSASToken(config TranslatorConfig, blobName string) (string, string, error) {
-	accountName := config.BlobAccountName
-	accountKey := config.BlobAccountKey
-	containerName := config.BlobContainerName
-	// Create a default request pipeline using your storage account name and account key.
-	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+// - ctx: The context of the operation.
+// - config: The TranslatorConfig object, whose SAS options set the lifetime and the IP range of the URL.
+// - blobName: The name of the blob in the storage (use "" for container SAS, or a name ending with "/" for a folder).
+// - permissions: The operations granted by the URL.
+// It returns the generated URL with the SAS query parameter as a string and an error if any,
+// a *BlobError if the storage could not sign the URL, e.g. because a user delegation key could not be obtained.
+func getBlobURLWithSASToken(ctx context.Context, config TranslatorConfig, blobName string, permissions Permissions) (string, error) {
+	storage, err := config.storage()
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
-	path := ""
-	permissions := ""
-	if blobName != "" {
-		path = fmt.Sprintf("/%s", blobName)
-		permissions = azblob.BlobSASPermissions{Add: true, Read: true, Write: true, List: true}.String()
-
-	} else {
-		permissions = azblob.ContainerSASPermissions{Read: true, Write: true}.String()
-		path = ""
-	}
-	// Get a SAS token for the blob.
-	sasQueryParams, err := azblob.BlobSASSignatureValues{
-		Protocol:      azblob.SASProtocolHTTPS,              // Users MUST use HTTPS (not HTTP)
-		ExpiryTime:    time.Now().UTC().Add(48 * time.Hour), // 48-hours before expiration
-		ContainerName: containerName,
-		BlobName:      blobName,
-
-		// To produce a container SAS (as opposed to a blob SAS), assign to Permissions using
-		// ContainerSASPermissions and make sure the BlobName field is "" (the default).
-		Permissions: permissions,
-	}.NewSASQueryParameters(credential)
+	signedURL, err := storage.SignedURL(ctx, blobName, permissions, config.sasExpiry())
 	if err != nil {
-		return "", "", err
+		return "", &BlobError{Op: "sign", Blob: blobName, Err: err}
 	}
-	qp := sasQueryParams.Encode()
-	urlToSendToSomeone := fmt.Sprintf("https://%s.blob.core.windows.net/%s%s?%s",
-		accountName, containerName, path, qp)
-	return urlToSendToSomeone, qp, nil
+	return signedURL, nil
 }
 
 // generateJSONDocument generates a JSON document for translation.
-// It takes the source SAS URL, target SAS URL, source language, and target language as input parameters.
+// It takes the source SAS URL, source language, and the targets (target SAS URL and language) as input parameters.
 // It returns the generated JSON document as a string and an error if any.
-func generateJSONDocument(sourceSASUrl string, targetSASUrl string, sourceLanguage string, targetLanguage string) (string, error) {
-	doc := ATranslateDocument{
-		Inputs: []Input{
-			{
-				StorageType: "File",
-				Source: Source{
-					SourceURL: sourceSASUrl,
-					Language:  sourceLanguage,
-				},
-				Targets: []Target{
-					{
-						TargetURL: targetSASUrl,
-						Language:  targetLanguage,
-					},
-				},
-			},
+func generateJSONDocument(sourceSASUrl string, sourceLanguage string, targets []Target) (string, error) {
+	return marshalJSONDocument(Input{
+		StorageType: "File",
+		Source: Source{
+			SourceURL: sourceSASUrl,
+			Language:  sourceLanguage,
 		},
+		Targets: targets,
+	})
+}
+
+// generateFolderJSONDocument generates a JSON document for the translation of a folder.
+// It takes the source container SAS URL, the filter selecting the source documents, the source language,
+// and the targets (target folder SAS URL and language) as input parameters.
+// It returns the generated JSON document as a string and an error if any.
+func generateFolderJSONDocument(sourceSASUrl string, filter *Filter, sourceLanguage string, targets []Target) (string, error) {
+	return marshalJSONDocument(Input{
+		StorageType: "Folder",
+		Source: Source{
+			SourceURL: sourceSASUrl,
+			Filter:    filter,
+			Language:  sourceLanguage,
+		},
+		Targets: targets,
+	})
+}
+
+// marshalJSONDocument marshals a translation request with a single input.
+func marshalJSONDocument(input Input) (string, error) {
+	doc := ATranslateDocument{
+		Inputs: []Input{input},
 	}
 
 	jsonBytes, err := json.MarshalIndent(doc, "", "  ")
@@ -286,6 +340,34 @@ func generateUUIDv4WithoutHyphens() string {
 	return uuidWithoutHyphens
 }
 
+// OutputPath builds the destination path of a translation from a template.
+// The template may contain the following placeholders:
+// - {name}: The base name of the source file without its extension.
+// - {ext}: The extension of the source file, including the leading dot.
+// - {lang}: The target language.
+// A template without placeholders is returned unchanged.
+func OutputPath(template, sourceFile, language string) string {
+	ext := filepath.Ext(sourceFile)
+	name := strings.TrimSuffix(filepath.Base(sourceFile), ext)
+	return strings.NewReplacer("{name}", name, "{ext}", ext, "{lang}", language).Replace(template)
+}
+
+// ParseLanguages splits a comma-separated list of languages, ignoring blank entries.
+// A language given several times, whatever its case, is only kept once, so that it is not translated twice
+// to the same output file.
+func ParseLanguages(list string) []string {
+	languages := []string{}
+	seen := map[string]bool{}
+	for _, language := range strings.Split(list, ",") {
+		language = strings.TrimSpace(language)
+		if language != "" && !seen[strings.ToLower(language)] {
+			seen[strings.ToLower(language)] = true
+			languages = append(languages, language)
+		}
+	}
+	return languages
+}
+
 // TranslateDocument translates a document from one language to another using the Azure Translator service.
 // It takes the following parameters:
 // - fileToTranslate: The path to the local file to be translated.
@@ -294,110 +376,370 @@ func generateUUIDv4WithoutHyphens() string {
 // - targetLanguage: The language to translate the document to.
 // - config: The TranslatorConfig object.
 // It returns an error if any.
-// The process of translation involves the following steps:
+// It is a shorthand for TranslateDocumentContext with a background context.
+func TranslateDocument(fileToTranslate, destinationFile, sourceLanguage, targetLanguage string, config TranslatorConfig) error {
+	return TranslateDocumentContext(context.Background(), fileToTranslate, destinationFile, sourceLanguage, targetLanguage, config)
+}
+
+// TranslateDocumentContext is like TranslateDocument with a context controlling the translation.
+// It is a shorthand for TranslateDocumentToLanguagesContext with a single target language.
+func TranslateDocumentContext(ctx context.Context, fileToTranslate, destinationFile, sourceLanguage, targetLanguage string, config TranslatorConfig) error {
+	return TranslateDocumentToLanguagesContext(ctx, fileToTranslate, destinationFile, sourceLanguage, []string{targetLanguage}, config)
+}
+
+// TranslateDocumentToLanguages translates a document to several languages in a single translation job.
+// It is a shorthand for TranslateDocumentToLanguagesContext with a background context.
+func TranslateDocumentToLanguages(fileToTranslate, destinationTemplate, sourceLanguage string, targetLanguages []string, config TranslatorConfig) error {
+	return TranslateDocumentToLanguagesContext(context.Background(), fileToTranslate, destinationTemplate, sourceLanguage, targetLanguages, config)
+}
+
+// TranslateDocumentToLanguagesContext translates a document to several languages in a single translation job.
+// It takes the following parameters:
+// - ctx: The context of the translation, cancelling it cancels the translation job and deletes the temporary blobs.
+// - fileToTranslate: The path to the local file to be translated.
+// - destinationTemplate: The template of the translated files paths, see OutputPath. It must contain {lang} when several target languages are given.
+// - sourceLanguage: The language of the source document if the provided string has zero length, the service will attempt to auto-detect the language.
+// - targetLanguages: The languages to translate the document to.
+// - config: The TranslatorConfig object.
+// It returns an error if any.
+// If UseSyncTranslation reports true, the document is translated with the synchronous endpoint instead, once per target language.
+// Otherwise the process of translation involves the following steps:
 // 1. Generate a UUID for the translation job.
-// 2. Upload the file to Azure Blob Storage.
-// 3. Generate a JSON document for translation.
+// 2. Upload the file and the glossary, if any, to the staging storage.
+// 3. Generate a JSON document for translation with one target per language.
 // 4. Translate the document.
-// 5. Delete the file from Azure Blob Storage.
-// 6. wait for the translated document to be ready in the target container.
-func TranslateDocument(fileToTranslate, destinationFile, sourceLanguage, targetLanguage string, config TranslatorConfig) error {
-	// populate endpoint, key, region, blobAccountName, blobContainerName, timeout and verbose with the values from the config object
-	endpoint := config.TranslatorEndpoint
-	key := config.TranslatorKey
-	region := config.TranslatorRegion
-	blobAccountName := config.BlobAccountName
-	blobContainerName := config.BlobContainerName
+// 5. Poll the job status until the translation completes and download the translated documents.
+// 6. Delete the files from the staging storage, on every exit path unless KeepBlobs is set.
+func TranslateDocumentToLanguagesContext(ctx context.Context, fileToTranslate, destinationTemplate, sourceLanguage string, targetLanguages []string, config TranslatorConfig) (err error) {
+	if len(targetLanguages) == 0 {
+		return fmt.Errorf("no target language")
+	}
+	if len(targetLanguages) > 1 && !strings.Contains(destinationTemplate, "{lang}") {
+		return fmt.Errorf("destination %q must contain {lang} when translating to several languages", destinationTemplate)
+	}
+
+	if config.GlossaryFile != "" {
+		if _, err := GlossaryFormat(config.GlossaryFile, config.GlossaryFormat); err != nil {
+			return err
+		}
+	}
+
+	// Create the credential and the storage once for all the operations of the job.
+	config, err = config.withSharedClients()
+	if err != nil {
+		return err
+	}
+
+	// Small documents are translated synchronously, one request per target language.
+	if UseSyncTranslation(config, fileToTranslate) {
+		for _, language := range targetLanguages {
+			err := translateDocumentSync(ctx, config, fileToTranslate, OutputPath(destinationTemplate, fileToTranslate, language), sourceLanguage, language)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
 	// Generate a UUID for the translation job.
 	jobID := generateUUIDv4WithoutHyphens()
 	config.Logger.Debugf("Starting translation job %s", jobID)
 	filename := filepath.Base(fileToTranslate)
 	srcJobID := fmt.Sprintf("%s-%s", jobID, filename)
-	dstJobID := fmt.Sprintf("%s-translated-%s", jobID, filename)
-	// Upload the file to Azure Blob Storage.
-	err := uploadFileToBlobStorage(config, fileToTranslate, srcJobID)
+	targets := make([]JobTarget, 0, len(targetLanguages))
+	for _, language := range targetLanguages {
+		targets = append(targets, JobTarget{
+			Language:        language,
+			Blob:            fmt.Sprintf("%s-translated-%s-%s", jobID, language, filename),
+			DestinationFile: OutputPath(destinationTemplate, fileToTranslate, language),
+		})
+	}
+	now := time.Now().UTC()
+	record := &JobRecord{ID: jobID, Source: fileToTranslate, SourceBlob: srcJobID, SourceLanguage: sourceLanguage, Targets: targets, State: JobUploading, Created: now}
+	config.saveJob(record)
+
+	// Delete the blobs of the job on every exit path, even if the context was cancelled or a panic occurred.
+	defer config.endJob(ctx, record, &err)
+
+	// Upload the file and the glossary to Azure Blob Storage.
+	err = uploadFileToBlobStorage(ctx, config, fileToTranslate, srcJobID)
 	if err != nil {
-		return fmt.Errorf("error uploading file to Azure Blob Storage: %v", err)
+		return &BlobError{Op: "upload", Blob: srcJobID, Err: err}
+	}
+	glossaries, err := uploadGlossary(ctx, config, jobID)
+	if err != nil {
+		return err
 	}
 
 	// Generate a JSON document for translation.
-	containerSASurl, containerSASToken, err := getBlobURLWithSASToken(config, "")
+	sourceSASUrl, err := getBlobURLWithSASToken(ctx, config, srcJobID, sourcePermissions)
 	if err != nil {
-		return fmt.Errorf("error generating container SAS token: %v", err)
+		return fmt.Errorf("error generating source SAS URL: %w", err)
 	}
-	config.Logger.Debugf("containerSASurl: %s", containerSASurl)
-
-	sourceSASUrl := fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s?%s", blobAccountName, blobContainerName, srcJobID, containerSASToken)
 
 	config.Logger.Debugf("sourceSASUrl: %s", sourceSASUrl)
 
-	targetSASUrl := fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s?%s", blobAccountName, blobContainerName, dstJobID, containerSASToken)
-
-	config.Logger.Debugf("targetSASUrl: %s", targetSASUrl)
+	documentTargets := make([]Target, 0, len(targets))
+	for _, target := range targets {
+		targetSASUrl, err := getBlobURLWithSASToken(ctx, config, target.Blob, targetPermissions)
+		if err != nil {
+			return fmt.Errorf("error generating target SAS URL: %w", err)
+		}
+		config.Logger.Debugf("targetSASUrl (%s): %s", target.Language, targetSASUrl)
+		documentTargets = append(documentTargets, Target{TargetURL: targetSASUrl, Language: target.Language, Category: config.Category(target.Language), Glossaries: glossaries})
+	}
 
-	jsonDocument, err := generateJSONDocument(sourceSASUrl, targetSASUrl, sourceLanguage, targetLanguage)
+	jsonDocument, err := generateJSONDocument(sourceSASUrl, sourceLanguage, documentTargets)
 	if err != nil {
 		return fmt.Errorf("error generating JSON document: %v", err)
 	}
 
-	// Translate the document.
-	basePath := fmt.Sprintf("%s/translator/document/batches", endpoint)
-	uri := fmt.Sprintf("%s?api-version=%s", basePath, APIVersion)
-	method := "POST"
-	req, err := http.NewRequest(method, uri, bytes.NewBuffer([]byte(jsonDocument)))
-	req.Header.Add("Ocp-Apim-Subscription-Key", key)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Ocp-Apim-Subscription-Region", region)
-	client := &http.Client{}
+	// Translate the document and wait for the translated documents.
+	operationURL, err := submitTranslation(ctx, config, jsonDocument)
 	if err != nil {
+		return err
+	}
+	record.State = JobSubmitted
+	record.OperationURL = operationURL
+	record.BatchID = batchIDFromOperationURL(operationURL)
+	config.saveJob(record)
+	if err := waitForTranslatedFile(ctx, config, operationURL, targets); err != nil {
+		return err
+	}
+	config.Logger.Debugf("Translation job %s completed successfully", jobID)
+	return nil
+}
+
+// cleanupContext returns a context for the cleanup of a translation.
+// It keeps the values of ctx but is not cancelled with it, so that the temporary blobs are deleted
+// even when the translation is cancelled. The cleanup is bounded by cleanupTimeout.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+}
 
-		return fmt.Errorf("error creating HTTP request: %v", err)
+// endJob is deferred by the translations to end their job on every exit path.
+// It ends the job with finishJob and the error the translation returns in *err, which it replaces with the result.
+// A panic of the translation ends the job as failed, then is propagated.
+func (config TranslatorConfig) endJob(ctx context.Context, record *JobRecord, err *error) {
+	if p := recover(); p != nil {
+		_ = config.finishJob(ctx, record, fmt.Errorf("panic: %v", p))
+		panic(p)
 	}
-	res, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error sending HTTP request: %v", err)
+	*err = config.finishJob(ctx, record, *err)
+}
+
+// finishJob ends a translation job: it deletes every temporary blob of the job, even if ctx was cancelled,
+// then records the end of the job in the job store. The blobs are kept if KeepBlobs is set.
+// It returns translationErr if the translation failed, or else the errors of the deletions.
+// If some blobs cannot be deleted after a failed translation, the deletion errors are logged;
+// in both cases the job stays in the JobCleanup state, so that ResumeJob retries the cleanup.
+// A job whose outcome was not collected, because it could not be cancelled, see abandonBatch, or its status
+// could not be retrieved, keeps its blobs and stays in the JobSubmitted state, so that ResumeJob polls it again.
+// Without a job store nothing can resume such a job, so its blobs are deleted like those of a failed job.
+func (config TranslatorConfig) finishJob(ctx context.Context, record *JobRecord, translationErr error) error {
+	var running *runningJobError
+	if errors.As(translationErr, &running) {
+		if config.Jobs != nil {
+			config.Logger.Warnf("The outcome of translation job %s is unknown, its temporary blobs are kept until it is resumed", record.ID)
+			return running.err
+		}
+		config.Logger.Warnf("The outcome of translation job %s is unknown and it cannot be resumed without a job store, its temporary blobs are deleted, gc deletes those it may still write", record.ID)
+		translationErr = running.err
+	}
+	record.State = JobCleanup
+	if translationErr != nil {
+		record.Error = translationErr.Error()
+		record.ErrorKind = errorKind(translationErr)
+	}
+	config.saveJob(record)
 
+	if config.KeepBlobs {
+		config.Logger.Infof("Keeping the temporary blobs of translation job %s", record.ID)
+	} else {
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+		if err := deleteJobBlobs(cleanupCtx, config, record); err != nil {
+			if translationErr == nil {
+				return err
+			}
+			config.Logger.Warnf("Temporary blobs of translation job %s could not be deleted: %v", record.ID, err)
+			return translationErr
+		}
+	}
+
+	record.State = JobCompleted
+	if record.Error != "" {
+		record.State = JobFailed
+	}
+	config.saveJob(record)
+	return translationErr
+}
+
+// translatorURL builds the URL of a Translator service path.
+// It takes the TranslatorConfig, the path and the query parameters, api-version is added to them.
+// The endpoint may end with a slash and may contain a base path, e.g. for a private endpoint behind a gateway.
+func translatorURL(config TranslatorConfig, path string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", APIVersion)
+	return fmt.Sprintf("%s%s?%s", strings.TrimSuffix(config.TranslatorEndpoint, "/"), path, query.Encode())
+}
+
+// submitTranslation submits a translation job to the Translator service.
+// It takes the TranslatorConfig and the JSON document describing the job as parameters.
+// It returns the job URL from the Operation-Location header of the response,
+// a *SubmissionError if the service rejected the job or an error if the request could not be sent.
+// A submission throttled or failing with a 5xx status is retried according to the retry policy of the configuration.
+func submitTranslation(ctx context.Context, config TranslatorConfig, jsonDocument string) (string, error) {
+	uri := translatorURL(config, "/translator/document/batches", nil)
+	res, err := doRequest(ctx, config, func() (*http.Request, error) {
+		req, err := newTranslatorRequest(ctx, config, http.MethodPost, uri, strings.NewReader(jsonDocument))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return "", err
 	}
 	defer res.Body.Close()
 
 	config.Logger.Debugf("response status: %s", res.Status)
 	config.Logger.Debugf("response headers: %v", res.Header)
 
-	if res.StatusCode >= 200 && res.StatusCode < 300 {
-		// Wait for the translated document to be ready in the target container.
-		_ = waitForTranslatedFile(config, destinationFile, dstJobID)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", newSubmissionError(res)
+	}
+	operationURL := res.Header.Get("Operation-Location")
+	if operationURL == "" {
+		return "", fmt.Errorf("missing Operation-Location header in the batch submission response")
 	}
+	config.Logger.Debugf("operation location: %s", operationURL)
+	config.progress(ProgressEvent{Kind: ProgressSubmitted, JobID: batchIDFromOperationURL(operationURL)})
+	return operationURL, nil
+}
 
-	// Delete the file from Azure Blob Storage.
-	err = deleteFileFromBlobStorage(config, srcJobID)
-	if err != nil {
-		return fmt.Errorf("error deleting source document: %v", err)
+// waitForBatch polls the status of the translation job at operationURL once per second until it reaches a terminal status.
+// The timeout of the configuration is measured from the first poll, including the time of the status requests.
+// If ctx is cancelled, even during a status request, or the timeout expires while the job is running,
+// the job is cancelled on the service, see abandonBatch.
+// It returns the final job status, a *TimeoutError if the job did not complete within the timeout period,
+// or an error wrapping the context error if ctx was cancelled.
+// An error getting the status is wrapped in a *runningJobError, since the job keeps running on the service.
+func waitForBatch(ctx context.Context, config TranslatorConfig, operationURL string) (*BatchStatus, error) {
+	batchID := batchIDFromOperationURL(operationURL)
+	timeout := time.Duration(config.Timeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	var last BatchStatus
+	for {
+		status, err := getBatchStatus(ctx, config, operationURL)
+		if err != nil && ctx.Err() != nil {
+			return nil, abandonBatch(ctx, config, operationURL, fmt.Errorf("translation job %s interrupted: %w", batchID, ctx.Err()))
+		}
+		if err != nil {
+			return nil, &runningJobError{err: err}
+		}
+		if status.Status != last.Status {
+			config.Logger.Infof("Translation job %s is %s", batchID, status.Status)
+		}
+		config.Logger.Debugf("Translation job %s summary: %+v", batchID, status.Summary)
+		if status.Status != last.Status || status.Summary != last.Summary {
+			config.progress(ProgressEvent{Kind: ProgressStatus, JobID: batchID, Status: status.Status, Summary: status.Summary})
+		}
+		if status.Summary.TotalCharacterCharged != last.Summary.TotalCharacterCharged {
+			config.progress(ProgressEvent{Kind: ProgressCharged, JobID: batchID, Characters: status.Summary.TotalCharacterCharged})
+		}
+		last = *status
+
+		if status.Status.IsTerminal() {
+			return status, nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, abandonBatch(ctx, config, operationURL, &TimeoutError{JobID: batchID, Timeout: timeout})
+		}
+		config.Logger.Debugln("Translation not yet complete, wait for 1s…")
+		select {
+		case <-ctx.Done():
+			return nil, abandonBatch(ctx, config, operationURL, fmt.Errorf("translation job %s interrupted: %w", batchID, ctx.Err()))
+		case <-time.After(min(time.Second, remaining)):
+		}
+	}
+}
+
+// abandonBatch cancels the translation job at operationURL, which is abandoned before completing because of err,
+// even if ctx was cancelled.
+// It returns err, wrapped in a *runningJobError if the job could not be cancelled: the job may still be running
+// and writing to its blobs, so they must be kept until it is resumed.
+func abandonBatch(ctx context.Context, config TranslatorConfig, operationURL string, err error) error {
+	batchID := batchIDFromOperationURL(operationURL)
+	config.Logger.Infof("Cancelling translation job %s", batchID)
+	cancelCtx, cancel := cleanupContext(ctx)
+	defer cancel()
+	if cancelErr := cancelTranslation(cancelCtx, config, operationURL); cancelErr != nil {
+		config.Logger.Warnf("Translation job %s could not be cancelled: %v", batchID, cancelErr)
+		return &runningJobError{err: err}
 	}
-	err = deleteFileFromBlobStorage(config, dstJobID)
+	return err
+}
+
+// waitForTranslatedFile waits for the translation job to complete and downloads the translated files.
+// When the job succeeds, the translated document of each target is downloaded to its destination file.
+// It returns a *JobError or a *DocumentError carrying the service error code if the job or a document failed,
+// a *TimeoutError if the job did not complete within the timeout period and a *BlobError if a download failed.
+func waitForTranslatedFile(ctx context.Context, config TranslatorConfig, operationURL string, targets []JobTarget) error {
+	status, err := waitForBatch(ctx, config, operationURL)
 	if err != nil {
-		return fmt.Errorf("error deleting translated document: %v", err)
+		return err
 	}
-	config.Logger.Debugf("Translation job %s completed successfully", jobID)
-	return nil
+	return finishTranslation(ctx, config, operationURL, status, targets)
 }
 
-// waitForTranslatedFile waits for the translated file to be available in the destination storage.
-// It continuously checks for the availability of the file by downloading it from the blob storage.
-// The function takes the TranslatorConfig, destinationFile path, and dstJobID as parameters.
-// It returns an error if the file is not available within the specified timeout period.
-func waitForTranslatedFile(config TranslatorConfig, destinationFile string, dstJobID string) error {
-	maxTry := config.Timeout
-	for {
-		err := downloadFileFromBlobStorage(config, destinationFile, dstJobID)
-		if err == nil {
-			return nil
+// finishTranslation handles a translation job that reached a terminal status.
+// It reports the status of each document and downloads the documents that were translated successfully.
+// The languages of the documents are matched with those of the targets regardless of their case.
+// It returns the job error, or else the first document or download error, or an error if a target has no translated document.
+// An error getting the documents status is wrapped in a *runningJobError, so that the job can be resumed.
+func finishTranslation(ctx context.Context, config TranslatorConfig, operationURL string, status *BatchStatus, targets []JobTarget) error {
+	documents, err := getDocumentsStatus(ctx, config, operationURL)
+	if err != nil {
+		return &runningJobError{err: err}
+	}
+	succeeded := map[string]bool{}
+	var documentErr *DocumentError
+	for _, document := range documents {
+		config.Logger.Debugf("Document %s (%s): %s", document.ID, document.To, document.Status)
+		if document.Status == StatusSucceeded {
+			succeeded[strings.ToLower(document.To)] = true
+		} else if documentErr == nil {
+			documentErr = &DocumentError{JobID: status.ID, DocumentID: document.ID, Language: document.To, Status: document.Status, Err: document.Error}
+		}
+	}
+
+	if status.Status != StatusSucceeded {
+		if status.Error == nil && documentErr != nil {
+			return documentErr
 		}
-		if maxTry <= 0 {
-			return fmt.Errorf("timeout waiting for the translated document")
+		return &JobError{JobID: status.ID, Status: status.Status, Err: status.Error}
+	}
+
+	var missingErr error
+	for _, target := range targets {
+		if !succeeded[strings.ToLower(target.Language)] {
+			if missingErr == nil {
+				missingErr = fmt.Errorf("translation job %s returned no translated document in %s", status.ID, target.Language)
+			}
+			continue
 		}
-		config.Logger.Infoln("File not yet ready, wait for 1s…")
-		time.Sleep(1 * time.Second)
-		maxTry--
+		if err := downloadFileFromBlobStorage(ctx, config, target.DestinationFile, target.Blob); err != nil {
+			return &BlobError{Op: "download", Blob: target.Blob, Err: err}
+		}
+		config.Logger.Infof("Translated document (%s) saved to %s", target.Language, target.DestinationFile)
+	}
+	if documentErr != nil {
+		return documentErr
 	}
+	return missingErr
 }
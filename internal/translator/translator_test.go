@@ -12,10 +12,13 @@ This file contains test functions for the various operations provided by the tra
 package translator
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -26,8 +29,25 @@ const (
 	BLOB_NAME = "TestREADME.md"
 )
 
+// blobEnv are the environment variables of the Azure Blob Storage account used by the tests against Azure.
+var blobEnv = []string{"BLOB_STORAGE_ACCOUNT_NAME", "BLOB_STORAGE_ACCOUNT_KEY", "BLOB_STORAGE_CONTAINER_NAME"}
+
+// translatorEnv are the environment variables of the Translator resource used by the tests against Azure.
+var translatorEnv = []string{"TRANSLATOR_ENDPOINT", "TRANSLATOR_KEY", "TRANSLATOR_REGION"}
+
+// skipWithoutAzure skips a test against Azure when one of the environment variables of its resources is not set.
+func skipWithoutAzure(t *testing.T, variables ...string) {
+	t.Helper()
+	for _, variable := range variables {
+		if os.Getenv(variable) == "" {
+			t.Skipf("%s is not set, skipping the test against Azure", variable)
+		}
+	}
+}
+
 // TestUploadFileToBlobStorage is a test function that tests the uploadFileToBlobStorage function.
 func TestUploadFileToBlobStorage(t *testing.T) {
+	skipWithoutAzure(t, blobEnv...)
 	log := logrus.New()
 	log.SetOutput(os.Stdout)
 	// Test configuration
@@ -54,7 +74,7 @@ func TestUploadFileToBlobStorage(t *testing.T) {
 	}
 
 	// Upload file to blob storage
-	err = uploadFileToBlobStorage(config, absFilePath, blobName)
+	err = uploadFileToBlobStorage(context.Background(), config, absFilePath, blobName)
 	if err != nil {
 		t.Errorf("UploadFileToBlobStorage failed: %v", err)
 	}
@@ -64,6 +84,7 @@ func TestUploadFileToBlobStorage(t *testing.T) {
 
 // TestGetBlobURLWithSASToken is a test function that tests the getBlobURLWithSASToken function.
 func TestGetBlobURLWithSASToken(t *testing.T) {
+	skipWithoutAzure(t, blobEnv...)
 	log := logrus.New()
 	log.SetOutput(os.Stdout)
 	// Test configuration
@@ -83,7 +104,7 @@ func TestGetBlobURLWithSASToken(t *testing.T) {
 	blobName := BLOB_NAME
 
 	// Get blob URL with SAS token
-	urlWithSASToken, _, err := getBlobURLWithSASToken(config, blobName)
+	urlWithSASToken, err := getBlobURLWithSASToken(context.Background(), config, blobName, sourcePermissions)
 	fmt.Println(urlWithSASToken)
 	if err != nil {
 		t.Errorf("getBlobURLWithSASToken failed: %v", err)
@@ -100,6 +121,7 @@ func TestGetBlobURLWithSASToken(t *testing.T) {
 
 // TestDeleteFileFromBlobStorage is a test function that tests the deleteFileFromBlobStorage function.
 func TestDeleteFileFromBlobStorage(t *testing.T) {
+	skipWithoutAzure(t, blobEnv...)
 	log := logrus.New()
 	log.SetOutput(os.Stdout)
 	// Test configuration
@@ -119,7 +141,7 @@ func TestDeleteFileFromBlobStorage(t *testing.T) {
 	blobName := BLOB_NAME
 
 	// Delete file from blob storage
-	err := deleteFileFromBlobStorage(config, blobName)
+	err := deleteFileFromBlobStorage(context.Background(), config, blobName)
 	if err != nil {
 		t.Errorf("DeleteFileFromBlobStorage failed: %v", err)
 	}
@@ -155,7 +177,7 @@ func TestGenerateJSONDocument(t *testing.T) {
 }`
 
 	// Generate JSON document
-	jsonDoc, err := generateJSONDocument(sourceSASUrl, targetSASUrl, sourceLanguage, targetLanguage)
+	jsonDoc, err := generateJSONDocument(sourceSASUrl, sourceLanguage, []Target{{TargetURL: targetSASUrl, Language: targetLanguage}})
 	if err != nil {
 		t.Errorf("generateJSONDocument failed: %v", err)
 	}
@@ -168,8 +190,70 @@ func TestGenerateJSONDocument(t *testing.T) {
 	// Add additional assertions or verifications here
 }
 
+// TestGenerateJSONDocumentWithCategory is a test function that checks that the category of a target is passed through.
+func TestGenerateJSONDocumentWithCategory(t *testing.T) {
+	config := TranslatorConfig{Categories: map[string]string{"fr": "abc123"}}
+	jsonDoc, err := generateJSONDocument("https://example.com/source", "en", []Target{
+		{TargetURL: "https://example.com/target-fr", Language: "fr", Category: config.Category("fr")},
+		{TargetURL: "https://example.com/target-de", Language: "de", Category: config.Category("de")},
+	})
+	if err != nil {
+		t.Fatalf("generateJSONDocument failed: %v", err)
+	}
+	if !strings.Contains(jsonDoc, `"category": "abc123"`) || strings.Count(jsonDoc, `"category"`) != 1 {
+		t.Errorf("generateJSONDocument JSON does not contain only the fr category:\n%s", jsonDoc)
+	}
+}
+
+// TestCategoryCase is a test function that checks that the categories are found whatever the case of their language.
+func TestCategoryCase(t *testing.T) {
+	config := TranslatorConfig{Categories: map[string]string{"zh-hans": "abc123", "PT-br": "def456"}}
+	for language, expected := range map[string]string{"zh-Hans": "abc123", "ZH-HANS": "abc123", "pt-BR": "def456", "pt": "", "zh-Hant": ""} {
+		if category := config.Category(language); category != expected {
+			t.Errorf("Category(%q) = %q, expected %q", language, category, expected)
+		}
+	}
+}
+
+// TestTranslatorURL is a test function that tests the translatorURL function.
+func TestTranslatorURL(t *testing.T) {
+	for _, endpoint := range []string{"https://example.cognitiveservices.azure.com", "https://example.cognitiveservices.azure.com/"} {
+		uri := translatorURL(TranslatorConfig{TranslatorEndpoint: endpoint}, "/translator/document/batches", nil)
+		if expected := "https://example.cognitiveservices.azure.com/translator/document/batches?api-version=" + APIVersion; uri != expected {
+			t.Errorf("translatorURL(%q) returned %q, expected %q", endpoint, uri, expected)
+		}
+	}
+}
+
+// TestOutputPath is a test function that tests the OutputPath function.
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"out/{name}.{lang}{ext}", "out/report.fr.docx"},
+		{"out/{lang}/{name}{ext}", "out/fr/report.docx"},
+		{"translated.docx", "translated.docx"},
+	}
+
+	for _, test := range tests {
+		if path := OutputPath(test.template, "in/report.docx", "fr"); path != test.expected {
+			t.Errorf("OutputPath(%q) returned %q, expected %q", test.template, path, test.expected)
+		}
+	}
+}
+
+// TestParseLanguages is a test function that tests the ParseLanguages function.
+func TestParseLanguages(t *testing.T) {
+	languages := ParseLanguages("fr, de,,es ,fr,DE")
+	if expected := []string{"fr", "de", "es"}; !reflect.DeepEqual(languages, expected) {
+		t.Errorf("ParseLanguages returned %v, expected %v", languages, expected)
+	}
+}
+
 // TestTranslateDocument is a test function that tests the TranslateDocument function.
 func TestTranslateDocument(t *testing.T) {
+	skipWithoutAzure(t, append(translatorEnv, blobEnv...)...)
 	// Test data
 	fileToTranslate := "../../README.md"
 	fileTranslated := "../../README_fr.md"
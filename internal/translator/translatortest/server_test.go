@@ -0,0 +1,713 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains end to end test functions of the translator package against the fake service.
+*/
+
+package translatortest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"translator/internal/translator"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newConfig returns the configuration of the fake server with a silent logger.
+func newConfig(s *Server) translator.TranslatorConfig {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	config := s.Config()
+	config.Logger = log
+	return config
+}
+
+// writeFile creates a file and its parent directories.
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkFile checks the content of a file.
+func checkFile(t *testing.T, path, expected string) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("cannot read %s: %v", path, err)
+	} else if string(content) != expected {
+		t.Errorf("%s contains %q, expected %q", path, content, expected)
+	}
+}
+
+// TestTranslateDocument is a test function that translates a document to several languages through the fake service.
+func TestTranslateDocument(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Key = "secret"
+	server.Latency = 1500 * time.Millisecond
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "report.txt")
+	writeFile(t, source, "hello")
+
+	err := translator.TranslateDocumentToLanguages(source, filepath.Join(dir, "out", "{name}.{lang}{ext}"), "en", []string{"fr", "de"}, newConfig(server))
+	if err != nil {
+		t.Fatalf("TranslateDocumentToLanguages failed: %v", err)
+	}
+	checkFile(t, filepath.Join(dir, "out", "report.fr.txt"), "[fr] hello")
+	checkFile(t, filepath.Join(dir, "out", "report.de.txt"), "[de] hello")
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+}
+
+// TestTranslateDocumentFailures is a test function that checks the typed errors returned for the failures of the fake service.
+func TestTranslateDocumentFailures(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "report.txt")
+	writeFile(t, source, "hello")
+
+	server := NewServer()
+	defer server.Close()
+	server.SubmitError = &translator.ServiceError{Code: "InvalidRequest", Message: "Target language is not supported"}
+	err := translator.TranslateDocument(source, source+".out", "", "xx", newConfig(server))
+	var submissionErr *translator.SubmissionError
+	if !errors.As(err, &submissionErr) || submissionErr.Err.Code != "InvalidRequest" {
+		t.Errorf("TranslateDocument returned %v, expected a *SubmissionError", err)
+	}
+
+	server.SubmitError = nil
+	server.Transform = func(language string, content []byte) ([]byte, error) {
+		return nil, errors.New("unsupported content")
+	}
+	err = translator.TranslateDocument(source, source+".out", "", "fr", newConfig(server))
+	var documentErr *translator.DocumentError
+	if !errors.As(err, &documentErr) || documentErr.Err.Code != "DocumentTranslationFailed" {
+		t.Errorf("TranslateDocument returned %v, expected a *DocumentError", err)
+	}
+
+	server.Transform = nil
+	server.JobError = &translator.ServiceError{Code: "InternalServerError", Message: "Something went wrong"}
+	err = translator.TranslateDocument(source, source+".out", "", "fr", newConfig(server))
+	var jobErr *translator.JobError
+	if !errors.As(err, &jobErr) || jobErr.Status != translator.StatusFailed {
+		t.Errorf("TranslateDocument returned %v, expected a *JobError", err)
+	}
+
+	server.JobError = nil
+	server.Latency = time.Hour
+	config := newConfig(server)
+	config.Timeout = 1
+	err = translator.TranslateDocument(source, source+".out", "", "fr", config)
+	var timeoutErr *translator.TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Errorf("TranslateDocument returned %v, expected a *TimeoutError", err)
+	}
+	if jobs := server.Jobs(); server.JobStatus(jobs[len(jobs)-1]) != translator.StatusCancelled {
+		t.Errorf("the translation job that timed out was not cancelled")
+	}
+}
+
+// TestTranslateDocumentTimeoutRunning is a test function that checks that a job that timed out and could not be cancelled
+// keeps its blobs and its Submitted record, so that it can be resumed.
+func TestTranslateDocumentTimeoutRunning(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "report.txt")
+	writeFile(t, source, "hello")
+	server := NewServer()
+	defer server.Close()
+	server.Latency = 2 * time.Second
+	server.CancelError = &translator.ServiceError{Code: "InvalidRequest", Message: "The job cannot be cancelled"}
+	store := translator.NewFileJobStore(filepath.Join(t.TempDir(), "jobs.json"))
+	config := newConfig(server)
+	config.Jobs = store
+	config.Timeout = 1
+
+	err := translator.TranslateDocument(source, source+".fr", "", "fr", config)
+	var timeoutErr *translator.TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("TranslateDocument returned %v, expected a *TimeoutError", err)
+	}
+	records, _ := store.List()
+	if len(records) != 1 || records[0].State != translator.JobSubmitted {
+		t.Fatalf("job records are %+v, expected a Submitted job", records)
+	}
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 1 {
+		t.Errorf("expected the source blob to be kept, found %v", names)
+	}
+
+	config.Timeout = 30
+	if _, err := translator.ResumeJob(context.Background(), records[0].ID, config); err != nil {
+		t.Fatalf("ResumeJob failed: %v", err)
+	}
+	checkFile(t, source+".fr", "[fr] hello")
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+}
+
+// TestTranslateDocumentTimeoutRunningWithoutStore is a test function that checks that a job that timed out
+// and could not be cancelled deletes its blobs when there is no job store to resume it.
+func TestTranslateDocumentTimeoutRunningWithoutStore(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "report.txt")
+	writeFile(t, source, "hello")
+	server := NewServer()
+	defer server.Close()
+	server.Latency = 2 * time.Second
+	server.CancelError = &translator.ServiceError{Code: "InvalidRequest", Message: "The job cannot be cancelled"}
+	config := newConfig(server)
+	config.Timeout = 1
+
+	err := translator.TranslateDocument(source, source+".fr", "", "fr", config)
+	var timeoutErr *translator.TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("TranslateDocument returned %v, expected a *TimeoutError", err)
+	}
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+}
+
+// TestTranslateDocumentStatusUnavailable is a test function that checks that a job whose documents status cannot be
+// retrieved keeps its blobs and its Submitted record, so that its translated document can be downloaded when it is resumed.
+func TestTranslateDocumentStatusUnavailable(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "report.txt")
+	writeFile(t, source, "hello")
+	server := NewServer()
+	defer server.Close()
+	server.DocumentsUnavailable = true
+	store := translator.NewFileJobStore(filepath.Join(t.TempDir(), "jobs.json"))
+	config := newConfig(server)
+	config.Jobs = store
+	config.Retry.MaxAttempts = 1
+
+	if err := translator.TranslateDocument(source, source+".fr", "", "fr", config); err == nil {
+		t.Fatal("TranslateDocument succeeded without the documents status")
+	}
+	records, _ := store.List()
+	if len(records) != 1 || records[0].State != translator.JobSubmitted {
+		t.Fatalf("job records are %+v, expected a Submitted job", records)
+	}
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 2 {
+		t.Errorf("expected the source and translated blobs to be kept, found %v", names)
+	}
+
+	server.DocumentsUnavailable = false
+	if _, err := translator.ResumeJob(context.Background(), records[0].ID, config); err != nil {
+		t.Fatalf("ResumeJob failed: %v", err)
+	}
+	checkFile(t, source+".fr", "[fr] hello")
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+}
+
+// TestTranslateFolder is a test function that translates a directory tree through the fake service.
+func TestTranslateFolder(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "in", "a.docx"), "a")
+	writeFile(t, filepath.Join(dir, "in", "sub", "b.docx"), "b")
+	writeFile(t, filepath.Join(dir, "in", "sub", "notes.txt"), "ignored")
+
+	results, err := translator.TranslateFolder(filepath.Join(dir, "in"), filepath.Join(dir, "out", "{lang}"), "", []string{"fr", "de"}, &translator.Filter{Suffix: ".docx"}, newConfig(server))
+	if err != nil {
+		t.Fatalf("TranslateFolder failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Errorf("TranslateFolder returned %d results, expected 4: %+v", len(results), results)
+	}
+	checkFile(t, filepath.Join(dir, "out", "fr", "a.docx"), "[fr] a")
+	checkFile(t, filepath.Join(dir, "out", "de", "sub", "b.docx"), "[de] b")
+	if _, err := os.Stat(filepath.Join(dir, "out", "fr", "sub", "notes.txt")); err == nil {
+		t.Error("TranslateFolder translated a document excluded by the filter")
+	}
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+}
+
+// TestTranslateFolderContainerForbidden is a test function that checks that a folder job falls back to the configured
+// container when the credentials are not authorized to create a container of its own.
+func TestTranslateFolderContainerForbidden(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Storage.ForbidContainers = true
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "in", "a.txt"), "a")
+	if _, err := translator.TranslateFolder(filepath.Join(dir, "in"), filepath.Join(dir, "out", "{lang}"), "", []string{"fr"}, nil, newConfig(server)); err != nil {
+		t.Fatalf("TranslateFolder failed: %v", err)
+	}
+	checkFile(t, filepath.Join(dir, "out", "fr", "a.txt"), "[fr] a")
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+}
+
+// TestTranslateFolderContainer is a test function that checks that a folder job stores its blobs in a container
+// of its own, so that the container SAS granted to the service cannot reach the blobs of the other jobs.
+func TestTranslateFolderContainer(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "in", "a.txt"), "a")
+	config := newConfig(server)
+	config.KeepBlobs = true
+	if _, err := translator.TranslateFolder(filepath.Join(dir, "in"), filepath.Join(dir, "out", "{lang}"), "", []string{"fr"}, nil, config); err != nil {
+		t.Fatalf("TranslateFolder failed: %v", err)
+	}
+	containers, _ := server.Storage.ListContainers(ctx, "")
+	if len(containers) != 1 || !strings.HasPrefix(containers[0].Name, "translator-job-") {
+		t.Fatalf("TranslateFolder created the containers %+v, expected a job container", containers)
+	}
+	if names, _ := server.Storage.List(ctx, ""); len(names) != 0 {
+		t.Errorf("TranslateFolder wrote blobs outside of the job container: %v", names)
+	}
+	jobStorage, _ := server.Storage.Container(containers[0].Name)
+	if names, _ := jobStorage.List(ctx, ""); len(names) != 2 {
+		t.Errorf("job container contains %v, expected the source and the translated blobs", names)
+	}
+
+	// The target URL submitted to the service only grants access to the job container.
+	server.mu.Lock()
+	var targetURL string
+	for _, j := range server.jobs {
+		targetURL = j.inputs[0].Targets[0].TargetURL
+	}
+	server.mu.Unlock()
+	server.Storage.CreateContainer(ctx, "other")
+	other, _ := url.Parse(targetURL)
+	other.Path = "/blobs/other/a.txt"
+	root, _ := url.Parse(targetURL)
+	root.Path = "/blobs/a.txt"
+	for rawURL, allowed := range map[string]bool{joinURL(targetURL, "b.txt"): true, other.String(): false, root.String(): false} {
+		if err := putURL(rawURL, []byte("overwritten")); (err == nil) != allowed {
+			t.Errorf("writing %s returned %v, expected allowed=%t", stripQuery(rawURL), err, allowed)
+		}
+	}
+
+	// The job container is deleted with the blobs of the job.
+	if _, err := translator.TranslateFolder(filepath.Join(dir, "in"), filepath.Join(dir, "out", "{lang}"), "", []string{"de"}, nil, newConfig(server)); err != nil {
+		t.Fatalf("TranslateFolder failed: %v", err)
+	}
+	if containers, _ := server.Storage.ListContainers(ctx, ""); len(containers) != 2 {
+		t.Errorf("containers are %+v, expected the kept job container and the other container", containers)
+	}
+}
+
+// TestTranslateDocumentSync is a test function that translates a document with the synchronous endpoint of the fake service.
+func TestTranslateDocumentSync(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "report.txt")
+	writeFile(t, source, "hello")
+	config := newConfig(server)
+	config.Sync = true
+
+	if err := translator.TranslateDocument(source, filepath.Join(dir, "report.fr.txt"), "", "fr", config); err != nil {
+		t.Fatalf("TranslateDocument failed: %v", err)
+	}
+	checkFile(t, filepath.Join(dir, "report.fr.txt"), "[fr] hello")
+	if jobs := server.Jobs(); len(jobs) != 0 {
+		t.Errorf("the synchronous translation submitted batch jobs: %v", jobs)
+	}
+}
+
+// TestTranslateDocumentCancel is a test function that checks that cancelling the context cancels the job and deletes the blobs.
+func TestTranslateDocumentCancel(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Latency = time.Hour
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "report.txt")
+	writeFile(t, source, "hello")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	err := translator.TranslateDocumentContext(ctx, source, filepath.Join(dir, "report.fr.txt"), "en", "fr", newConfig(server))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TranslateDocumentContext returned %v, expected the context error", err)
+	}
+	jobs := server.Jobs()
+	if len(jobs) != 1 || server.JobStatus(jobs[0]) != translator.StatusCancelled {
+		t.Errorf("the translation job was not cancelled: %v", jobs)
+	}
+	if names, _ := server.Storage.List(context.Background(), ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+}
+
+// TestClient is a test function that runs a job with a Client and checks its status, jobs and formats methods.
+func TestClient(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client, err := translator.NewClient(newConfig(server))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "report.txt")
+	writeFile(t, source, "hello")
+	for i := 0; i < 2; i++ {
+		if err := client.Translate(ctx, source, filepath.Join(dir, "report.{lang}.txt"), "en", []string{"fr"}); err != nil {
+			t.Fatalf("Translate failed: %v", err)
+		}
+	}
+	checkFile(t, filepath.Join(dir, "report.fr.txt"), "[fr] hello")
+
+	jobs, err := client.ListJobs(ctx)
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	ids := server.Jobs()
+	if len(jobs) != 2 || jobs[0].ID != ids[1] || jobs[1].ID != ids[0] {
+		t.Fatalf("ListJobs returned %+v, expected the jobs %v the most recent first", jobs, ids)
+	}
+	status, err := client.Status(ctx, ids[0])
+	if err != nil || status.Status != translator.StatusSucceeded || status.Summary.Success != 1 {
+		t.Errorf("Status returned %+v, %v", status, err)
+	}
+	documents, err := client.Documents(ctx, ids[0])
+	if err != nil || len(documents) != 1 || documents[0].To != "fr" {
+		t.Errorf("Documents returned %+v, %v", documents, err)
+	}
+	if _, err := client.Status(ctx, "unknown"); err == nil {
+		t.Error("Status succeeded on an unknown job")
+	}
+
+	formats, err := client.SupportedFormats(ctx)
+	if err != nil || len(formats) == 0 || formats[0].Type != "document" {
+		t.Errorf("SupportedFormats returned %+v, %v", formats, err)
+	}
+	languages, err := client.SupportedLanguages(ctx)
+	if err != nil || languages["fr"].Name != "French" {
+		t.Errorf("SupportedLanguages returned %+v, %v", languages, err)
+	}
+	glossaryFormats, err := client.SupportedGlossaryFormats(ctx)
+	if err != nil || len(glossaryFormats) == 0 || glossaryFormats[0].Type != "glossary" {
+		t.Errorf("SupportedGlossaryFormats returned %+v, %v", glossaryFormats, err)
+	}
+}
+
+// TestClientCancel is a test function that cancels a running job with a Client.
+func TestClientCancel(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Latency = time.Hour
+	client, err := translator.NewClient(newConfig(server))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "report.txt")
+	writeFile(t, source, "hello")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- client.Translate(ctx, source, filepath.Join(dir, "report.fr.txt"), "en", []string{"fr"})
+	}()
+
+	// Cancel the job from another caller once it is submitted.
+	for len(server.Jobs()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	id := server.Jobs()[0]
+	if err := client.Cancel(context.Background(), id); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	var jobErr *translator.JobError
+	if err := <-done; !errors.As(err, &jobErr) || jobErr.Status != translator.StatusCancelled {
+		t.Errorf("Translate returned %v, expected a cancelled job error", err)
+	}
+}
+
+// TestTranslateDocumentThrottled is a test function that checks that throttled requests are retried after their Retry-After delay.
+func TestTranslateDocumentThrottled(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Throttle = 2
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "report.txt")
+	writeFile(t, source, "hello")
+
+	config := newConfig(server)
+	config.Retry = translator.RetryPolicy{BaseDelay: translator.Duration(10 * time.Millisecond)}
+	start := time.Now()
+	if err := translator.TranslateDocument(source, filepath.Join(dir, "report.fr.txt"), "en", "fr", config); err != nil {
+		t.Fatalf("TranslateDocument failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("the Retry-After delay was not honoured, the translation took %s", elapsed)
+	}
+	checkFile(t, filepath.Join(dir, "report.fr.txt"), "[fr] hello")
+
+	// Without retries, the throttled submission is rejected.
+	server.Throttle = 1
+	config.Retry.MaxAttempts = 1
+	var submissionErr *translator.SubmissionError
+	if err := translator.TranslateDocument(source, filepath.Join(dir, "report.fr.txt"), "en", "fr", config); !errors.As(err, &submissionErr) || submissionErr.StatusCode != 429 {
+		t.Errorf("TranslateDocument returned %v, expected a 429 submission error", err)
+	}
+}
+
+// TestTranslateDocumentProgress is a test function that checks the progress events of a translation.
+func TestTranslateDocumentProgress(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "report.txt")
+	writeFile(t, source, "hello")
+
+	var mu sync.Mutex
+	events := map[translator.ProgressKind]translator.ProgressEvent{}
+	config := newConfig(server)
+	config.Progress = translator.ProgressFunc(func(event translator.ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[event.Kind] = event
+	})
+	if err := translator.TranslateDocument(source, filepath.Join(dir, "report.fr.txt"), "en", "fr", config); err != nil {
+		t.Fatalf("TranslateDocument failed: %v", err)
+	}
+
+	jobID := server.Jobs()[0]
+	if event := events[translator.ProgressUpload]; event.Name != source || event.Bytes != 5 || event.Total != 5 {
+		t.Errorf("unexpected last upload event: %+v", event)
+	}
+	if event := events[translator.ProgressSubmitted]; event.JobID != jobID {
+		t.Errorf("unexpected submission event: %+v", event)
+	}
+	if event := events[translator.ProgressStatus]; event.JobID != jobID || event.Status != translator.StatusSucceeded || event.Summary.Success != 1 {
+		t.Errorf("unexpected last status event: %+v", event)
+	}
+	if event := events[translator.ProgressCharged]; event.Characters != 5 {
+		t.Errorf("unexpected last charged event: %+v", event)
+	}
+	if event := events[translator.ProgressDownload]; event.Name != filepath.Join(dir, "report.fr.txt") || event.Bytes != 10 || event.Total != 10 {
+		t.Errorf("unexpected last download event: %+v", event)
+	}
+}
+
+// submitBatch uploads a document and submits its translation to the fake service, like a process killed after the submission.
+// It returns the operation URL of the job.
+func submitBatch(t *testing.T, server *Server, sourceBlob, content string, target translator.JobTarget) string {
+	ctx := context.Background()
+	if err := server.Storage.Put(ctx, sourceBlob, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	expiry := time.Now().Add(time.Hour)
+	sourceURL, _ := server.Storage.SignedURL(ctx, sourceBlob, translator.Permissions{Read: true}, expiry)
+	targetURL, _ := server.Storage.SignedURL(ctx, target.Blob, translator.Permissions{Write: true}, expiry)
+	document, _ := json.Marshal(translator.ATranslateDocument{Inputs: []translator.Input{{
+		StorageType: "File",
+		Source:      translator.Source{SourceURL: sourceURL},
+		Targets:     []translator.Target{{TargetURL: targetURL, Language: target.Language}},
+	}}})
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/translator/document/batches?api-version="+translator.APIVersion, bytes.NewReader(document))
+	req.Header.Set("Ocp-Apim-Subscription-Key", server.Key)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("submission failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("submission returned %s", res.Status)
+	}
+	return res.Header.Get("Operation-Location")
+}
+
+// TestResumeJob is a test function that resumes the jobs of an interrupted process from the job store.
+func TestResumeJob(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Latency = 500 * time.Millisecond
+	dir := t.TempDir()
+	store := translator.NewFileJobStore(filepath.Join(dir, "jobs.json"))
+	config := newConfig(server)
+	config.Jobs = store
+	client, err := translator.NewClient(config)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	ctx := context.Background()
+
+	// A submitted job is polled, downloaded and cleaned up.
+	target := translator.JobTarget{Language: "fr", Blob: "job1-translated-fr-report.txt", DestinationFile: filepath.Join(dir, "report.fr.txt")}
+	operationURL := submitBatch(t, server, "job1-report.txt", "hello", target)
+	now := time.Now().UTC()
+	store.Save(translator.JobRecord{ID: "job1", OperationURL: operationURL, BatchID: path.Base(operationURL), Source: "report.txt", SourceBlob: "job1-report.txt",
+		Targets: []translator.JobTarget{target}, State: translator.JobSubmitted, Created: now})
+	if _, err := client.Resume(ctx, path.Base(operationURL)); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	checkFile(t, target.DestinationFile, "[fr] hello")
+	if record, err := store.Load("job1"); err != nil || record.State != translator.JobCompleted {
+		t.Errorf("job record is %+v, %v, expected Completed", record, err)
+	}
+
+	// A job interrupted during its upload was never submitted, its blobs are deleted.
+	server.Storage.Put(ctx, "job2-report.txt", strings.NewReader("hello"))
+	store.Save(translator.JobRecord{ID: "job2", Source: "report.txt", SourceBlob: "job2-report.txt", State: translator.JobUploading, Created: now.Add(time.Second)})
+	if _, err := client.Resume(ctx, "job2"); err == nil {
+		t.Error("Resume of a job interrupted before its submission succeeded")
+	}
+	if record, err := store.Load("job2"); err != nil || record.State != translator.JobFailed || record.Error == "" {
+		t.Errorf("job record is %+v, %v, expected Failed with an error", record, err)
+	}
+	if names, _ := server.Storage.List(ctx, ""); len(names) != 0 {
+		t.Errorf("temporary blobs were not deleted: %v", names)
+	}
+
+	// A job whose blobs could not be deleted returns the error of the same type as its translation once cleaned up.
+	source := filepath.Join(dir, "notes.txt")
+	writeFile(t, source, "hi")
+	server.SubmitError = &translator.ServiceError{Code: "InvalidRequest", Message: "Target language is not supported"}
+	failing := config
+	failing.Storage = failingDeleteStorage{server.Storage, func(string) bool { return true }}
+	translateErr := translator.TranslateDocument(source, filepath.Join(dir, "notes.xx.txt"), "", "xx", failing)
+	server.SubmitError = nil
+	records, err := store.List()
+	var cleanup *translator.JobRecord
+	for i := range records {
+		if records[i].State == translator.JobCleanup {
+			cleanup = &records[i]
+		}
+	}
+	if err != nil || cleanup == nil {
+		t.Fatalf("job records are %+v, %v, expected a job in the Cleanup state", records, err)
+	}
+	var submissionErr *translator.SubmissionError
+	if _, err := client.Resume(ctx, cleanup.ID); !errors.As(err, &submissionErr) || err.Error() != translateErr.Error() {
+		t.Errorf("Resume returned %v, expected the *SubmissionError %v", err, translateErr)
+	}
+	if record, err := store.Load(cleanup.ID); err != nil || record.State != translator.JobFailed {
+		t.Errorf("job record is %+v, %v, expected Failed", record, err)
+	}
+
+	// The jobs of the process are recorded until their completion.
+	if err := client.Translate(ctx, source, filepath.Join(dir, "notes.{lang}.txt"), "", []string{"de"}); err != nil {
+		t.Fatalf("Translate failed: %v", err)
+	}
+	records, err = client.StoredJobs()
+	if err != nil || len(records) != 4 {
+		t.Fatalf("StoredJobs returned %+v, %v", records, err)
+	}
+	if records[0].Source != source || records[0].State != translator.JobCompleted || records[0].BatchID == "" {
+		t.Errorf("unexpected record of the last job: %+v", records[0])
+	}
+	if _, err := client.Resume(ctx, "unknown"); !errors.Is(err, translator.ErrJobNotFound) {
+		t.Errorf("Resume of an unknown job returned %v", err)
+	}
+}
+
+// failingDeleteStorage is a storage refusing to delete the blobs for which fail returns true.
+type failingDeleteStorage struct {
+	*translator.MemoryStorage
+	fail func(name string) bool
+}
+
+func (s failingDeleteStorage) Delete(ctx context.Context, name string) error {
+	if s.fail(name) {
+		return errors.New("delete refused")
+	}
+	return s.MemoryStorage.Delete(ctx, name)
+}
+
+// TestTranslateDocumentCleanup is a test function that checks that the blobs of a job are deleted on every exit path.
+func TestTranslateDocumentCleanup(t *testing.T) {
+	ctx := context.Background()
+	source := filepath.Join(t.TempDir(), "report.txt")
+	writeFile(t, source, "hello")
+	server := NewServer()
+	defer server.Close()
+	remaining := func() []string {
+		names, _ := server.Storage.List(ctx, "")
+		return names
+	}
+
+	// A rejected submission.
+	server.SubmitError = &translator.ServiceError{Code: "InvalidRequest", Message: "Target language is not supported"}
+	if err := translator.TranslateDocument(source, source+".out", "", "xx", newConfig(server)); err == nil {
+		t.Fatal("TranslateDocument succeeded with a rejected submission")
+	}
+	if names := remaining(); len(names) != 0 {
+		t.Errorf("blobs of a rejected job were not deleted: %v", names)
+	}
+	server.SubmitError = nil
+
+	// The translated blob is deleted even if the source blob cannot be deleted.
+	config := newConfig(server)
+	isSource := func(name string) bool { return !strings.Contains(name, "-translated-") }
+	config.Storage = failingDeleteStorage{server.Storage, isSource}
+	err := translator.TranslateDocument(source, source+".fr", "", "fr", config)
+	var blobErr *translator.BlobError
+	if !errors.As(err, &blobErr) || blobErr.Op != "delete" {
+		t.Errorf("TranslateDocument returned %v, expected a delete *BlobError", err)
+	}
+	if names := remaining(); len(names) != 1 || !isSource(names[0]) {
+		t.Errorf("expected only the source blob to remain, found %v", names)
+	}
+	for _, name := range remaining() {
+		server.Storage.Delete(ctx, name)
+	}
+
+	// A panic during the translation.
+	config = newConfig(server)
+	config.Progress = translator.ProgressFunc(func(event translator.ProgressEvent) {
+		if event.Kind == translator.ProgressStatus {
+			panic("reporter failure")
+		}
+	})
+	func() {
+		defer func() {
+			if p := recover(); p != "reporter failure" {
+				t.Errorf("TranslateDocument panicked with %v, expected the panic of the reporter", p)
+			}
+		}()
+		translator.TranslateDocument(source, source+".fr", "", "fr", config)
+	}()
+	if names := remaining(); len(names) != 0 {
+		t.Errorf("blobs of a panicking job were not deleted: %v", names)
+	}
+
+	// The blobs are kept on demand.
+	config = newConfig(server)
+	config.KeepBlobs = true
+	if err := translator.TranslateDocument(source, source+".fr", "", "fr", config); err != nil {
+		t.Fatalf("TranslateDocument failed: %v", err)
+	}
+	if names := remaining(); len(names) != 2 {
+		t.Errorf("expected the source and translated blobs to be kept, found %v", names)
+	}
+}
@@ -0,0 +1,224 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"translator/internal/translator"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultJobStorePath returns the path of the job store in the user configuration directory,
+// or an empty path, which disables the job store, if there is no such directory.
+func defaultJobStorePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "translator", "jobs.json")
+}
+
+// defineJobStoreFlag defines the flag of the job store path on a flag set.
+func defineJobStoreFlag(fs *flag.FlagSet) *string {
+	return fs.String("jobStore", defaultJobStorePath(), "JSON file recording the translation jobs so that they can be resumed (empty to disable)")
+}
+
+// setupResume defines the resume command, picking up an interrupted translation job where it was left.
+// The command takes the local or batch ID of the job after its flags.
+// It returns an error if the job cannot be found or its translation fails.
+func setupResume(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, translatorFlags|storageFlags|transferFlags|jobStoreFlags)
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 1, "one job ID"); err != nil {
+			return err
+		}
+		log := logrus.New()
+		log.SetOutput(os.Stdout)
+
+		config, err := service.config(log)
+		if err != nil {
+			return err
+		}
+		if err := validateServiceInputs(config); err != nil {
+			return err
+		}
+		if config.Jobs == nil {
+			return fmt.Errorf("no job store, set -jobStore")
+		}
+
+		progress, closeProgress := newProgressReporter(log, os.Stdout)
+		defer closeProgress()
+		config.Progress = progress
+		client, err := translator.NewClient(config)
+		if err != nil {
+			return err
+		}
+
+		// Cancel the translation job and delete the temporary blobs on Ctrl-C or SIGTERM
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		results, err := client.Resume(ctx, args[0])
+		closeProgress()
+		printResults(results)
+		return err
+	}
+}
+
+// setupJobs defines the jobs command, whose only subcommand, list, prints the jobs of the job store,
+// or the jobs of the Translator resource with -remote.
+// The job store is read without resolving the secrets of the configuration.
+func setupJobs(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, translatorFlags|jobStoreFlags)
+	remote := fs.Bool("remote", false, "List the jobs of the Translator resource instead of the job store")
+	return func(args []string) error {
+		if err := expectArgs(fs, args[1:], 0, ""); err != nil {
+			return err
+		}
+		if *remote {
+			client, err := service.client()
+			if err != nil {
+				return err
+			}
+			jobs, err := client.ListJobs(context.Background())
+			if err != nil {
+				return err
+			}
+			printBatches(jobs)
+			return nil
+		}
+
+		jobStore, err := service.jobStore()
+		if err != nil {
+			return err
+		}
+		if jobStore == "" {
+			return fmt.Errorf("no job store, set -jobStore")
+		}
+		records, err := translator.NewFileJobStore(jobStore).List()
+		if err != nil {
+			return err
+		}
+		printJobs(records)
+		return nil
+	}
+}
+
+// setupStatus defines the status command, printing the status of a translation job of the Translator service
+// and, with -documents, the status of its documents.
+// The command takes the batch ID of the job, or its local ID in the job store, after its flags.
+func setupStatus(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, translatorFlags|jobStoreFlags)
+	documents := fs.Bool("documents", false, "Also print the status of each document of the job")
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 1, "one job ID"); err != nil {
+			return err
+		}
+		client, err := service.client()
+		if err != nil {
+			return err
+		}
+		id := batchID(client.Config(), args[0])
+		status, err := client.Status(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		printBatches([]translator.BatchStatus{*status})
+		if !*documents {
+			return nil
+		}
+		statuses, err := client.Documents(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+		printDocuments(statuses)
+		return nil
+	}
+}
+
+// setupCancel defines the cancel command, cancelling a translation job of the Translator service.
+// The command takes the batch ID of the job, or its local ID in the job store, after its flags.
+func setupCancel(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, translatorFlags|jobStoreFlags)
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 1, "one job ID"); err != nil {
+			return err
+		}
+		client, err := service.client()
+		if err != nil {
+			return err
+		}
+		id := batchID(client.Config(), args[0])
+		if err := client.Cancel(context.Background(), id); err != nil {
+			return err
+		}
+		fmt.Printf("Cancelled translation job %s\n", id)
+		return nil
+	}
+}
+
+// batchID returns the batch ID of a job given by its local ID or its batch ID in the job store of config,
+// or id itself if the job store does not know it.
+func batchID(config translator.TranslatorConfig, id string) string {
+	if config.Jobs == nil {
+		return id
+	}
+	if record, err := config.Jobs.Load(id); err == nil && record.BatchID != "" {
+		return record.BatchID
+	}
+	return id
+}
+
+// printJobs prints the records of the job store as a table.
+func printJobs(records []translator.JobRecord) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tBATCH\tSTATE\tSOURCE\tLANGUAGES\tUPDATED\tERROR")
+	for _, record := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", record.ID, record.BatchID, record.State, record.Source,
+			strings.Join(record.Languages(), ","), record.Updated.Local().Format(time.DateTime), record.Error)
+	}
+	w.Flush()
+}
+
+// printBatches prints the status of translation jobs of the Translator service as a table.
+func printBatches(batches []translator.BatchStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tCREATED\tUPDATED\tDOCUMENTS\tSUCCEEDED\tFAILED\tCHARACTERS\tERROR")
+	for _, batch := range batches {
+		message := ""
+		if batch.Error != nil {
+			message = batch.Error.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", batch.ID, batch.Status,
+			batch.CreatedDateTimeUtc.Local().Format(time.DateTime), batch.LastActionDateTimeUtc.Local().Format(time.DateTime),
+			batch.Summary.Total, batch.Summary.Success, batch.Summary.Failed, batch.Summary.TotalCharacterCharged, message)
+	}
+	w.Flush()
+}
+
+// printDocuments prints the status of the documents of a translation job as a table.
+func printDocuments(documents []translator.DocumentStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOCUMENT\tLANGUAGE\tSTATUS\tPROGRESS\tCHARACTERS\tERROR")
+	for _, document := range documents {
+		message := ""
+		if document.Error != nil {
+			message = document.Error.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.0f%%\t%d\t%s\n", document.SourcePath, document.To, document.Status,
+			document.Progress*100, document.CharacterCharged, message)
+	}
+	w.Flush()
+}
@@ -0,0 +1,90 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"translator/internal/translator"
+)
+
+// setupLanguages defines the languages command, printing the languages supported for translation by the Translator service.
+func setupLanguages(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, translatorFlags)
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 0, ""); err != nil {
+			return err
+		}
+		client, err := service.client()
+		if err != nil {
+			return err
+		}
+		languages, err := client.SupportedLanguages(context.Background())
+		if err != nil {
+			return err
+		}
+		printLanguages(languages)
+		return nil
+	}
+}
+
+// setupFormats defines the formats command, printing the document formats supported by the Translator service,
+// or its glossary formats with -glossary.
+func setupFormats(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, translatorFlags)
+	glossary := fs.Bool("glossary", false, "List the glossary formats instead of the document formats")
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 0, ""); err != nil {
+			return err
+		}
+		client, err := service.client()
+		if err != nil {
+			return err
+		}
+		var formats []translator.FileFormat
+		if *glossary {
+			formats, err = client.SupportedGlossaryFormats(context.Background())
+		} else {
+			formats, err = client.SupportedFormats(context.Background())
+		}
+		if err != nil {
+			return err
+		}
+		printFormats(formats)
+		return nil
+	}
+}
+
+// printLanguages prints the supported languages as a table sorted by language code.
+func printLanguages(languages map[string]translator.Language) {
+	codes := make([]string, 0, len(languages))
+	for code := range languages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tNAME\tNATIVE NAME\tDIRECTION")
+	for _, code := range codes {
+		language := languages[code]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", code, language.Name, language.NativeName, language.Dir)
+	}
+	w.Flush()
+}
+
+// printFormats prints the supported file formats as a table.
+func printFormats(formats []translator.FileFormat) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FORMAT\tEXTENSIONS\tCONTENT TYPES\tVERSIONS")
+	for _, format := range formats {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", format.Format, strings.Join(format.FileExtensions, ","),
+			strings.Join(format.ContentTypes, ","), strings.Join(format.Versions, ","))
+	}
+	w.Flush()
+}
@@ -7,11 +7,17 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"runtime"
+	"sort"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"translator/internal/translator"
 
 	"github.com/sirupsen/logrus"
@@ -25,118 +31,403 @@ const (
 	envBlobAccount        = "BLOB_STORAGE_ACCOUNT_NAME"
 	envBlobAccountKey     = "BLOB_STORAGE_ACCOUNT_KEY"
 	envBlobContainer      = "BLOB_STORAGE_CONTAINER_NAME"
+	envBlobServiceURL     = "BLOB_STORAGE_SERVICE_URL"
+	// The variables of the Azure SDK configuring the Microsoft Entra ID credentials
+	envTenantID            = "AZURE_TENANT_ID"
+	envClientID            = "AZURE_CLIENT_ID"
+	envClientSecret        = "AZURE_CLIENT_SECRET"
+	envClientCertificate   = "AZURE_CLIENT_CERTIFICATE_PATH"
+	envCertificatePassword = "AZURE_CLIENT_CERTIFICATE_PASSWORD"
+	envAuthorityHost       = "AZURE_AUTHORITY_HOST"
 )
 
-var verbose bool
+// Exit codes returned by the CLI, one per kind of failure
+const (
+	exitError              = 1
+	exitSubmissionRejected = 3
+	exitJobFailed          = 4
+	exitDocumentFailed     = 5
+	exitTimeout            = 6
+	exitBlobStorage        = 7
+	exitCancelRejected     = 8
+	exitCancelled          = 130
+)
+
+// redactor masks the secrets of the configuration in the error printed on exit, nil with -unsafe-log-secrets.
+var redactor *translator.Redactor
 
 // main is the entry point of the application.
-// It parses command-line arguments, loads configuration from file if specified, validates inputs, and performs the translation.
+// It runs the command of the command line and exits with the code of its error, if any.
 func main() {
-	if err := run(); err != nil {
+	err := runCommand(os.Args[1:])
+	if err != nil {
+		if redactor != nil {
+			err = redactor.Error(err)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode maps an error returned by run to the process exit code.
+func exitCode(err error) int {
+	var submissionErr *translator.SubmissionError
+	var jobErr *translator.JobError
+	var documentErr *translator.DocumentError
+	var timeoutErr *translator.TimeoutError
+	var blobErr *translator.BlobError
+	var cancelErr *translator.CancelError
+	switch {
+	case errors.As(err, &submissionErr):
+		return exitSubmissionRejected
+	case errors.As(err, &jobErr):
+		return exitJobFailed
+	case errors.As(err, &documentErr):
+		return exitDocumentFailed
+	case errors.As(err, &timeoutErr):
+		return exitTimeout
+	case errors.As(err, &blobErr):
+		return exitBlobStorage
+	case errors.As(err, &cancelErr):
+		return exitCancelRejected
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
 	}
+	return exitError
 }
 
-// run is the main logic of the application.
+// setupTranslate defines the translate command, the main logic of the application.
 // It sets up the translator configuration, validates inputs, and performs the translation.
-// It returns an error if any step fails.
-func run() error {
+// The command returns an error if any step fails.
+func setupTranslate(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, allServiceFlags)
+	in := fs.String("in", "", "Input file path, or input directory to translate a whole folder")
+	from := fs.String("from", "", "Source language")
+	to := fs.String("to", "", "Target language, or comma-separated list of target languages")
+	out := fs.String("out", "", "Destination file path, or destination directory for a folder, may contain {name}, {lang} and {ext} placeholders")
+	prefix := fs.String("prefix", "", "Only translate the documents of the input directory whose relative path starts with this prefix")
+	suffix := fs.String("suffix", "", "Only translate the documents of the input directory whose relative path ends with this suffix (e.g. .docx)")
+	glossary := fs.String("glossary", "", "Glossary file path (.tsv, .csv or .xlf)")
+	sync := fs.Bool("sync", false, "Translate the document synchronously, without Azure Blob Storage")
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 0, ""); err != nil {
+			return err
+		}
+		return translate(service, *in, *from, *to, *out, *prefix, *suffix, *glossary, *sync)
+	}
+}
+
+// translate translates the document or the folder in to the languages to, writing the translations to out.
+// It returns an error if the inputs are invalid or the translation fails.
+func translate(service *serviceFlags, in, from, to, out, prefix, suffix, glossary string, sync bool) error {
 	// Set up logging
 	log := logrus.New()
 	log.SetOutput(os.Stdout)
 
-	// Parse command-line arguments
-	endpoint := flag.String("endpoint", os.Getenv(envTranslatorEndpoint), "Azure Translator API endpoint")
-	key := flag.String("key", os.Getenv(envTranslatorKey), "Azure Translator API key")
-	region := flag.String("region", os.Getenv(envTranslatorRegion), "Azure region")
-	in := flag.String("in", "", "Input file path")
-	from := flag.String("from", "", "Source language")
-	to := flag.String("to", "", "Target language")
-	out := flag.String("out", "", "Destination file path")
-	timeout := flag.Int("timeout", 30, "Timeout in seconds")
-	blobAccount := flag.String("blobAccount", os.Getenv(envBlobAccount), "Azure Blob Storage account name")
-	blobAccountKey := flag.String("blobAccountKey", os.Getenv(envBlobAccountKey), "Azure Blob Storage account key")
-	blobContainer := flag.String("blobContainer", os.Getenv(envBlobContainer), "Azure Blob Storage container name")
-	configFile := flag.String("config", "", "Configuration file path")
-	flag.BoolVar(&verbose, "v", false, "enable verbose logging")
-	flag.Parse()
-
-	// Load configuration from file if specified
-	if *configFile != "" {
-		err := loadConfigFromFile(*configFile, endpoint, key, region, blobAccount, blobAccountKey, blobContainer, &verbose)
-		if err != nil {
-			return err
-		}
+	// Set up translator configuration
+	config, err := service.config(log)
+	if err != nil {
+		return err
 	}
+	config.GlossaryFile = glossary
+	config.Sync = sync
 
-	// Validate inputs
-	if err := validateInputs(*endpoint, *key, *region, *in, *out, *to, *blobAccount, *blobAccountKey, *blobContainer); err != nil {
+	// Validate inputs, the blob storage is not needed for a synchronous translation,
+	// which translates single documents only
+	info, err := os.Stat(in)
+	folder := err == nil && info.IsDir()
+	if folder && config.Sync {
+		return fmt.Errorf("-sync cannot translate the directory %s, the documents of a folder are translated through the blob storage", in)
+	}
+	syncMode := !folder && translator.UseSyncTranslation(config, in)
+	if err := validateInputs(config, in, out, to, syncMode); err != nil {
 		return err
 	}
+	targetLanguages := translator.ParseLanguages(to)
+	if len(targetLanguages) > 1 && !strings.Contains(out, "{lang}") {
+		return fmt.Errorf("-out must contain {lang} when translating to several languages")
+	}
+	warnUnusedCategories(log, config.Categories, targetLanguages)
 
-	// Set up translator configuration
-	config := translator.TranslatorConfig{
-		TranslatorEndpoint: *endpoint,
-		TranslatorKey:      *key,
-		TranslatorRegion:   *region,
-		BlobAccountName:    *blobAccount,
-		BlobAccountKey:     *blobAccountKey,
-		BlobContainerName:  *blobContainer,
-		Timeout:            *timeout,
-		Verbose:            verbose,
-		Logger:             log,
-	}
-
-	// Set log level based on verbose flag
+	// Report the progress on a live line on terminals, as log lines otherwise
+	progress, closeProgress := newProgressReporter(log, os.Stdout)
+	defer closeProgress()
+	config.Progress = progress
+
+	client, err := translator.NewClient(config)
+	if err != nil {
+		return err
+	}
+
+	// Cancel the translation job and delete the temporary blobs on Ctrl-C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Translate a whole folder if the input is a directory
+	if folder {
+		log.Info("Starting folder translation")
+		results, err := client.TranslateFolder(ctx, in, out, from, targetLanguages, &translator.Filter{Prefix: prefix, Suffix: suffix})
+		closeProgress()
+		printResults(results)
+		return err
+	}
+
+	// Perform the translation
+	log.Info("Starting document translation")
+	return client.Translate(ctx, in, out, from, targetLanguages)
+}
+
+// serviceFlags are the command-line flags configuring the Translator service and the blob storage,
+// shared by the translation and the serve commands.
+// The flags only override the configuration when they are given, see (*serviceFlags).load.
+type serviceFlags struct {
+	fs            *flag.FlagSet
+	configFile    *string
+	profile       *string
+	unsafeSecrets *bool
+}
+
+// serviceFlagGroup selects groups of service flags, so that each command only defines the flags it uses.
+type serviceFlagGroup int
+
+// The groups of service flags. The configuration file, profile, credential, timeout and logging flags are always defined.
+const (
+	// translatorFlags configure the Translator service.
+	translatorFlags serviceFlagGroup = 1 << iota
+	// storageFlags configure the blob storage account and container.
+	storageFlags
+	// transferFlags configure the transfers of the documents of a job, its SAS tokens and the deletion of its blobs.
+	transferFlags
+	// translationFlags configure the translation of documents.
+	translationFlags
+	// jobStoreFlags configure the job store.
+	jobStoreFlags
+	// allServiceFlags are all the groups of service flags.
+	allServiceFlags = translatorFlags | storageFlags | transferFlags | translationFlags | jobStoreFlags
+)
+
+// defineServiceFlags defines the service flags of some groups and the verbose flag on a flag set.
+// The Translator, blob storage and credential settings may also be set by their environment variables,
+// which the flags override.
+func defineServiceFlags(fs *flag.FlagSet, groups serviceFlagGroup) *serviceFlags {
+	if groups&translatorFlags != 0 {
+		fs.String("endpoint", "", "Azure Translator API endpoint (env "+envTranslatorEndpoint+")")
+		fs.String("key", "", "Azure Translator API key (env "+envTranslatorKey+")")
+		fs.String("region", "", "Azure region (env "+envTranslatorRegion+")")
+	}
+	fs.Int("timeout", 30, "Timeout in seconds")
+	if groups&storageFlags != 0 {
+		fs.String("blobAccount", "", "Azure Blob Storage account name (env "+envBlobAccount+")")
+		fs.String("blobAccountKey", "", "Azure Blob Storage account key (env "+envBlobAccountKey+")")
+		fs.String("blobContainer", "", "Azure Blob Storage container name (env "+envBlobContainer+")")
+		fs.String("blobServiceUrl", "", "Azure Blob Storage service URL (env "+envBlobServiceURL+", default: https://<blobAccount>.blob.core.windows.net)")
+	}
+	if groups&transferFlags != 0 {
+		fs.Int64("blockSize", translator.DefaultBlockSize, "Size in bytes of the blocks uploaded to and downloaded from Azure Blob Storage")
+		fs.Int("parallelism", translator.DefaultParallelism, "Number of blocks transferred in parallel")
+		fs.Bool("keep-blobs", false, "Keep the temporary blobs of the jobs in Azure Blob Storage instead of deleting them, for debugging")
+		fs.Duration("sasExpiry", 0, "Lifetime of the SAS tokens given to the Translator service (default: the timeout plus 15m)")
+		fs.String("sasIpRange", "", "IP address or range start-end allowed to use the SAS tokens (default: any)")
+	}
+	if groups&translationFlags != 0 {
+		fs.String("category", "", "Custom Translator category ID per target language (e.g. fr=abc123,de=def456)")
+		fs.Int64("syncThreshold", 0, "File size in bytes up to which documents are translated synchronously, e.g. 1048576 (0 to disable)")
+	}
+	if groups&jobStoreFlags != 0 {
+		defineJobStoreFlag(fs)
+	}
+	fs.String("credential", "", "Microsoft Entra ID credential used instead of the keys: clientSecret, clientCertificate, workloadIdentity, managedIdentity or azureCli")
+	fs.String("tenantId", "", "Microsoft Entra ID tenant of the application (env "+envTenantID+")")
+	fs.String("clientId", "", "Client ID of the application or of the user-assigned managed identity (env "+envClientID+")")
+	fs.String("clientCertificate", "", "PEM or PKCS#12 file of the certificate of the application (env "+envClientCertificate+")")
+	fs.Bool("v", false, "enable verbose logging")
+	return &serviceFlags{
+		fs:            fs,
+		configFile:    fs.String("config", "", "Configuration file path"),
+		profile:       fs.String("profile", "", "Profile of the configuration file overriding its top-level settings (e.g. prod-westeurope)"),
+		unsafeSecrets: fs.Bool("unsafe-log-secrets", false, "Log the SAS tokens and the keys unredacted, for debugging"),
+	}
+}
+
+// config builds the translator configuration from the defaults, the configuration file and its profile, if any,
+// the environment and the parsed service flags, each overriding the previous ones.
+// It sets the level of log from the verbose setting and uses log as the logger of the configuration.
+// It returns an error if the categories are malformed, the configuration file cannot be read or a secret cannot be resolved.
+func (f *serviceFlags) config(log *logrus.Logger) (translator.TranslatorConfig, error) {
+	layered, err := f.load()
+	if err != nil {
+		return translator.TranslatorConfig{}, err
+	}
+	var cli cliConfig
+	if err := layered.decode(&cli); err != nil {
+		return translator.TranslatorConfig{}, fmt.Errorf("error decoding configuration: %v", err)
+	}
+	config := cli.TranslatorConfig
+
+	// Set log level based on verbose setting
 	if config.Verbose {
 		log.SetLevel(logrus.DebugLevel)
 	} else {
 		log.SetLevel(logrus.InfoLevel)
 	}
+	config.UnsafeLogSecrets = *f.unsafeSecrets
+	config.Logger = log
 
-	// Perform the translation
-	log.Info("Starting document translation")
-	return translator.TranslateDocument(*in, *out, *from, *to, config)
+	// Resolve the secret references before the redactor is built, so that it masks the secrets themselves
+	if err := config.ResolveSecrets(context.Background()); err != nil {
+		return translator.TranslatorConfig{}, err
+	}
+	if config.UnsafeLogSecrets {
+		log.Warn("The SAS tokens and the keys are logged unredacted")
+	} else {
+		redactor = config.Redactor()
+	}
+	if cli.JobStore != "" {
+		config.Jobs = translator.NewFileJobStore(cli.JobStore)
+	}
+	return config, nil
 }
 
-// loadConfigFromFile loads the translator configuration from a JSON file.
-// It updates the endpoint, key, region, blobAccount, blobAccountKey, blobContainer, and verbose variables with the values from the file.
-func loadConfigFromFile(configFile string, endpoint, key, region, blobAccount, blobAccountKey, blobContainer *string, verbose *bool) error {
-	file, err := os.Open(configFile)
+// client builds the configuration of the service flags and a client of the Translator service,
+// for the commands querying the service that need neither the blob storage nor a job to translate.
+// The log lines are written to the standard error, leaving the standard output to the result of the command.
+// It returns an error if the configuration is invalid or the Translator service settings are missing.
+func (f *serviceFlags) client() (*translator.Client, error) {
+	log := logrus.New()
+	log.SetOutput(os.Stderr)
+	config, err := f.config(log)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
+	if err := validateServiceInputs(config); err != nil {
+		return nil, err
+	}
+	// The blob storage is not needed, do not authenticate with it
+	config.BlobAccountName = ""
+	return translator.NewClient(config)
+}
+
+// jobStore returns the path of the job store of the configuration, empty if it is disabled.
+// The secrets of the configuration are not resolved.
+func (f *serviceFlags) jobStore() (string, error) {
+	layered, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	var cli cliConfig
+	if err := layered.decode(&cli); err != nil {
+		return "", fmt.Errorf("error decoding configuration: %v", err)
+	}
+	return cli.JobStore, nil
+}
+
+// printResults prints the result of each document of a folder translation as a table.
+func printResults(results []translator.DocumentResult) {
+	if len(results) == 0 {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOCUMENT\tLANGUAGE\tSTATUS\tCHARACTERS\tOUTPUT")
+	for _, result := range results {
+		output := result.DestinationFile
+		if result.Error != nil {
+			output = result.Error.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", result.SourcePath, result.Language, result.Status, result.CharacterCharged, output)
+	}
+	w.Flush()
+}
 
-	var config translator.TranslatorConfig
-	err = json.NewDecoder(file).Decode(&config)
+// checkConfigPermissions checks that a configuration file is only readable by its owner.
+// The permissions are not checked on Windows, whose files have no Unix permission bits.
+func checkConfigPermissions(file *os.File) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
+	if mode := info.Mode().Perm(); mode&0044 != 0 {
+		return fmt.Errorf("configuration file %s is readable by its group or others (mode %04o), restrict it with chmod 600", file.Name(), mode)
+	}
+	return nil
+}
+
+// parseCategories parses a comma-separated list of language=category pairs.
+// The languages are keyed in lower case, the language tags being case-insensitive.
+// It returns an error if a pair is malformed.
+func parseCategories(list string) (map[string]string, error) {
+	categories := map[string]string{}
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		language, category, ok := strings.Cut(pair, "=")
+		language = strings.TrimSpace(language)
+		category = strings.TrimSpace(category)
+		if !ok || language == "" || category == "" {
+			return nil, fmt.Errorf("invalid category %q, expected language=category", pair)
+		}
+		categories[strings.ToLower(language)] = category
+	}
+	return categories, nil
+}
 
-	*endpoint = config.TranslatorEndpoint
-	*key = config.TranslatorKey
-	*region = config.TranslatorRegion
-	*blobAccount = config.BlobAccountName
-	*blobAccountKey = config.BlobAccountKey
-	*blobContainer = config.BlobContainerName
-	*verbose = config.Verbose
+// warnUnusedCategories warns about the categories configured for a language that is not among the target languages,
+// which are most likely misspelled, such as a category for zh-Hant when translating to zh-Hans.
+func warnUnusedCategories(log *logrus.Logger, categories map[string]string, targetLanguages []string) {
+	languages := make([]string, 0, len(categories))
+	for language := range categories {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		used := false
+		for _, target := range targetLanguages {
+			used = used || strings.EqualFold(language, target)
+		}
+		if !used {
+			log.Warnf("The category %s is configured for %s, which is not among the target languages %s, it is not used", categories[language], language, strings.Join(targetLanguages, ","))
+		}
+	}
+}
 
+// validateServiceInputs checks that the Translator service settings of a configuration are provided.
+// The key and the region are not required with a Microsoft Entra ID credential.
+// It returns an error listing the missing arguments, if any.
+func validateServiceInputs(config translator.TranslatorConfig) error {
+	missingArgs := []string{}
+	if config.TranslatorEndpoint == "" {
+		missingArgs = append(missingArgs, "endpoint")
+	}
+	if config.TranslatorKey == "" && config.Credential.UsesKeys() {
+		missingArgs = append(missingArgs, "key")
+	}
+	if config.TranslatorRegion == "" && config.Credential.UsesKeys() {
+		missingArgs = append(missingArgs, "region")
+	}
+	if len(missingArgs) > 0 {
+		return fmt.Errorf("missing required arguments: %s", strings.Join(missingArgs, ", "))
+	}
 	return nil
 }
 
 // validateInputs checks if all the required inputs are provided.
+// The blob storage inputs are only required when syncMode is false,
+// and the keys are not required with a Microsoft Entra ID credential.
 // It returns an error if any required input is missing.
-func validateInputs(endpoint, key, region, in, out, to, blobAccount, blobAccountKey, blobContainer string) error {
+func validateInputs(config translator.TranslatorConfig, in, out, to string, syncMode bool) error {
+	useKeys := config.Credential.UsesKeys()
 	missingArgs := []string{}
-	if endpoint == "" {
+	if config.TranslatorEndpoint == "" {
 		missingArgs = append(missingArgs, "endpoint")
 	}
-	if key == "" {
+	if config.TranslatorKey == "" && useKeys {
 		missingArgs = append(missingArgs, "key")
 	}
-	if region == "" {
+	if config.TranslatorRegion == "" && useKeys {
 		missingArgs = append(missingArgs, "region")
 	}
 	if in == "" {
@@ -148,13 +439,13 @@ func validateInputs(endpoint, key, region, in, out, to, blobAccount, blobAccount
 	if to == "" {
 		missingArgs = append(missingArgs, "to")
 	}
-	if blobAccount == "" {
+	if config.BlobAccountName == "" && !syncMode {
 		missingArgs = append(missingArgs, "blobAccount")
 	}
-	if blobAccountKey == "" {
+	if config.BlobAccountKey == "" && useKeys && !syncMode {
 		missingArgs = append(missingArgs, "blobAccountKey")
 	}
-	if blobContainer == "" {
+	if config.BlobContainerName == "" && !syncMode {
 		missingArgs = append(missingArgs, "blobContainer")
 	}
 
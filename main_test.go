@@ -0,0 +1,419 @@
+/*
+Copyright (c) Ronan LE MEILLAT 2024
+Licensed under the AGPLv3 License
+https://www.gnu.org/licenses/agpl-3.0.html
+
+This file contains test functions for the command-line argument parsing, the configuration file and progress display.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"translator/internal/translator"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestParseCategories is a test function that tests the parseCategories function.
+func TestParseCategories(t *testing.T) {
+	categories, err := parseCategories("fr=abc123, de=def456, zh-Hans=ghi789")
+	if err != nil {
+		t.Fatalf("parseCategories failed: %v", err)
+	}
+	if expected := map[string]string{"fr": "abc123", "de": "def456", "zh-hans": "ghi789"}; !reflect.DeepEqual(categories, expected) {
+		t.Errorf("parseCategories returned %v, expected %v", categories, expected)
+	}
+
+	for _, invalid := range []string{"fr", "fr=", "=abc123"} {
+		if _, err := parseCategories(invalid); err == nil {
+			t.Errorf("parseCategories(%q) succeeded, expected an error", invalid)
+		}
+	}
+}
+
+// TestWarnUnusedCategories is a test function that checks that only the categories of the languages
+// that are not among the target languages are reported, whatever their case.
+func TestWarnUnusedCategories(t *testing.T) {
+	var out bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&out)
+	warnUnusedCategories(log, map[string]string{"fr": "abc123", "zh-hans": "def456", "zh-hant": "ghi789"}, []string{"FR", "zh-Hans"})
+	if warnings := out.String(); strings.Count(warnings, "level=warning") != 1 || !strings.Contains(warnings, "ghi789 is configured for zh-hant") {
+		t.Errorf("warnUnusedCategories logged:\n%s\nexpected a single warning for zh-hant", warnings)
+	}
+}
+
+// TestFormatBytes is a test function that tests the formatBytes function.
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 40 << 20: "40.0 MiB", 3 << 30: "3.0 GiB"}
+	for n, expected := range tests {
+		if s := formatBytes(n); s != expected {
+			t.Errorf("formatBytes(%d) = %q, expected %q", n, s, expected)
+		}
+	}
+}
+
+// TestProgressDisplay is a test function that checks that the progress line is redrawn below the log lines.
+func TestProgressDisplay(t *testing.T) {
+	var out bytes.Buffer
+	display := &progressDisplay{out: &out}
+	display.Progress(translator.ProgressEvent{Kind: translator.ProgressUpload, Name: "deck.pptx", Bytes: 10 << 20, Total: 40 << 20})
+	display.Write([]byte("log line\n"))
+	display.Progress(translator.ProgressEvent{Kind: translator.ProgressStatus, JobID: "job1", Status: translator.StatusRunning, Summary: translator.StatusSummary{Total: 2, Success: 1}})
+	display.Progress(translator.ProgressEvent{Kind: translator.ProgressCharged, JobID: "job1", Characters: 1200})
+	display.Close()
+
+	expected := "\r\033[KUploading deck.pptx 10.0 MiB / 40.0 MiB  25%" +
+		"\r\033[Klog line\n\r\033[KUploading deck.pptx 10.0 MiB / 40.0 MiB  25%" +
+		"\r\033[KTranslation job job1 Running: 1/2 documents translated, 0 failed" +
+		"\r\033[KTranslation job job1 Running: 1/2 documents translated, 0 failed, 1200 characters charged" +
+		"\r\033[K"
+	if out.String() != expected {
+		t.Errorf("progressDisplay wrote %q, expected %q", out.String(), expected)
+	}
+}
+
+// TestConfigSecretReferences is a test function that resolves the secret references of a configuration file,
+// and refuses them when the file is readable by its group or others.
+func TestConfigSecretReferences(t *testing.T) {
+	t.Setenv("TRANSLATOR_TEST_KEY", "referenced-key")
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"translatorKey": "env:TRANSLATOR_TEST_KEY", "blobAccountKey": "cmd:echo blob-key"}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	load := func() (translator.TranslatorConfig, error) {
+		fs := flag.NewFlagSet("translator", flag.ContinueOnError)
+		service := defineServiceFlags(fs, allServiceFlags)
+		fs.Parse([]string{"-config", path, "-jobStore", ""})
+		return service.config(logrus.New())
+	}
+
+	if _, err := load(); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+		t.Errorf("config of a world-readable file returned %v, expected a permission error", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := load()
+	if err != nil {
+		t.Fatalf("config failed: %v", err)
+	}
+	if config.TranslatorKey != "referenced-key" || config.BlobAccountKey != "blob-key" {
+		t.Errorf("config resolved the keys %q and %q", config.TranslatorKey, config.BlobAccountKey)
+	}
+	if redacted := redactor.Redact("keys referenced-key and blob-key"); redacted != "keys REDACTED and REDACTED" {
+		t.Errorf("the resolved secrets are not redacted: %s", redacted)
+	}
+}
+
+// TestLayeredConfig is a test function that merges the defaults, a configuration file and its profile,
+// the environment and the flags, and prints the effective configuration with the source of each value.
+func TestLayeredConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+		"translatorEndpoint": "https://file.example/",
+		"translatorKey": "file-key",
+		"translatorRegion": "",
+		"timeout": 120,
+		"verbose": false,
+		"categories": {"fr": "file-fr", "de": "file-de"},
+		"profiles": {
+			"prod-westeurope": {"translatorRegion": "westeurope", "sas": {"expiry": "2h"}, "categories": {"DE": "profile-de"}}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envTranslatorKey, "env-key")
+	t.Setenv(envTranslatorRegion, "")
+	t.Setenv(envTranslatorEndpoint, "")
+	load := func(args ...string) (*serviceFlags, translator.TranslatorConfig, error) {
+		fs := flag.NewFlagSet("translator", flag.ContinueOnError)
+		service := defineServiceFlags(fs, allServiceFlags)
+		fs.Parse(append([]string{"-config", path, "-jobStore", ""}, args...))
+		config, err := service.config(logrus.New())
+		return service, config, err
+	}
+
+	service, config, err := load("-profile", "prod-westeurope", "-v", "-category", "FR=flag-fr", "-blockSize", "1024")
+	if err != nil {
+		t.Fatalf("config failed: %v", err)
+	}
+	if config.TranslatorEndpoint != "https://file.example/" || config.TranslatorKey != "env-key" || config.TranslatorRegion != "westeurope" {
+		t.Errorf("config returned the endpoint %q, key %q and region %q", config.TranslatorEndpoint, config.TranslatorKey, config.TranslatorRegion)
+	}
+	if config.Timeout != 120 || !config.Verbose || config.SAS.Expiry != translator.Duration(2*time.Hour) {
+		t.Errorf("config returned the timeout %d, verbose %v and SAS expiry %v", config.Timeout, config.Verbose, config.SAS.Expiry)
+	}
+	if config.Categories["fr"] != "flag-fr" || config.Categories["de"] != "profile-de" {
+		t.Errorf("config returned the categories %v", config.Categories)
+	}
+	if config.Transfer.BlockSize != 1024 || config.Transfer.Parallelism != translator.DefaultParallelism || config.Retry != translator.DefaultRetryPolicy {
+		t.Errorf("config returned the transfer options %+v and retry policy %+v", config.Transfer, config.Retry)
+	}
+
+	layered, err := service.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printConfig(&out, layered)
+	printed := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		columns := regexp.MustCompile(`\s{2,}`).Split(line, 3)
+		printed[columns[0]] = strings.Join(columns[1:], " | ")
+	}
+	for key, expected := range map[string]string{
+		"translatorEndpoint": "https://file.example/ | file " + path,
+		"translatorKey":      "REDACTED | env TRANSLATOR_KEY",
+		"translatorRegion":   "westeurope | profile prod-westeurope of " + path,
+		"timeout":            "120 | file " + path,
+		"verbose":            "true | flag -v",
+		"transfer.blockSize": "1024 | flag -blockSize",
+		"retry.baseDelay":    "1s | default",
+		"categories.de":      "profile-de | profile prod-westeurope of " + path,
+		"categories.fr":      "flag-fr | flag -category",
+	} {
+		if printed[key] != expected {
+			t.Errorf("config show printed %s as %q, expected %q", key, printed[key], expected)
+		}
+	}
+	if strings.Contains(out.String(), "env-key") {
+		t.Errorf("config show printed the key:\n%s", out.String())
+	}
+
+	if _, config, err := load(); err != nil || config.Verbose || config.TranslatorRegion != "" || config.Timeout != 120 {
+		t.Errorf("config without profile returned %+v, %v", config, err)
+	}
+	if _, _, err := load("-profile", "staging"); err == nil || !strings.Contains(err.Error(), "prod-westeurope") {
+		t.Errorf("config of an unknown profile returned %v, expected the list of the profiles", err)
+	}
+}
+
+// TestConfigFileFormats is a test function that reads the same configuration from JSON, YAML and TOML files.
+func TestConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{
+			"$schema": "./config.schema.json",
+			"translatorEndpoint": "https://translator.example/",
+			"timeout": 1.2e2,
+			"verbose": true,
+			"sas": {"expiry": "2h"},
+			"retry": {"jitter": 0.25},
+			"categories": {"fr": "abc123"},
+			"profiles": {"prod": {"translatorRegion": "westeurope"}}
+		}`,
+		"config.yaml": `
+translatorEndpoint: https://translator.example/
+timeout: 120
+verbose: true
+sas:
+  expiry: 2h
+retry:
+  jitter: 0.25
+categories:
+  fr: abc123
+profiles:
+  prod:
+    translatorRegion: westeurope
+`,
+		"config.toml": `
+translatorEndpoint = "https://translator.example/"
+timeout = 120
+verbose = true
+retry = { jitter = 0.25 }
+
+[sas]
+expiry = "2h"
+
+[categories]
+fr = "abc123"
+
+[profiles.prod]
+translatorRegion = "westeurope"
+`,
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		fs := flag.NewFlagSet("translator", flag.ContinueOnError)
+		service := defineServiceFlags(fs, allServiceFlags)
+		fs.Parse([]string{"-config", path, "-profile", "prod", "-jobStore", ""})
+		config, err := service.config(logrus.New())
+		if err != nil {
+			t.Errorf("config of %s failed: %v", name, err)
+			continue
+		}
+		if config.TranslatorEndpoint != "https://translator.example/" || config.TranslatorRegion != "westeurope" || config.Timeout != 120 ||
+			!config.Verbose || config.SAS.Expiry != translator.Duration(2*time.Hour) || config.Retry.Jitter != 0.25 || config.Categories["fr"] != "abc123" {
+			t.Errorf("config of %s returned %+v", name, config)
+		}
+	}
+
+	if _, err := parseConfigFile("config.ini", []byte("")); err == nil {
+		t.Error("parseConfigFile accepted an .ini file")
+	}
+}
+
+// TestConfigValidate is a test function that reports the unknown keys, the values of the wrong type and the syntax errors
+// of configuration files with their line.
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{"valid.yaml", "timeout: 30\nsas:\n  expiry: 45m\n", nil},
+		{"config.json", `{
+  "translatorKye": "key",
+  "timeout": "30",
+  "sas": {
+    "expiry": "forever",
+    "ipRnage": "10.0.0.1"
+  },
+  "credential": {"type": "password"},
+  "profiles": {
+    "prod": {"verbose": "yes", "profiles": {}}
+  }
+}`, []string{
+			`2: unknown key "translatorKye"`,
+			`3: timeout must be an integer`,
+			`5: sas.expiry must be a duration such as "30s"`,
+			`6: unknown key "sas.ipRnage"`,
+			`8: credential.type must be one of "key", "clientSecret", "clientCertificate", "workloadIdentity", "managedIdentity", "azureCli"`,
+			`10: profiles.prod.verbose must be a boolean`,
+			`10: unknown key "profiles.prod.profiles"`,
+		}},
+		{"config.yaml", "timeout: 30\nretry:\n  maxAttempts: 2.5\ntransfer: 4\nglossary: terms.tsv\n", []string{
+			`3: retry.maxAttempts must be an integer`,
+			`4: transfer must be an object`,
+			`5: unknown key "glossary"`,
+		}},
+		{"config.toml", "timeout = 30\n\n[sas]\nexpiry = 45\n", []string{
+			`4: sas.expiry must be a duration such as "30s"`,
+		}},
+		{"bounds.json", `{
+  "timeout": -1,
+  "syncThreshold": 1e3,
+  "retry": {"jitter": 5, "baseDelay": "-1s"},
+  "transfer": {
+    "blockSize": 5e9,
+    "parallelism": 65
+  }
+}`, []string{
+			`2: timeout must be at least 0`,
+			`4: retry.baseDelay must be at least 0s`,
+			`4: retry.jitter must be between 0 and 1`,
+			`6: transfer.blockSize must be between 0 and 4194304000`,
+			`7: transfer.parallelism must be between 0 and 64`,
+		}},
+		{"broken.json", "{\n  \"timeout\": 30,\n}\n", []string{"3: invalid character '}' looking for beginning of object key string"}},
+		{"broken.yaml", "timeout: 30\nsas:\n\texpiry: 45m\n", []string{"3: found character that cannot start any token"}},
+		{"broken.toml", "timeout = 30\nverbose = yes\n", []string{`2: expected value but found "yes" instead`}},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err := validateConfigFile(&out, path)
+		expected := path + " is valid\n"
+		if test.expected != nil {
+			expected = path + ":" + strings.Join(test.expected, "\n"+path+":") + "\n"
+		}
+		if out.String() != expected || (err == nil) != (test.expected == nil) {
+			t.Errorf("validateConfigFile(%s) printed:\n%s\nreturned %v, expected:\n%s", test.name, out.String(), err, expected)
+		}
+	}
+}
+
+// TestConfigSchema is a test function that checks that the published JSON Schema is the one generated from the settings.
+func TestConfigSchema(t *testing.T) {
+	published, err := os.ReadFile("config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generated bytes.Buffer
+	if err := writeConfigSchema(&generated); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(published, generated.Bytes()) {
+		t.Error("config.schema.json is outdated, regenerate it with: go run . config schema > config.schema.json")
+	}
+}
+
+// TestCommands is a test function that checks that each command only defines the flags it uses,
+// and that the completion scripts complete the commands and their flags.
+func TestCommands(t *testing.T) {
+	for name, flags := range map[string]map[string]bool{
+		"translate": {"in": true, "blobAccount": true, "category": true, "jobStore": true},
+		"status":    {"documents": true, "endpoint": true, "jobStore": true, "blobAccount": false, "in": false},
+		"languages": {"endpoint": true, "blobAccount": false, "jobStore": false, "category": false},
+		"gc":        {"olderThan": true, "blobAccount": true, "endpoint": false, "keep-blobs": false, "parallelism": false, "sasExpiry": false},
+		"resume":    {"blobAccount": true, "keep-blobs": true, "parallelism": true, "category": false},
+		"version":   {"config": false},
+	} {
+		fs := findCommand(name).flagSet()
+		for flag, expected := range flags {
+			if defined := fs.Lookup(flag) != nil; defined != expected {
+				t.Errorf("the %s command defines -%s: %v, expected %v", name, flag, defined, expected)
+			}
+		}
+	}
+	if err := runCommand([]string{"translat"}); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("runCommand of an unknown command returned %v", err)
+	}
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var script bytes.Buffer
+		if err := writeCompletion(&script, shell, "translator"); err != nil {
+			t.Fatalf("writeCompletion(%s) failed: %v", shell, err)
+		}
+		for _, expected := range []string{"translator", "status", "documents", "validate", "olderThan"} {
+			if !strings.Contains(script.String(), expected) {
+				t.Errorf("the %s completion does not contain %s:\n%s", shell, expected, script.String())
+			}
+		}
+		// Check the syntax of the script if the shell is installed
+		path, err := exec.LookPath(shell)
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, "-n")
+		cmd.Stdin = &script
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("the %s completion is invalid: %v: %s", shell, err, output)
+		}
+	}
+	if err := writeCompletion(io.Discard, "powershell", "translator"); err == nil {
+		t.Error("writeCompletion accepted powershell")
+	}
+}
+
+// TestTranslateSyncFolder is a test function that checks that a synchronous translation of a directory is rejected
+// before any request, since the documents of a folder are translated through the blob storage.
+func TestTranslateSyncFolder(t *testing.T) {
+	dir := t.TempDir()
+	err := runCommand([]string{"translate", "-endpoint", "http://127.0.0.1:1", "-key", "key", "-region", "region",
+		"-in", dir, "-out", filepath.Join(dir, "out"), "-to", "fr", "-sync"})
+	if err == nil || !strings.Contains(err.Error(), "-sync cannot translate the directory") {
+		t.Errorf("translate returned %v, expected -sync to be rejected for a directory", err)
+	}
+}
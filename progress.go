@@ -0,0 +1,159 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"translator/internal/translator"
+
+	"github.com/sirupsen/logrus"
+)
+
+// isTerminal reports whether a file is a terminal.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgressReporter returns the progress reporter of the command line and a function removing its display.
+// When out is a terminal, a live progress line is drawn at the bottom of out and the log lines are written above it,
+// otherwise the progress events are logged as plain lines.
+func newProgressReporter(log *logrus.Logger, out *os.File) (translator.ProgressReporter, func()) {
+	if !isTerminal(out) {
+		return &progressLog{log: log, quarters: map[string]int64{}}, func() {}
+	}
+	display := &progressDisplay{out: out}
+	log.SetOutput(display)
+	return display, display.Close
+}
+
+// formatBytes formats a number of bytes with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatTransfer formats the progress of an upload or a download.
+func formatTransfer(verb string, event translator.ProgressEvent) string {
+	if event.Total <= 0 {
+		return fmt.Sprintf("%s %s %s", verb, event.Name, formatBytes(event.Bytes))
+	}
+	return fmt.Sprintf("%s %s %s / %s %3d%%", verb, event.Name, formatBytes(event.Bytes), formatBytes(event.Total), event.Bytes*100/event.Total)
+}
+
+// formatSummary formats the document counters of a translation job.
+func formatSummary(event translator.ProgressEvent) string {
+	summary := event.Summary
+	return fmt.Sprintf("job %s %s: %d/%d documents translated, %d failed", event.JobID, event.Status, summary.Success, summary.Total, summary.Failed)
+}
+
+// progressDisplay draws the last progress event as a line rewritten in place on a terminal.
+// It is also the output of the logger, so that the log lines are written above the progress line.
+type progressDisplay struct {
+	mu         sync.Mutex
+	out        io.Writer
+	line       string
+	characters int64
+}
+
+// Progress redraws the progress line for an event.
+func (d *progressDisplay) Progress(event translator.ProgressEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch event.Kind {
+	case translator.ProgressUpload:
+		d.line = formatTransfer("Uploading", event)
+	case translator.ProgressSubmitted:
+		d.line = fmt.Sprintf("Translation job %s submitted", event.JobID)
+	case translator.ProgressStatus:
+		d.line = "Translation " + formatSummary(event)
+	case translator.ProgressCharged:
+		d.characters = event.Characters
+	case translator.ProgressDownload:
+		d.line = formatTransfer("Downloading", event)
+	}
+	d.draw()
+}
+
+// draw rewrites the progress line, it must be called with mu held.
+func (d *progressDisplay) draw() {
+	line := d.line
+	if d.characters > 0 {
+		line = fmt.Sprintf("%s, %d characters charged", line, d.characters)
+	}
+	fmt.Fprintf(d.out, "\r\033[K%s", line)
+}
+
+// Write writes a log line above the progress line.
+func (d *progressDisplay) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprint(d.out, "\r\033[K")
+	n, err := d.out.Write(p)
+	if d.line != "" {
+		d.draw()
+	}
+	return n, err
+}
+
+// Close removes the progress line, the log lines are then written directly.
+func (d *progressDisplay) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.line != "" {
+		fmt.Fprint(d.out, "\r\033[K")
+		d.line = ""
+	}
+}
+
+// progressLog logs the progress events as plain lines.
+// The transfers are logged at every quarter of their size to keep the logs short.
+type progressLog struct {
+	mu       sync.Mutex
+	log      *logrus.Logger
+	quarters map[string]int64
+}
+
+// Progress logs an event.
+func (l *progressLog) Progress(event translator.ProgressEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch event.Kind {
+	case translator.ProgressUpload, translator.ProgressDownload:
+		if event.Total <= 0 {
+			return
+		}
+		key := event.Kind.String() + " " + event.Name
+		quarter := event.Bytes * 4 / event.Total
+		if last, ok := l.quarters[key]; ok && quarter <= last {
+			return
+		}
+		l.quarters[key] = quarter
+		verb := "Uploading"
+		if event.Kind == translator.ProgressDownload {
+			verb = "Downloading"
+		}
+		l.log.Info(formatTransfer(verb, event))
+	case translator.ProgressSubmitted:
+		l.log.Infof("Translation job %s submitted", event.JobID)
+	case translator.ProgressStatus:
+		if event.Summary.Total > 0 {
+			l.log.Info("Translation " + formatSummary(event))
+		}
+	case translator.ProgressCharged:
+		l.log.Infof("Translation job %s: %d characters charged", event.JobID, event.Characters)
+	}
+}
@@ -0,0 +1,92 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// durationPattern matches the durations accepted by time.ParseDuration, such as "1m30s".
+const durationPattern = `^[-+]?(0|([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+$`
+
+// configSchema returns the JSON Schema of the configuration files, generated from the settings.
+// It is published as config.schema.json, regenerated with "translator config schema > config.schema.json".
+func configSchema() map[string]interface{} {
+	root := map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	for _, s := range settings {
+		// Create the objects of the sections of the setting, such as sas
+		object := root
+		path := strings.Split(s.key, ".")
+		for _, name := range path[:len(path)-1] {
+			properties := object["properties"].(map[string]interface{})
+			child, ok := properties[name].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}, "additionalProperties": false}
+				properties[name] = child
+			}
+			object = child
+		}
+		object["properties"].(map[string]interface{})[path[len(path)-1]] = settingSchema(s)
+	}
+
+	return map[string]interface{}{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "translator configuration",
+		"description": "Configuration file of translator, in JSON, YAML or TOML",
+		"$ref":        "#/$defs/settings",
+		"properties": map[string]interface{}{
+			schemaKey: map[string]interface{}{"type": "string", "description": "JSON Schema of the file"},
+			profilesKey: map[string]interface{}{
+				"type":        "object",
+				"description": "Named profiles overriding the settings of the file, selected with -profile",
+				"additionalProperties": map[string]interface{}{
+					"$ref":                  "#/$defs/settings",
+					"unevaluatedProperties": false,
+				},
+			},
+		},
+		"unevaluatedProperties": false,
+		"$defs":                 map[string]interface{}{"settings": root},
+	}
+}
+
+// settingSchema returns the JSON Schema of the value of a setting.
+func settingSchema(s setting) map[string]interface{} {
+	schema := map[string]interface{}{"description": s.description}
+	switch s.kind {
+	case kindDuration:
+		schema["type"] = kindString
+		schema["pattern"] = durationPattern
+	default:
+		schema["type"] = s.kind
+	}
+	if len(s.values) > 0 {
+		schema["enum"] = s.values
+	}
+	// The bounds of the durations cannot be checked by a JSON Schema, only those of the numbers.
+	if s.kind == kindInteger || s.kind == kindNumber {
+		if s.minimum != nil {
+			schema["minimum"] = *s.minimum
+		}
+		if s.maximum != nil {
+			schema["maximum"] = *s.maximum
+		}
+	}
+	if s.key == categoriesKey {
+		schema["type"] = "object"
+		schema["additionalProperties"] = map[string]interface{}{"type": kindString}
+	}
+	return schema
+}
+
+// writeConfigSchema writes the indented JSON Schema of the configuration files.
+func writeConfigSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(configSchema())
+}
@@ -0,0 +1,131 @@
+/* Copyright (c) Ronan LE MEILLAT 2024
+ * Licensed under the AGPLv3 License
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"translator/internal/server"
+	"translator/internal/translator"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// shutdownTimeout is the time given to the HTTP requests in flight to complete when the server stops.
+	shutdownTimeout = 30 * time.Second
+	// readHeaderTimeout is the time given to a client to send the headers of a request.
+	readHeaderTimeout = 10 * time.Second
+)
+
+// setupServe defines the serve command, exposing the translations as a REST web service until SIGINT or SIGTERM.
+// The command returns an error if the configuration is invalid or the server cannot listen.
+func setupServe(fs *flag.FlagSet) func(args []string) error {
+	service := defineServiceFlags(fs, allServiceFlags)
+	listen := fs.String("listen", "localhost:8080", "Address the web service listens on, e.g. :8080 to listen on every interface")
+	workDir := fs.String("workDir", filepath.Join(os.TempDir(), "translator-serve"), "Directory storing the uploaded and translated documents")
+	maxUploadSize := fs.Int64("maxUploadSize", server.DefaultMaxUploadSize, "Maximum size in bytes of an uploaded document")
+	retention := fs.Duration("retention", server.DefaultRetention, "Time a finished job and its documents are kept before they are deleted")
+	maxJobs := fs.Int("maxJobs", server.DefaultMaxJobs, "Maximum number of translations running at once, the submissions beyond are rejected with 429 Too Many Requests")
+	tokenFile := fs.String("tokenFile", "", "File of the bearer tokens accepted by the web service, one per line, each client only sees the jobs of its token")
+	return func(args []string) error {
+		if err := expectArgs(fs, args, 0, ""); err != nil {
+			return err
+		}
+		options := server.Options{WorkDir: *workDir, MaxUploadSize: *maxUploadSize, Retention: *retention, MaxJobs: *maxJobs}
+		if *tokenFile != "" {
+			tokens, err := readTokens(*tokenFile)
+			if err != nil {
+				return err
+			}
+			options.Tokens = tokens
+		}
+		return serve(service, *listen, options)
+	}
+}
+
+// readTokens reads the bearer tokens of a file, one per line, the empty lines and the lines starting with # are ignored.
+// It returns an error if the file cannot be read or holds no token.
+func readTokens(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading token file: %v", err)
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, line)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("token file %s holds no token", path)
+	}
+	return tokens, nil
+}
+
+// serve serves the translations on the address listen, storing the documents in the work directory of options.
+func serve(service *serviceFlags, listen string, options server.Options) error {
+	log := logrus.New()
+	log.SetOutput(os.Stdout)
+
+	config, err := service.config(log)
+	if err != nil {
+		return err
+	}
+	if err := validateServiceInputs(config); err != nil {
+		return err
+	}
+	if len(options.Tokens) == 0 {
+		log.Warn("No token file set, any client can submit translations and download the documents of a job from its ID")
+	}
+	if config.BlobAccountName == "" {
+		log.Warn("No blob storage configured, only the documents small enough for a synchronous translation can be translated")
+	}
+
+	client, err := translator.NewClient(config)
+	if err != nil {
+		return err
+	}
+	s, err := server.New(client, options)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{Addr: listen, Handler: s.Handler(), ReadHeaderTimeout: readHeaderTimeout}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+	log.Infof("Serving translations on %s", listen)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stop accepting requests, then cancel the running translations and delete their temporary blobs
+	log.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = httpServer.Shutdown(shutdownCtx)
+	s.Shutdown()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}